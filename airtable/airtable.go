@@ -0,0 +1,217 @@
+// Package airtable is a minimal client of the Airtable Web API, listing
+// and updating the records of a table.
+package airtable
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	baseURL = "https://api.airtable.com/v0"
+	// MaxUpdate is the maximum number of records updated per request.
+	MaxUpdate  = 10
+	maxRetries = 3
+)
+
+// rateLimitWait is the time Airtable asks clients to wait once rate
+// limited.
+var rateLimitWait = 30 * time.Second
+
+// Client is an Airtable client. Initialize it using New. It is not safe
+// for concurrent use.
+type Client struct {
+	// Personal access token.
+	Token string
+	// HTTP client used to perform the requests.
+	Client *http.Client
+	// Base URL of the API. If empty, the Airtable one is used; mostly
+	// useful for testing.
+	BaseURL string
+	// Interval is the minimum time between two requests, Airtable
+	// allowing 5 requests per second per base.
+	Interval time.Duration
+
+	last time.Time
+}
+
+// New returns a client authenticated with token.
+func New(token string) *Client {
+	return &Client{
+		Token:    token,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		Interval: 200 * time.Millisecond,
+	}
+}
+
+// Record is a record of a table.
+type Record struct {
+	ID     string         `json:"id"`
+	Fields map[string]any `json:"fields"`
+}
+
+// Error is an error reported by the API.
+type Error struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("airtable: %s (%d)", e.Type, e.StatusCode)
+	}
+	return fmt.Sprintf("airtable: %s: %s", e.Type, e.Message)
+}
+
+func decodeError(r io.Reader, status int) error {
+	// The error is either an object or a bare type.
+	var res struct {
+		Error json.RawMessage `json:"error"`
+	}
+	e := &Error{StatusCode: status, Type: http.StatusText(status)}
+	if err := json.NewDecoder(r).Decode(&res); err != nil {
+		return e
+	}
+	var obj struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(res.Error, &obj); err == nil {
+		e.Type, e.Message = obj.Type, obj.Message
+	} else {
+		json.Unmarshal(res.Error, &e.Type)
+	}
+	return e
+}
+
+// do performs a request, spacing the requests by Interval, and retrying
+// those rate limited.
+func (c *Client) do(ctx context.Context, method, path string, v url.Values, body, dst any) error {
+	base := c.BaseURL
+	if base == "" {
+		base = baseURL
+	}
+	u := base + path
+	if len(v) > 0 {
+		u += "?" + v.Encode()
+	}
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; ; i++ {
+		if wait := c.Interval - time.Since(c.last); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		c.last = time.Now()
+
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(b))
+		if err != nil {
+			return fmt.Errorf("unable to build airtable request: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		hc := c.Client
+		if hc == nil {
+			hc = http.DefaultClient
+		}
+		resp, err := hc.Do(req)
+		if err != nil {
+			return fmt.Errorf("unable to contact airtable: %w", err)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && i < maxRetries {
+			resp.Body.Close()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(rateLimitWait):
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return decodeError(resp.Body, resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
+			return fmt.Errorf("unable to decode response: %w", err)
+		}
+		return nil
+	}
+}
+
+func tablePath(base, table string) string {
+	return "/" + url.PathEscape(base) + "/" + url.PathEscape(table)
+}
+
+// ListOptions select the records listed.
+type ListOptions struct {
+	// Fields are the names of the fields returned, all if empty.
+	Fields []string
+	// Formula filters the records, if set, e.g. "NOT({Image})".
+	Formula string
+	// View lists the records of a view, in its order, if set.
+	View string
+}
+
+// List returns the records of the table of base, calling fn with each
+// page of records.
+func (c *Client) List(ctx context.Context, base, table string, opts *ListOptions, fn func([]*Record) error) error {
+	v := url.Values{}
+	for _, f := range opts.Fields {
+		v.Add("fields[]", f)
+	}
+	if opts.Formula != "" {
+		v.Set("filterByFormula", opts.Formula)
+	}
+	if opts.View != "" {
+		v.Set("view", opts.View)
+	}
+	for {
+		var page struct {
+			Records []*Record `json:"records"`
+			Offset  string    `json:"offset"`
+		}
+		if err := c.do(ctx, "GET", tablePath(base, table), v, nil, &page); err != nil {
+			return err
+		}
+		if err := fn(page.Records); err != nil {
+			return err
+		}
+		if page.Offset == "" {
+			return nil
+		}
+		v.Set("offset", page.Offset)
+	}
+}
+
+// Update sets the fields of up to MaxUpdate records of the table of
+// base, leaving their other fields unchanged.
+func (c *Client) Update(ctx context.Context, base, table string, records []*Record) error {
+	if len(records) > MaxUpdate {
+		return fmt.Errorf("airtable: cannot update more than %d records at once, got %d", MaxUpdate, len(records))
+	}
+	body := struct {
+		Records []*Record `json:"records"`
+	}{records}
+	var res struct {
+		Records []*Record `json:"records"`
+	}
+	return c.do(ctx, "PATCH", tablePath(base, table), nil, body, &res)
+}
@@ -0,0 +1,103 @@
+package airtable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/app1/Words list" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected request: %v %v", r.URL, r.Header)
+		}
+		if q.Get("filterByFormula") != "NOT({Image})" || len(q["fields[]"]) != 2 {
+			t.Errorf("unexpected query: %v", q)
+		}
+		if q.Get("offset") == "" {
+			io.WriteString(w, `{"records": [{"id": "rec1", "fields": {"Name": "cat"}}], "offset": "next"}`)
+			return
+		}
+		io.WriteString(w, `{"records": [{"id": "rec2", "fields": {"Name": "dog"}}]}`)
+	}))
+	defer srv.Close()
+
+	c := New("token")
+	c.BaseURL = srv.URL
+	c.Interval = 0
+	var ids []string
+	err := c.List(context.Background(), "app1", "Words list", &ListOptions{
+		Fields:  []string{"Name", "Image"},
+		Formula: "NOT({Image})",
+	}, func(records []*Record) error {
+		for _, r := range records {
+			ids = append(ids, r.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "rec1" || ids[1] != "rec2" {
+		t.Fatalf("unexpected records: %v", ids)
+	}
+}
+
+func TestUpdateRateLimited(t *testing.T) {
+	rateLimitWait = time.Millisecond
+	defer func() { rateLimitWait = 30 * time.Second }()
+
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		var body struct {
+			Records []*Record `json:"records"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if r.Method != "PATCH" || len(body.Records) != 1 || body.Records[0].Fields["Image"] != "https://example.com/cat.jpg" {
+			t.Errorf("unexpected request: %s %+v", r.Method, body.Records)
+		}
+		io.WriteString(w, `{"records": []}`)
+	}))
+	defer srv.Close()
+
+	c := New("token")
+	c.BaseURL = srv.URL
+	err := c.Update(context.Background(), "app1", "Words", []*Record{
+		{ID: "rec1", Fields: map[string]any{"Image": "https://example.com/cat.jpg"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("got %d calls, want 2", calls)
+	}
+}
+
+func TestError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		io.WriteString(w, `{"error": {"type": "INVALID_VALUE_FOR_COLUMN", "message": "Field \"Image\" cannot accept the provided value"}}`)
+	}))
+	defer srv.Close()
+
+	c := New("token")
+	c.BaseURL = srv.URL
+	err := c.Update(context.Background(), "app1", "Words", []*Record{{ID: "rec1"}})
+	var e *Error
+	if !errors.As(err, &e) || e.Type != "INVALID_VALUE_FOR_COLUMN" || e.StatusCode != 422 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package dic
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/discursive-image/dic/google"
+)
+
+// Store persists search results across runs, such as a cache.Dir. It
+// must be safe for concurrent use.
+type Store interface {
+	Get(k string) ([]*google.ISR, bool, error)
+	Set(k string, items []*google.ISR) error
+}
+
+// Cache holds the results of each key searched, returning them in turn
+// so that the records of a key get different images. The zero value is
+// an empty cache returning all results, in their order.
+type Cache struct {
+	// Store persists the results, if set, and provides the ones of the
+	// keys not searched by the run.
+	Store Store
+	// Order, if set, returns the results of k in the order they are
+	// returned, such as ranked.
+	Order func(k string, results []*google.ISR) []*google.ISR
+	// Start, if set, returns the index of the first result returned out
+	// of n, 0 otherwise.
+	Start func(n int) int
+	// Unique is set to return each link once, whatever the key.
+	Unique bool
+	// OnError, if set, is called with the errors of the store, which do
+	// not fail lookups.
+	OnError func(k string, err error)
+
+	mu      sync.Mutex
+	m       map[string]*ring
+	emitted map[string]bool
+	// verdicts are the checks of the results by Validate, by key and
+	// link.
+	verdicts map[[2]string]verdict
+}
+
+// verdict is the check of a result, along with the result returned in
+// its place.
+type verdict struct {
+	isr *google.ISR
+	ok  bool
+}
+
+type ring struct {
+	all   []*google.ISR
+	index int
+}
+
+func (c *Cache) newRing(k string, results []*google.ISR) *ring {
+	if c.Order != nil {
+		results = c.Order(k, results)
+	}
+	r := &ring{all: results}
+	if c.Start != nil && len(results) > 0 {
+		r.index = c.Start(len(results))
+	}
+	return r
+}
+
+// next returns the next result of r, nil if it has none.
+func (r *ring) next() *google.ISR {
+	if len(r.all) == 0 {
+		return nil
+	}
+	isr := r.all[r.index]
+	r.index = (r.index + 1) % len(r.all)
+	return isr
+}
+
+func (c *Cache) onError(k string, err error) {
+	if c.OnError != nil {
+		c.OnError(k, err)
+	}
+}
+
+// Next returns the next result of k, and whether there is one: false if
+// k was not searched, by the run or a previous one as told by the store,
+// or if all of its results were returned already, when c.Unique is set.
+func (c *Cache) Next(k string) (*google.ISR, bool) {
+	c.mu.Lock()
+	_, ok := c.m[k]
+	c.mu.Unlock()
+	if !ok && c.Store != nil {
+		// Fallback on the persistent store, whose results are ordered
+		// without holding the lock.
+		results, found, err := c.Store.Get(k)
+		if err != nil {
+			c.onError(k, fmt.Errorf("unable to read cache: %w", err))
+		}
+		if found {
+			c.swap(k, c.newRing(k, results), false)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.m[k]
+	if !ok {
+		return nil, false
+	}
+	isr := r.next()
+	// Links returned already are skipped, each one being returned
+	// once per turn of the ring.
+	for i := 1; isr != nil && c.Unique && c.emitted[isr.Link]; i++ {
+		isr = nil
+		if i < len(r.all) {
+			isr = r.next()
+		}
+	}
+	if isr == nil {
+		// Nothing is left of the ring, delete it.
+		delete(c.m, k)
+		return nil, false
+	}
+	if c.Unique {
+		if c.emitted == nil {
+			c.emitted = make(map[string]bool)
+		}
+		c.emitted[isr.Link] = true
+	}
+	return isr, true
+}
+
+// Set records the results of k, persisting them if c.Store is set. They
+// are ordered without holding the lock of c.
+func (c *Cache) Set(k string, results []*google.ISR) {
+	c.swap(k, c.newRing(k, results), true)
+	if c.Store != nil {
+		if err := c.Store.Set(k, results); err != nil {
+			c.onError(k, fmt.Errorf("unable to write cache: %w", err))
+		}
+	}
+}
+
+// swap records r as the ring of k, replacing the current one if
+// replace is set.
+func (c *Cache) swap(k string, r *ring, replace bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]*ring)
+	}
+	if _, ok := c.m[k]; ok && !replace {
+		return
+	}
+	c.m[k] = r
+}
+
+// verdict returns the check of the result of k linking to link, and
+// whether it was checked.
+func (c *Cache) verdict(k, link string) (verdict, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.verdicts[[2]string{k, link}]
+	return v, ok
+}
+
+func (c *Cache) setVerdict(k, link string, v verdict) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.verdicts == nil {
+		c.verdicts = make(map[[2]string]verdict)
+	}
+	c.verdicts[[2]string{k, link}] = v
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+)
+
+// Entry is a cached search result.
+type Entry struct {
+	Key     string        `json:"key"`
+	Created time.Time     `json:"created"`
+	Items   []*google.ISR `json:"items"`
+}
+
+// Expired reports whether the entry is older than ttl. A ttl of 0
+// means that entries never expire.
+func (e *Entry) Expired(ttl time.Duration) bool {
+	return ttl > 0 && time.Since(e.Created) > ttl
+}
+
+// Dir is a cache that persists search results on disk, one JSON
+// file per key. Initialize it using NewDir.
+type Dir struct {
+	// Directory containing the cache entries.
+	Path string
+	// Time after which an entry is considered stale. 0 means forever.
+	TTL time.Duration
+}
+
+// NewDir returns a new disk cache rooted at path, creating the
+// directory if needed.
+func NewDir(path string, ttl time.Duration) (*Dir, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create cache directory: %w", err)
+	}
+	return &Dir{
+		Path: path,
+		TTL:  ttl,
+	}, nil
+}
+
+func (d *Dir) filename(k string) string {
+	h := sha256.Sum256([]byte(k))
+	return filepath.Join(d.Path, hex.EncodeToString(h[:])+".json")
+}
+
+// Get returns the items stored under k. The boolean is false when
+// the key is not present or the entry is expired.
+func (d *Dir) Get(k string) ([]*google.ISR, bool, error) {
+	e, err := readEntry(d.filename(k))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if e.Key != k || e.Expired(d.TTL) {
+		return nil, false, nil
+	}
+	return e.Items, true, nil
+}
+
+func readEntry(path string) (*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open cache entry: %w", err)
+	}
+	defer f.Close()
+
+	var e Entry
+	if err := json.NewDecoder(f).Decode(&e); err != nil {
+		return nil, fmt.Errorf("unable to decode cache entry: %w", err)
+	}
+	return &e, nil
+}
+
+// Set stores items under k, replacing any previous entry.
+func (d *Dir) Set(k string, items []*google.ISR) error {
+	return d.Put(&Entry{
+		Key:     k,
+		Created: time.Now(),
+		Items:   items,
+	})
+}
+
+// Put stores e, replacing any previous entry with the same key. Unlike
+// Set, the entry creation time is preserved.
+func (d *Dir) Put(e *Entry) error {
+
+	// Write to a temporary file first so that concurrent readers
+	// never see a partially written entry.
+	tmp, err := os.CreateTemp(d.Path, ".entry-*")
+	if err != nil {
+		return fmt.Errorf("unable to create cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(e); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to encode cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), d.filename(e.Key)); err != nil {
+		return fmt.Errorf("unable to store cache entry: %w", err)
+	}
+	return nil
+}
+
+// Ping checks that entries can be written to the cache directory.
+func (d *Dir) Ping() error {
+	tmp, err := os.CreateTemp(d.Path, ".ping-*")
+	if err != nil {
+		return fmt.Errorf("unable to write to cache directory: %w", err)
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// Delete removes the entry stored under k, if any.
+func (d *Dir) Delete(k string) error {
+	err := os.Remove(d.filename(k))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to delete cache entry: %w", err)
+	}
+	return nil
+}
+
+// Walk calls f for each entry in the cache, expired ones included.
+// Entries that cannot be decoded are skipped. Walk stops at the
+// first error returned by f.
+func (d *Dir) Walk(f func(path string, e *Entry) error) error {
+	paths, err := filepath.Glob(filepath.Join(d.Path, "*.json"))
+	if err != nil {
+		return fmt.Errorf("unable to list cache entries: %w", err)
+	}
+	for _, p := range paths {
+		e, err := readEntry(p)
+		if err != nil {
+			continue
+		}
+		if err := f(p, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune removes the expired entries, or all of them if all is true.
+// Returns the number of entries removed.
+func (d *Dir) Prune(all bool) (int, error) {
+	var n int
+	err := d.Walk(func(p string, e *Entry) error {
+		if !all && !e.Expired(d.TTL) {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			return fmt.Errorf("unable to delete cache entry: %w", err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+)
+
+func TestDirGetSet(t *testing.T) {
+	d, err := NewDir(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := d.Get("cats"); err != nil || ok {
+		t.Fatalf("unexpected hit on empty cache: %v, %v", ok, err)
+	}
+
+	items := []*google.ISR{{Link: "https://example.com/cat.jpg"}}
+	if err := d.Set("cats", items); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := d.Get("cats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("expected cache hit")
+	}
+	if len(got) != 1 || got[0].Link != items[0].Link {
+		t.Fatalf("unexpected items: %+v", got)
+	}
+}
+
+func TestDirTTL(t *testing.T) {
+	d, err := NewDir(t.TempDir(), time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Set("cats", []*google.ISR{{Link: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, ok, _ := d.Get("cats"); ok {
+		t.Fatalf("expected expired entry to be ignored")
+	}
+}
+
+func TestDirPing(t *testing.T) {
+	d, err := NewDir(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	d.Path = filepath.Join(d.Path, "missing")
+	if err := d.Ping(); err == nil {
+		t.Fatalf("expected error on missing directory")
+	}
+}
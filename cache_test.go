@@ -0,0 +1,119 @@
+package dic
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+)
+
+func results(links ...string) []*google.ISR {
+	items := make([]*google.ISR, len(links))
+	for i, l := range links {
+		items[i] = &google.ISR{Link: l}
+	}
+	return items
+}
+
+func TestCacheRotates(t *testing.T) {
+	c := &Cache{}
+	if _, ok := c.Next("k"); ok {
+		t.Fatal("got a result for a key not set")
+	}
+	c.Set("k", results("a", "b", "c"))
+	var got []string
+	for i := 0; i < 4; i++ {
+		isr, ok := c.Next("k")
+		if !ok {
+			t.Fatalf("no result at lookup %d", i)
+		}
+		got = append(got, isr.Link)
+	}
+	if want := "a b c a"; strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+func TestCacheUnique(t *testing.T) {
+	c := &Cache{Unique: true}
+	c.Set("k1", results("a", "b"))
+	c.Set("k2", results("a", "c"))
+	for _, want := range []struct{ k, link string }{{"k1", "a"}, {"k2", "c"}, {"k1", "b"}} {
+		isr, ok := c.Next(want.k)
+		if !ok || isr.Link != want.link {
+			t.Fatalf("%s: got %v, want %s", want.k, isr, want.link)
+		}
+	}
+	if isr, ok := c.Next("k2"); ok {
+		t.Fatalf("got %s, want none left", isr.Link)
+	}
+}
+
+type mapStore map[string][]*google.ISR
+
+func (s mapStore) Get(k string) ([]*google.ISR, bool, error) {
+	items, ok := s[k]
+	return items, ok, nil
+}
+
+func (s mapStore) Set(k string, items []*google.ISR) error {
+	s[k] = items
+	return nil
+}
+
+func TestCacheStore(t *testing.T) {
+	s := mapStore{"k": results("a", "b")}
+	c := &Cache{
+		Store: s,
+		Order: func(k string, items []*google.ISR) []*google.ISR {
+			reversed := slices.Clone(items)
+			slices.Reverse(reversed)
+			return reversed
+		},
+	}
+	if isr, ok := c.Next("k"); !ok || isr.Link != "b" {
+		t.Fatalf("got %v, want b from the store", isr)
+	}
+	c.Set("j", results("c"))
+	if _, ok := s["j"]; !ok {
+		t.Error("results not persisted")
+	}
+}
+
+func TestCacheOrderUnlocked(t *testing.T) {
+	release := make(chan struct{})
+	c := &Cache{
+		Order: func(k string, items []*google.ISR) []*google.ISR {
+			if k == "slow" {
+				<-release
+			}
+			return items
+		},
+	}
+	c.Set("k", results("a"))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Set("slow", results("b"))
+	}()
+	got := make(chan string, 1)
+	go func() {
+		isr, _ := c.Next("k")
+		got <- isr.Link
+	}()
+	select {
+	case link := <-got:
+		if link != "a" {
+			t.Errorf("got %s, want a", link)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lookup blocked by the ordering of another key")
+	}
+	close(release)
+	<-done
+	if isr, ok := c.Next("slow"); !ok || isr.Link != "b" {
+		t.Errorf("got %v, want b", isr)
+	}
+}
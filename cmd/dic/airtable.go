@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/discursive-image/dic/airtable"
+)
+
+const envAirtableToken = "AIRTABLE_TOKEN"
+
+// airtableTable is a table given as airtable://<base id>/<table>, the
+// table being named or given by id.
+type airtableTable struct {
+	base, table string
+	// word is the field searched, and link the field where the links
+	// are written, as attachments if attachment is set.
+	word       string
+	link       string
+	attachment bool
+	// view and formula select the records processed, formula defaulting
+	// to the records with a word but no link.
+	view    string
+	formula string
+}
+
+func isAirtableURL(s string) bool {
+	return strings.HasPrefix(s, "airtable://")
+}
+
+// parseURL sets the base and table of t from s.
+func (t *airtableTable) parseURL(s string) error {
+	base, table, _ := strings.Cut(strings.TrimPrefix(s, "airtable://"), "/")
+	table, err := url.PathUnescape(table)
+	if err != nil {
+		return fmt.Errorf("invalid airtable url %q: %w", s, err)
+	}
+	if base == "" || table == "" {
+		return fmt.Errorf("invalid airtable url %q, expected airtable://<base id>/<table>", s)
+	}
+	t.base, t.table = base, table
+	return nil
+}
+
+// field returns the reference to the field called name in formulas.
+func field(name string) string {
+	return "{" + name + "}"
+}
+
+// openAirtable returns the records of the in table selected by t as csv
+// records holding the record id and its word.
+func openAirtable(ctx context.Context, c *airtable.Client, in string, t airtableTable) (io.ReadCloser, error) {
+	if err := t.parseURL(in); err != nil {
+		return nil, err
+	}
+	formula := t.formula
+	if formula == "" {
+		formula = fmt.Sprintf("AND(%s, NOT(%s))", field(t.word), field(t.link))
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	err := c.List(ctx, t.base, t.table, &airtable.ListOptions{
+		Fields:  []string{t.word},
+		Formula: formula,
+		View:    t.view,
+	}, func(records []*airtable.Record) error {
+		for _, r := range records {
+			var word string
+			if v, ok := r.Fields[t.word]; ok {
+				word = fmt.Sprint(v)
+			}
+			w.Write([]string{r.ID, word})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list airtable records: %w", err)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(&buf), nil
+}
+
+// airtableOutput writes the links of the records written to it to the
+// records of a table, whose id is the first field of the records.
+type airtableOutput struct {
+	*recordSink
+}
+
+func openAirtableOutput(c *airtable.Client, out string, t airtableTable) (*airtableOutput, error) {
+	if err := t.parseURL(out); err != nil {
+		return nil, err
+	}
+	o := &airtableOutput{}
+	o.recordSink = newRecordSink(func(recs <-chan []string) error {
+		return o.write(c, recs, &t)
+	})
+	return o, nil
+}
+
+// write updates the record of each record of recs, by batches of
+// airtable.MaxUpdate. The records are written even if the run is
+// canceled, as their search is complete.
+func (o *airtableOutput) write(c *airtable.Client, recs <-chan []string, t *airtableTable) error {
+	ctx := context.Background()
+	var batch []*airtable.Record
+	update := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := c.Update(ctx, t.base, t.table, batch); err != nil {
+			return fmt.Errorf("unable to update airtable records: %w", err)
+		}
+		logger.Debug("airtable records updated", "table", t.table, "records", len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	for rec := range recs {
+		if len(rec) < 2 {
+			return fmt.Errorf("airtable record id missing")
+		}
+		var link any = rec[len(rec)-1]
+		if t.attachment {
+			link = []map[string]string{{"url": rec[len(rec)-1]}}
+		}
+		batch = append(batch, &airtable.Record{
+			ID:     rec[0],
+			Fields: map[string]any{t.link: link},
+		})
+		if len(batch) == airtable.MaxUpdate {
+			if err := update(); err != nil {
+				return err
+			}
+		}
+	}
+	return update()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// amqpQueue is a queue backed by AMQP queues, such as RabbitMQ ones.
+// Messages are acknowledged once their result is confirmed by the
+// broker. Those failing permanently, or failing again once delivered
+// again, are dead-lettered by the broker; the others are requeued.
+type amqpQueue struct {
+	conn *amqp.Connection
+	ch   *amqp.Channel
+	msgs <-chan amqp.Delivery
+	out  string
+}
+
+func newAMQPQueue(url, in, out, deadLetter, consumer string, prefetch int) (*amqpQueue, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to amqp broker: %w", err)
+	}
+	q := &amqpQueue{conn: conn, out: out}
+	if err := q.setup(in, deadLetter, consumer, prefetch); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *amqpQueue) setup(in, deadLetter, consumer string, prefetch int) error {
+	ch, err := q.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("unable to open channel: %w", err)
+	}
+	q.ch = ch
+	// Results are published with confirmations, so that the messages
+	// are only acknowledged once their result is safe.
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("unable to enable publisher confirms: %w", err)
+	}
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		return fmt.Errorf("unable to set prefetch count: %w", err)
+	}
+
+	// The queues are declared as expected if missing; declaring an
+	// existing queue with other arguments fails.
+	if _, err := ch.QueueDeclare(deadLetter, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("unable to declare queue %q: %w", deadLetter, err)
+	}
+	if _, err := ch.QueueDeclare(in, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": deadLetter,
+	}); err != nil {
+		return fmt.Errorf("unable to declare queue %q: %w", in, err)
+	}
+	q.msgs, err = ch.Consume(in, consumer, false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("unable to consume queue %q: %w", in, err)
+	}
+	return nil
+}
+
+func (q *amqpQueue) receive(ctx context.Context) (*delivery, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case msg, ok := <-q.msgs:
+		if !ok {
+			return nil, errors.New("amqp channel closed")
+		}
+		return &delivery{
+			body: msg.Body,
+			reply: func(ctx context.Context, m *queryMessage, res []byte) error {
+				if err := q.publish(ctx, &msg, m, res); err != nil {
+					msg.Nack(false, true)
+					return err
+				}
+				return msg.Ack(false)
+			},
+			fail: func(ctx context.Context, m *queryMessage, res []byte, err error) error {
+				if !permanent(err) && !msg.Redelivered {
+					// Try again, possibly on another worker.
+					return msg.Nack(false, true)
+				}
+				if err := q.publish(ctx, &msg, m, res); err != nil {
+					msg.Nack(false, true)
+					return err
+				}
+				logger.Warn("dead-lettering message", "query", m.Query, "error", err)
+				return msg.Nack(false, false)
+			},
+		}, nil
+	}
+}
+
+// publish sends the result of msg to its reply queue if any, or else to
+// the one of m, or else to the output queue, and waits for the broker
+// to confirm it.
+func (q *amqpQueue) publish(ctx context.Context, msg *amqp.Delivery, m *queryMessage, res []byte) error {
+	out := q.out
+	switch {
+	case msg.ReplyTo != "":
+		out = msg.ReplyTo
+	case m.ReplyTo != "":
+		out = m.ReplyTo
+	}
+	conf, err := q.ch.PublishWithDeferredConfirmWithContext(ctx, "", out, false, false, amqp.Publishing{
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: msg.CorrelationId,
+		Body:          res,
+	})
+	if err != nil {
+		return err
+	}
+	ok, err := conf.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("result not confirmed by the broker")
+	}
+	return nil
+}
+
+func (q *amqpQueue) close() error {
+	return q.conn.Close()
+}
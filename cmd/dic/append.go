@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// readKeys returns the keys, field field of the records, of the output
+// file at path, which may not exist yet. A last record left incomplete
+// by a run that died is ignored, as openAppend drops it.
+func readKeys(path string, field int) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open output file: %w", err)
+	}
+	defer f.Close()
+	n, err := completeSize(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read output file: %w", err)
+	}
+
+	r := csv.NewReader(io.NewSectionReader(f, 0, n))
+	r.FieldsPerRecord = -1
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read output file: %w", err)
+		}
+		if field < len(rec) {
+			keys[rec[field]] = true
+		}
+	}
+}
+
+// openAppend opens the output file at path for appending, creating it
+// if needed. A last record left incomplete by a run that died is
+// dropped.
+func openAppend(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open output file: %w", err)
+	}
+	n, err := completeSize(f)
+	if err == nil && n < fileSize(f) {
+		logger.Warn("dropping the incomplete last record of the output file", "path", path)
+		err = f.Truncate(n)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to repair output file: %w", err)
+	}
+	return f, nil
+}
+
+func fileSize(f *os.File) int64 {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// completeSize returns the size of f up to its last newline.
+func completeSize(f *os.File) (int64, error) {
+	const chunk = 4096
+	buf := make([]byte, chunk)
+	for end := fileSize(f); end > 0; {
+		start := max(end-chunk, 0)
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// skipReader filters out the csv records of r whose field field is one
+// of keys.
+type skipReader struct {
+	*io.PipeReader
+	r io.ReadCloser
+}
+
+func skipKeys(r io.ReadCloser, field int, keys map[string]bool) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		csvr := csv.NewReader(r)
+		csvr.FieldsPerRecord = -1
+		w := csv.NewWriter(pw)
+		skipped := 0
+		for {
+			rec, err := csvr.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if field < len(rec) && keys[rec[field]] {
+				skipped++
+				continue
+			}
+			if err := w.Write(rec); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		w.Flush()
+		logger.Info("records already in the output skipped", "count", skipped)
+		pw.CloseWithError(w.Error())
+	}()
+	return &skipReader{PipeReader: pr, r: r}
+}
+
+func (s *skipReader) Close() error {
+	s.PipeReader.Close()
+	return s.r.Close()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeTemp(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out.csv")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCompleteSize(t *testing.T) {
+	long := strings.Repeat("x", 5000)
+	for _, tt := range []struct {
+		content string
+		want    int64
+	}{
+		{"", 0},
+		{"cat", 0},
+		{"cat\n", 4},
+		{"cat\ndog", 4},
+		{"cat\ndog\n", 8},
+		{"cat\n" + long, 4},
+		{long + "\n" + long, 5001},
+	} {
+		f, err := os.Open(writeTemp(t, tt.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := completeSize(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%.10q: got %d, want %d", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestReadKeys(t *testing.T) {
+	for _, tt := range []struct {
+		content string
+		field   int
+		want    string
+	}{
+		{"", 0, ""},
+		{"cat,http://a\ndog,http://b\n", 0, "cat dog"},
+		{"cat,http://a\ndog,http://b\n", 1, "http://a http://b"},
+		{"cat,http://a\ndog,http://b\n", 2, ""},
+		{"cat,http://a\ndog\n", 1, "http://a"},
+		{"cat,http://a\ndog,htt", 0, "cat"},
+		{"\"red, apple\",http://a\n", 0, "red, apple"},
+	} {
+		keys, err := readKeys(writeTemp(t, tt.content), tt.field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for k := range keys {
+			got = append(got, k)
+		}
+		slices.Sort(got)
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%q, field %d: got %q, want %q", tt.content, tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestReadKeysMissing(t *testing.T) {
+	keys, err := readKeys(filepath.Join(t.TempDir(), "out.csv"), 0)
+	if err != nil || len(keys) != 0 {
+		t.Errorf("got %v, %v, want no keys", keys, err)
+	}
+}
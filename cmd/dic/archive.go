@@ -0,0 +1,116 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// archivePath returns the path in an archive of the file at p found for
+// query, in the subdirectory of dir named after the query.
+func archivePath(dir, query, p string) string {
+	q := slug(query)
+	if q == "" {
+		q = "_"
+	}
+	return path.Join(dir, q, filepath.Base(p))
+}
+
+// addToArchive adds the file at p to zw as name, stored as it is, images
+// being compressed already.
+func addToArchive(zw *zip.Writer, name, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	h, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	h.Name = name
+	w, err := zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// writeArchive writes to dst a zip archive of the images and thumbnails
+// of downloads, in the images and thumbs directories, by query, with the
+// records of the images, as written by downloadAll, in records.csv and
+// their manifest, recorded by dl, in manifest.json. Their paths are
+// those in the archive.
+func writeArchive(dst string, dl *downloader, downloads []*download) error {
+	f, err := createAtomic(dst)
+	if err != nil {
+		return err
+	}
+	defer f.discard()
+	zw := zip.NewWriter(f)
+	added := make(map[string]bool)
+	var records bytes.Buffer
+	cw := csv.NewWriter(&records)
+	var entries []*manifestEntry
+	for _, d := range downloads {
+		img := archivePath("images", d.query, d.path)
+		if !added[img] {
+			if err := addToArchive(zw, img, d.path); err != nil {
+				return err
+			}
+			added[img] = true
+		}
+		var thumb string
+		if d.thumb != "" {
+			thumb = archivePath("thumbs", d.query, d.thumb)
+			if !added[thumb] {
+				if err := addToArchive(zw, thumb, d.thumb); err != nil {
+					return err
+				}
+				added[thumb] = true
+			}
+		}
+		cw.Write(d.record(dl, img, thumb))
+		if d.entry != nil {
+			e := *d.entry
+			e.Path = img
+			entries = append(entries, &e)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	now := time.Now()
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "records.csv", Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(records.Bytes()); err != nil {
+		return err
+	}
+	if w, err = zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: now}); err != nil {
+		return err
+	}
+	if err := encodeManifest(w, false, entries); err != nil {
+		return err
+	}
+	err = zw.Close()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return f.commit()
+}
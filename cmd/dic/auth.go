@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// apiKey grants access to the server. Name identifies the client in
+// the logs.
+type apiKey struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	// RateLimit and RateBurst override the server request rate
+	// limit for the key, if not zero.
+	RateLimit float64 `yaml:"rate_limit"`
+	RateBurst int     `yaml:"rate_burst"`
+
+	// The following make the key a tenant, with its own search
+	// stack: Provider, Google, Blocklist and Allowlist override the
+	// server ones, and searches are cached in their own namespace, by
+	// default the key name.
+	Provider       string            `yaml:"provider"`
+	Google         googleCredentials `yaml:"google"`
+	Blocklist      string            `yaml:"blocklist"`
+	Allowlist      string            `yaml:"allowlist"`
+	CacheNamespace string            `yaml:"cache_namespace"`
+}
+
+// tenant reports whether the key has its own search stack.
+func (k *apiKey) tenant() bool {
+	return k.Provider != "" || k.Google != (googleCredentials{}) ||
+		k.Blocklist != "" || k.Allowlist != "" || k.CacheNamespace != ""
+}
+
+// selector returns the selector of the images of a tenant key, sel with
+// the domain lists of the key.
+func (k *apiKey) selector(sel *selector) (*selector, error) {
+	ts := *sel
+	var err error
+	if k.Blocklist != "" {
+		if ts.blocklist, err = loadDomainList(expandHome(k.Blocklist)); err != nil {
+			return nil, fmt.Errorf("unable to load blocklist: %w", err)
+		}
+	}
+	if k.Allowlist != "" {
+		if ts.allowlist, err = loadDomainList(expandHome(k.Allowlist)); err != nil {
+			return nil, fmt.Errorf("unable to load allowlist: %w", err)
+		}
+	}
+	return &ts, nil
+}
+
+// namespace returns the cache namespace of a tenant key.
+func (k *apiKey) namespace() string {
+	if k.CacheNamespace != "" {
+		return k.CacheNamespace
+	}
+	return k.Name
+}
+
+func (k *apiKey) validate() error {
+	ns := k.namespace()
+	switch {
+	case k.Provider != "" && k.Provider != providerGoogle:
+		return fmt.Errorf("unsupported provider %q", k.Provider)
+	case (k.Google.Key == "") != (k.Google.Cx == ""):
+		return fmt.Errorf("google key and cx must be set together")
+	case k.tenant() && (ns == "." || ns == ".." || filepath.Base(ns) != ns):
+		return fmt.Errorf("invalid cache namespace %q", ns)
+	case k.tenant() && slices.Contains(reservedNamespaces, ns):
+		return fmt.Errorf("reserved cache namespace %q", ns)
+	default:
+		return nil
+	}
+}
+
+// parseAPIKeys parses a comma separated list of keys, each optionally
+// prefixed by its name and a colon.
+func parseAPIKeys(s string) []apiKey {
+	var keys []apiKey
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		k := apiKey{Key: v}
+		if name, key, ok := strings.Cut(v, ":"); ok {
+			k.Name, k.Key = name, key
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// authenticator checks the API keys presented by the clients. Access
+// is granted to anyone if it has no keys. Initialize it using
+// newAuthenticator, which names the unnamed keys after their position.
+type authenticator struct {
+	keys []apiKey
+}
+
+func newAuthenticator(keys []apiKey) (*authenticator, error) {
+	names := make(map[string]bool, len(keys))
+	for i, k := range keys {
+		if k.Key == "" {
+			return nil, fmt.Errorf("api key %d is empty", i+1)
+		}
+		if k.Name == "" {
+			keys[i].Name = "key" + strconv.Itoa(i+1)
+		}
+		if names[keys[i].Name] {
+			return nil, fmt.Errorf("duplicate api key name %q", keys[i].Name)
+		}
+		if err := keys[i].validate(); err != nil {
+			return nil, fmt.Errorf("api key %q: %w", keys[i].Name, err)
+		}
+		names[keys[i].Name] = true
+	}
+	return &authenticator{keys: keys}, nil
+}
+
+func (a *authenticator) enabled() bool {
+	return len(a.keys) > 0
+}
+
+// lookup returns the name of the key matching token.
+func (a *authenticator) lookup(token string) (string, bool) {
+	var name string
+	for _, k := range a.keys {
+		// Compare all the keys, in constant time, not to leak
+		// which one matched.
+		if subtle.ConstantTimeCompare([]byte(token), []byte(k.Key)) == 1 {
+			name = k.Name
+		}
+	}
+	return name, name != ""
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+// value, if any.
+func bearerToken(h string) string {
+	scheme, token, ok := strings.Cut(h, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+type clientKey struct{}
+
+// clientName returns the name of the key the client authenticated
+// with, if any.
+func clientName(ctx context.Context) string {
+	name, _ := ctx.Value(clientKey{}).(string)
+	return name
+}
+
+// middleware rejects the requests without a valid key, passed either
+// as a bearer token or in the X-API-Key header. Browsers cannot set
+// headers on WebSocket connections: those may use the "access_token"
+// query parameter instead.
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	if !a.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r.Header.Get("Authorization"))
+		if token == "" {
+			token = r.Header.Get("X-API-Key")
+		}
+		if token == "" && websocket.IsWebSocketUpgrade(r) {
+			token = r.URL.Query().Get("access_token")
+		}
+		name, ok := a.lookup(token)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dic"`)
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid api key"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, name)))
+	})
+}
+
+// authenticate checks the key passed in the "authorization" metadata
+// as a bearer token, or in "x-api-key".
+func (a *authenticator) authenticate(ctx context.Context) (context.Context, error) {
+	if !a.enabled() {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if v := md.Get("authorization"); len(v) > 0 {
+		token = bearerToken(v[0])
+	}
+	if v := md.Get("x-api-key"); token == "" && len(v) > 0 {
+		token = v[0]
+	}
+	name, ok := a.lookup(token)
+	if !ok {
+		return nil, grpcstatus.Error(codes.Unauthenticated, "missing or invalid api key")
+	}
+	return context.WithValue(ctx, clientKey{}, name), nil
+}
+
+func (a *authenticator) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authStream overrides the context of a server stream.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+func (a *authenticator) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+}
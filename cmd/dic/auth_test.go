@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	keys := []apiKey{{Name: "alice", Key: "secret"}}
+	a, err := newAuthenticator(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{auth: a, limiter: newRateLimiter(limit{}, keys)}
+	h, err := s.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path   string
+		header string
+		value  string
+		want   int
+	}{
+		{"/metrics", "", "", http.StatusUnauthorized},
+		{"/metrics", "Authorization", "Bearer nope", http.StatusUnauthorized},
+		{"/metrics", "Authorization", "Basic secret", http.StatusUnauthorized},
+		{"/metrics", "X-API-Key", "nope", http.StatusUnauthorized},
+		{"/metrics", "Authorization", "Bearer secret", http.StatusOK},
+		{"/metrics", "Authorization", "bearer  secret", http.StatusOK},
+		{"/metrics", "X-API-Key", "secret", http.StatusOK},
+		{"/metrics?access_token=secret", "", "", http.StatusUnauthorized},
+		{"/healthz", "", "", http.StatusOK},
+		{"/openapi.yaml", "", "", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s, %s %q: got status %d, want %d", tt.path, tt.header, tt.value, w.Code, tt.want)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s, %s %q: no WWW-Authenticate header", tt.path, tt.header, tt.value)
+		}
+	}
+}
+
+func TestAuthMiddlewareDisabled(t *testing.T) {
+	a, err := newAuthenticator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want access granted without keys", w.Code)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a, err := newAuthenticator([]apiKey{{Key: "first"}, {Name: "bob", Key: "second"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		md   metadata.MD
+		want string // client name, empty if rejected.
+	}{
+		{nil, ""},
+		{metadata.Pairs("authorization", "Bearer nope"), ""},
+		{metadata.Pairs("x-api-key", "nope"), ""},
+		{metadata.Pairs("authorization", "Bearer first"), "key1"},
+		{metadata.Pairs("x-api-key", "second"), "bob"},
+		{metadata.Pairs("authorization", "Bearer second", "x-api-key", "first"), "bob"},
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+		ctx, err := a.authenticate(ctx)
+		if tt.want == "" {
+			if grpcstatus.Code(err) != codes.Unauthenticated {
+				t.Errorf("%v: got %v, want unauthenticated", tt.md, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.md, err)
+			continue
+		}
+		if got := clientName(ctx); got != tt.want {
+			t.Errorf("%v: got client %q, want %q", tt.md, got, tt.want)
+		}
+	}
+}
+
+func TestNewAuthenticatorInvalid(t *testing.T) {
+	for _, keys := range [][]apiKey{
+		{{Name: "alice"}},
+		{{Name: "alice", Key: "a"}, {Name: "alice", Key: "b"}},
+		{{Name: "alice", Key: "a", Provider: "bing"}},
+		{{Name: "alice", Key: "a", Google: googleCredentials{Key: "k"}}},
+		{{Name: "alice", Key: "a", CacheNamespace: "../cache"}},
+		{{Name: llmNamespace, Key: "a", Blocklist: "blocked.txt"}},
+		{{Name: "alice", Key: "a", CacheNamespace: llmNamespace}},
+	} {
+		if _, err := newAuthenticator(keys); err == nil {
+			t.Errorf("%+v: got no error", keys)
+		}
+	}
+	if _, err := newAuthenticator([]apiKey{{Name: llmNamespace, Key: "a"}}); err != nil {
+		t.Errorf("key not a tenant named %q: %v", llmNamespace, err)
+	}
+}
@@ -0,0 +1,759 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discursive-image/dic"
+	"github.com/discursive-image/dic/airtable"
+	"github.com/discursive-image/dic/google"
+	"github.com/discursive-image/dic/journal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func openInputFile(in string) (io.ReadCloser, error) {
+	if in == "-" {
+		return os.Stdin, nil
+	}
+
+	file, err := os.Open(in)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open csv input reader: %w", err)
+	}
+	return file, nil
+}
+
+// input locates the csv records of a batch.
+type input struct {
+	// path is a file, "-" for stdin, an S3 object URL, a PostgreSQL URL
+	// whose query rows are the records, a sheets URL whose range rows
+	// are, or an airtable URL whose table records are, as their id and
+	// word.
+	path     string
+	query    string
+	sheets   *google.Sheets
+	airtable *airtable.Client
+	table    airtableTable
+	// skip holds the keys, field keyField, of the records skipped, as
+	// already in the output, if set.
+	skip     map[string]bool
+	keyField int
+}
+
+// remote reports whether the records are not read from a file.
+func (in *input) remote() bool {
+	return in.path == "-" || isS3URL(in.path) || isPostgresURL(in.path) || isSheetURL(in.path) || isAirtableURL(in.path)
+}
+
+func (in *input) open(ctx context.Context) (io.ReadCloser, error) {
+	r, err := in.openRecords(ctx)
+	if err != nil || in.skip == nil {
+		return r, err
+	}
+	return skipKeys(r, in.keyField, in.skip), nil
+}
+
+func (in *input) openRecords(ctx context.Context) (io.ReadCloser, error) {
+	switch {
+	case isS3URL(in.path):
+		return openS3(ctx, in.path)
+	case isPostgresURL(in.path):
+		return openPostgres(ctx, in.path, in.query)
+	case isSheetURL(in.path):
+		return openSheet(ctx, in.sheets, in.path)
+	case isAirtableURL(in.path):
+		return openAirtable(ctx, in.airtable, in.path, in.table)
+	}
+	return openInputFile(in.path)
+}
+
+// output locates where the enriched records of a batch are written.
+type output struct {
+	// path is "-" for stdout, an S3 object URL, a BigQuery table URL
+	// whose rows are the records, a PostgreSQL URL whose table is
+	// updated, a sheets URL whose range, the input one, is
+	// updated in place in sheetColumn, an airtable URL whose records,
+	// identified by the first field, are updated, or a file.
+	path            string
+	table           *postgresTable
+	sheets          *google.Sheets
+	sheetColumn     string
+	airtable        *airtable.Client
+	airtableTable   airtableTable
+	bigQueryColumns []string
+	// append is set to append to an output file rather than replace it,
+	// and splitRows, if positive, to split it into shards of splitRows
+	// records.
+	append    bool
+	splitRows int
+}
+
+// nopCloser wraps the standard output, which is not closed.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// recordOutput receives the enriched records of a batch.
+type recordOutput interface {
+	dic.Sink
+	// Close writes the pending records, and reports the first failure
+	// of the output.
+	Close() error
+}
+
+// csvOutput writes the records to w, csv encoded, flushing each so that
+// they are output as soon as enriched.
+type csvOutput struct {
+	flushWriter
+	w io.WriteCloser
+}
+
+func newCSVOutput(w io.WriteCloser) *csvOutput {
+	return &csvOutput{flushWriter: flushWriter{csv.NewWriter(w)}, w: w}
+}
+
+func (o *csvOutput) Close() error {
+	return o.w.Close()
+}
+
+// outputCommitter returns the committer of w, if it is one or writes to
+// one.
+func outputCommitter(w recordOutput) committer {
+	if o, ok := w.(*csvOutput); ok {
+		c, _ := o.w.(committer)
+		return c
+	}
+	c, _ := w.(committer)
+	return c
+}
+
+func (o *output) open(ctx context.Context) (recordOutput, error) {
+	switch {
+	case o.path == "-":
+		return newCSVOutput(nopCloser{os.Stdout}), nil
+	case isS3URL(o.path):
+		s3o, err := openS3Output(ctx, o.path)
+		if err != nil {
+			return nil, err
+		}
+		return newCSVOutput(s3o), nil
+	case isBigQueryURL(o.path):
+		return openBigQueryOutput(o.path, o.bigQueryColumns)
+	case isPostgresURL(o.path):
+		return openPostgresOutput(ctx, o.path, o.table)
+	case isSheetURL(o.path):
+		return newSheetOutput(o.sheets, o.path, o.sheetColumn)
+	case isAirtableURL(o.path):
+		return openAirtableOutput(o.airtable, o.path, o.airtableTable)
+	case o.append:
+		f, err := openAppend(o.path)
+		if err != nil {
+			return nil, err
+		}
+		return newCSVOutput(f), nil
+	case o.splitRows > 0:
+		return openShards(o.path, o.splitRows)
+	}
+	f, err := createAtomic(o.path)
+	if err != nil {
+		return nil, err
+	}
+	return newCSVOutput(f), nil
+}
+
+// committer is implemented by the outputs only written once committed,
+// after being closed.
+type committer interface {
+	commit() error
+	// discard drops the output, unless committed.
+	discard()
+}
+
+// atomicFile is an output file written to a temporary file of its
+// directory, which only replaces it once committed, so that a failed
+// run never leaves a partial output behind.
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+func createAtomic(path string) (*atomicFile, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create output file: %w", err)
+	}
+	// As if created by os.Create.
+	f.Chmod(0o644)
+	return &atomicFile{File: f, path: path}, nil
+}
+
+// commit replaces the output file with the temporary one, which must be
+// closed.
+func (f *atomicFile) commit() error {
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		return fmt.Errorf("unable to replace output file: %w", err)
+	}
+	return nil
+}
+
+// discard removes the temporary file, unless committed.
+func (f *atomicFile) discard() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// errNoResults is reported when a search returns no images.
+var errNoResults = dic.ErrNoResults
+
+// errDeadLinks is the error of the searches whose results all link to
+// images that are not alive, or skipped by the filters of the results.
+var errDeadLinks = dic.ErrDeadLinks
+
+// ImageRequest is the lookup of the image of a record, the Value of
+// its dic.Request.
+type ImageRequest struct {
+	gsc      *google.SC
+	c        int
+	row      int // row number in the input, from 1; 0 for single searches.
+	rec      []string
+	opts     []func(url.Values)
+	err      error
+	hit      bool // served by the cache.
+	searched bool // a search was issued.
+	latency  time.Duration
+	timeout  time.Duration   // of the search, if not 0.
+	gate     searchGate      // optional.
+	journal  *journal.Writer // optional.
+	span     trace.Span      // spans the record lifecycle.
+	wspan    trace.Span      // spans the write of the record.
+}
+
+// query returns the word searched by the request, if present.
+func (r *ImageRequest) query() string {
+	if r.c >= len(r.rec) {
+		return ""
+	}
+	return r.rec[r.c]
+}
+
+// logAttrs returns the attributes describing the request outcome.
+func (r *ImageRequest) logAttrs() []any {
+	attrs := []any{
+		"query", r.query(),
+		"provider", providerGoogle,
+		"cache_hit", r.hit,
+	}
+	if r.searched {
+		attrs = append(attrs, "latency", r.latency)
+	}
+	if r.err != nil {
+		attrs = append(attrs, "error", r.err)
+	} else {
+		attrs = append(attrs, "link", r.rec[len(r.rec)-1])
+	}
+	return attrs
+}
+
+// Run looks up the image of the record with h, appending its link, after
+// the correction of its query if spellChecker is set and the synonym it
+// was found for if synonymSource is, each empty if none.
+func (r *ImageRequest) Run(ctx context.Context, h dic.Handler) {
+	q := &dic.Request{Row: r.row, Record: r.rec, Value: r}
+	r.err = dic.Lookup(ctx, h, q, r.c)
+	r.rec = q.Record
+}
+
+// pipeline is the search stack used to enrich records. The same
+// pipeline, and hence its cache, may be shared by several batches.
+type pipeline struct {
+	gsc     *google.SC
+	c       int // column of the word to search.
+	maxcc   int // maximum concurrent searches per batch.
+	opts    []func(url.Values)
+	caches  *resultCaches
+	gate    searchGate      // optional.
+	journal *journal.Writer // optional.
+	// onRecord, if set, is called with statusMu held once each
+	// record is processed, in input order.
+	onRecord func(*ImageRequest)
+}
+
+// request returns a request enriching rec.
+func (p *pipeline) request(rec []string, span trace.Span) *ImageRequest {
+	return &ImageRequest{
+		c:       p.c,
+		rec:     rec,
+		gsc:     p.gsc,
+		opts:    p.opts,
+		gate:    p.gate,
+		journal: p.journal,
+		span:    span,
+	}
+}
+
+// options returns the options looking up the terms with the pipeline,
+// whose requests must hold an *ImageRequest as Value.
+func (p *pipeline) options() dic.Options {
+	rc := p.caches.get(p.opts)
+	return dic.Options{
+		Column:      p.c,
+		Search:      search,
+		Concurrency: p.maxcc,
+		Cache:       rc.Cache,
+		Check:       rc.check,
+		Stages:      stages,
+	}
+}
+
+// filters returns the pipeline search options, overridden by the typ
+// and size filters when not empty.
+func (p *pipeline) filters(typ, size string) []func(url.Values) {
+	opts := append([]func(url.Values){}, p.opts...)
+	if typ != "" {
+		opts = append(opts, google.FilterImgType(typ))
+	}
+	if size != "" {
+		opts = append(opts, google.FilterImgSize(size))
+	}
+	return opts
+}
+
+// lookup searches for q alone, with the opts filters instead of the
+// pipeline ones.
+func (p *pipeline) lookup(ctx context.Context, q string, opts []func(url.Values)) *ImageRequest {
+	lp := *p
+	lp.c = 0
+	lp.opts = opts
+	ctx, span := tracer.Start(ctx, "record")
+	rw := lp.request([]string{q}, span)
+	// Bound the search only, not the wait for a shared one.
+	rw.timeout = 5 * time.Second
+	rw.Run(ctx, lp.options().Handler())
+	endSpan(span, rw.err)
+	return rw
+}
+
+// handleSSearch processes the records of in into out, calling
+// onRecord, if set, as for pipeline.onRecord, with the summary of the
+// run.
+func handleSSearch(ctx context.Context, p *pipeline, in *input, out dic.Sink, onRecord func(*ImageRequest, *summary)) *summary {
+	r, err := in.open(ctx)
+	if err != nil {
+		exitf(err.Error())
+	}
+	defer r.Close()
+
+	sum := newSummary()
+	if onRecord != nil {
+		p.onRecord = func(r *ImageRequest) { onRecord(r, sum) }
+	}
+	total := countRecords(in.path)
+	if in.skip != nil {
+		// Unknown, as the records already in the output are skipped.
+		total = 0
+	}
+	prog := newProgress(total, sum)
+	p.process(ctx, r, out, sum, prog)
+	prog.finish()
+	sum.close()
+	return sum
+}
+
+// process enriches the csv records read from r, writing them to w in
+// the same order. The outcome is accounted in sum. Records already
+// read when ctx is canceled, or when reading fails, are completed;
+// once writing fails, the next ones are dropped.
+func (p *pipeline) process(ctx context.Context, r io.Reader, w dic.Sink, sum *summary, prog *progress) {
+	opts := p.options()
+	opts.Stages = append([]dic.Stage{detached}, opts.Stages...)
+	opts.OnRead = func(q *dic.Request) {
+		_, span := tracer.Start(ctx, "record", trace.WithAttributes(attribute.Int("row", q.Row)))
+		rw := p.request(q.Record, span)
+		rw.row = q.Row
+		q.Value = rw
+		metricQueueDepth.Inc()
+	}
+	opts.OnRecord = func(q *dic.Request, err error) {
+		rw := q.Value.(*ImageRequest)
+		metricQueueDepth.Dec()
+		if errors.Is(err, dic.ErrAborted) {
+			rw.span.End()
+			return
+		}
+		rw.rec, rw.err = q.Record, err
+		metricRecords.WithLabelValues(outcome(err)).Inc()
+		statusMu.Lock()
+		sum.record(rw)
+		if p.onRecord != nil {
+			p.onRecord(rw)
+		}
+		statusMu.Unlock()
+		prog.update()
+		if err != nil {
+			// This is a non critical error. The log is here to
+			// prevent records from being discarded silently.
+			logger.Error("unable to obtain link", rw.logAttrs()...)
+			endSpan(rw.span, err)
+			return
+		}
+		logger.Info("link obtained", rw.logAttrs()...)
+		_, rw.wspan = tracer.Start(trace.ContextWithSpan(context.Background(), rw.span), "write")
+	}
+	opts.OnWrite = func(q *dic.Request, err error) {
+		rw := q.Value.(*ImageRequest)
+		endSpan(rw.wspan, err)
+		endSpan(rw.span, err)
+	}
+
+	err := dic.Enrich(ctx, csv.NewReader(r), w, opts)
+	switch {
+	case ctx.Err() != nil && errors.Is(err, ctx.Err()):
+		sum.Canceled = true
+	case err != nil:
+		logger.Error("exiting input processing loop", "error", err)
+		sum.Error = err.Error()
+	}
+}
+
+// flushWriter writes csv records, flushing each so that they are output
+// as soon as enriched.
+type flushWriter struct {
+	*csv.Writer
+}
+
+func (w flushWriter) Write(rec []string) error {
+	if err := w.Writer.Write(rec); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// recordSink is an output whose records are written by a goroutine, such
+// as to a database, as they are received. Initialize it using
+// newRecordSink.
+type recordSink struct {
+	recs chan []string
+	// stop is closed once the goroutine returns, with err.
+	stop chan struct{}
+	err  error
+}
+
+// newRecordSink returns the sink whose records are received by write,
+// until it is closed. Once write fails, the writes to the sink do.
+func newRecordSink(write func(recs <-chan []string) error) *recordSink {
+	s := &recordSink{recs: make(chan []string), stop: make(chan struct{})}
+	go func() {
+		defer close(s.stop)
+		s.err = write(s.recs)
+	}()
+	return s
+}
+
+func (s *recordSink) Write(rec []string) error {
+	select {
+	case s.recs <- rec:
+		return nil
+	case <-s.stop:
+		if s.err == nil {
+			return errors.New("output closed")
+		}
+		return s.err
+	}
+}
+
+// Close writes the pending records, and reports the first failure of
+// the output.
+func (s *recordSink) Close() error {
+	close(s.recs)
+	<-s.stop
+	return s.err
+}
+
+// processFile enriches the records of the in file into the out file,
+// which is only replaced once the run completes: the records are
+// written to a temporary file first. Returns the error that aborted the
+// run, also reported in sum, or context.Canceled.
+func (p *pipeline) processFile(ctx context.Context, in, out string, sum *summary) error {
+	fail := func(err error) error {
+		sum.Error = err.Error()
+		return err
+	}
+	r, err := openInputFile(in)
+	if err != nil {
+		return fail(err)
+	}
+	defer r.Close()
+
+	w, err := createAtomic(out)
+	if err != nil {
+		return fail(err)
+	}
+	defer w.discard()
+
+	p.process(ctx, r, flushWriter{csv.NewWriter(w)}, sum, nil)
+	if err := w.Close(); err != nil && sum.Error == "" {
+		sum.Error = fmt.Sprintf("unable to write output: %v", err)
+	}
+	switch {
+	case sum.Error != "":
+		return errors.New(sum.Error)
+	case sum.Canceled:
+		return context.Canceled
+	}
+	if err := w.commit(); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+func runBatch(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	o := registerOptions(fs)
+	i := fs.String("i", "-", "Input file containing the words to retrive the image of. csv encoded, use the \"c\" flag to select the proper column. Use - for stdin, an s3://<bucket>/<key> URL, authenticated with the default AWS credential chain, a postgres:// URL to read the rows of \"query\", whose password may rather be in the standard PGPASSWORD variable, a sheets://<spreadsheet id>/<range> URL to read the rows of a Google Sheets range, such as \"Words!A2:C\", or an airtable://<base id>/<table> URL to read the records of an Airtable table missing a link.")
+	query := fs.String("query", "", "SQL query selecting the records of a PostgreSQL input, such as \"SELECT id, word FROM words\" with \"c\" set to 1. Values are read as text, NULL as empty.")
+	out := fs.String("o", "-", "Output where the enriched records are written: - for stdout, a file, only replaced once the run completes, an s3://<bucket>/<key> URL, a bigquery://<project>/<dataset>/<table> URL whose rows are appended, a postgres:// URL whose \"table\" is updated, the sheets:// input URL, whose rows are updated in place, or an airtable://<base id>/<table> URL whose records, identified by the first field, are updated.")
+	sheetColumn := fs.String("sheet-column", "", "Column of the sheet, such as D, where the links are written, for sheets outputs.")
+	airtableToken := fs.String("airtable-token", os.Getenv(envAirtableToken), "Airtable personal access token, for airtable inputs and outputs.")
+	wordField := fs.String("airtable-word-field", "Name", "Field of the airtable records holding the word searched.")
+	linkField := fs.String("airtable-link-field", "Image", "Field of the airtable records where the links are written.")
+	attachment := fs.Bool("airtable-attachment", false, "Write the links as attachments of \"airtable-link-field\", an attachment field, rather than as text.")
+	view := fs.String("airtable-view", "", "Optional view of the airtable table whose records are processed, in its order.")
+	formula := fs.String("airtable-formula", "", "Formula selecting the airtable records processed. Defaults to those with a word but no link.")
+	table := fs.String("table", "", "Table where the links are written, for PostgreSQL outputs.")
+	key := fs.String("key", "id", "Column of \"table\" identifying the rows, matched against the field \"key-field\" of the records.")
+	keyField := fs.Int("key-field", 0, "Selects the column of the records holding the key of their row in \"table\", or identifying them when appending.")
+	bqColumns := fs.String("bigquery-columns", "", "Comma separated names of the columns of a BigQuery table created for the output, one per record field. Defaults to field_1, field_2, ... and link.")
+	splitRows := fs.Int("split-rows", 0, "Split the output file into numbered shards of this many records, such as out-00001.csv for out.csv.")
+	appendOut := fs.Bool("append", false, "Append to the output file rather than replace it, skipping the records whose field \"key-field\" is already in it.")
+	link := fs.String("link", "image", "Column of \"table\" where the links are written.")
+	upsert := fs.Bool("upsert", false, "Insert the rows missing from \"table\", with INSERT ... ON CONFLICT on \"key\", instead of updating the existing ones only.")
+	txSize := fs.Int("tx-size", 100, "Number of records written to \"table\" per transaction.")
+	c := fs.Int("c", 3, "Selects the column which will be used as word input.")
+	j := fs.Int("j", 10, "Maximum number of concurrent searches.")
+	dryRun := fs.Bool("dry-run", false, "Read the input and report how many searches the run would perform, without performing them.")
+	estimate := fs.Bool("estimate", false, "Print the number of billable searches and their estimated cost before running.")
+	confirmRun := fs.Bool("confirm", false, "Like \"estimate\", then ask for confirmation before running.")
+	summaryPath := fs.String("summary", "", "Optional file where the end of run summary is written as JSON.")
+	metricsAddr := fs.String("metrics-addr", "", "Optional address where Prometheus metrics are served, at /metrics.")
+	debugAddr := fs.String("debug-addr", "", "Optional address where pprof profiles are served, at /debug/pprof/. Do not expose publicly.")
+	jobID := fs.String("job-id", newJobID(), "Identifier of the run in its progress events. Random by default.")
+	eo := registerEventOptions(fs)
+	fs.Usage = usageFor(fs, "batch [flags]", `Appends the link of an image to each record of a csv input, searching for the word in the selected column.
+
+If "events-redis-url" or "events-webhook" is set, progress events are
+published as JSON objects, such as:
+  {"type": "start", "job": "...", "time": "..."}
+  {"type": "row", "job": "...", "time": "...", "processed": 12, "failed": 1, "row": {"row": 12, "query": "cats", "link": "https://...", "cache_hit": false}}
+  {"type": "summary", "job": "...", "time": "...", "summary": {...}}`)
+	fs.Parse(args)
+
+	cfg := o.load(fs)
+	if err := applyConfig(fs, map[string]string{
+		"c":                   strconv.Itoa(cfg.Column),
+		"j":                   strconv.Itoa(cfg.Concurrency),
+		"query":               cfg.Postgres.Query,
+		"table":               cfg.Postgres.Table,
+		"key":                 cfg.Postgres.Key,
+		"key-field":           strconv.Itoa(cfg.Postgres.KeyField),
+		"link":                cfg.Postgres.Link,
+		"upsert":              strconv.FormatBool(cfg.Postgres.Upsert),
+		"tx-size":             strconv.Itoa(cfg.Postgres.TxSize),
+		"sheet-column":        cfg.Sheets.Column,
+		"airtable-word-field": cfg.Airtable.WordField,
+		"airtable-link-field": cfg.Airtable.LinkField,
+		"airtable-attachment": strconv.FormatBool(cfg.Airtable.Attachment),
+		"airtable-view":       cfg.Airtable.View,
+		"airtable-formula":    cfg.Airtable.Formula,
+		"bigquery-columns":    strings.Join(cfg.BigQuery.Columns, ","),
+	}); err != nil {
+		exitf(err.Error())
+	}
+	// Environment variables take precedence over the config file.
+	if os.Getenv(envAirtableToken) == "" {
+		if err := applyConfig(fs, map[string]string{"airtable-token": cfg.Airtable.Token}); err != nil {
+			exitf(err.Error())
+		}
+	}
+	if err := eo.applyConfig(fs, cfg); err != nil {
+		exitf(err.Error())
+	}
+	if *j <= 0 {
+		exitf("concurrency must be positive, got %d", *j)
+	}
+	if isSheetURL(*out) && *out != *i {
+		exitf("sheets outputs must be the input, whose rows are updated in place")
+	}
+	in := &input{path: *i, query: *query}
+	fileOut := !(*out == "-" || isS3URL(*out) || isBigQueryURL(*out) || isPostgresURL(*out) || isSheetURL(*out) || isAirtableURL(*out))
+	if *splitRows != 0 {
+		switch {
+		case !fileOut:
+			exitf("only output files can be split")
+		case *appendOut:
+			exitf("split outputs cannot be appended to")
+		case *splitRows < 0:
+			exitf("rows per shard must be positive, got %d", *splitRows)
+		}
+	}
+	if *appendOut {
+		if !fileOut {
+			exitf("only output files can be appended to")
+		}
+		if *keyField < 0 {
+			exitf("key field must be positive, got %d", *keyField)
+		}
+		keys, err := readKeys(*out, *keyField)
+		if err != nil {
+			exitf(err.Error())
+		}
+		in.skip, in.keyField = keys, *keyField
+	}
+	var sheets *google.Sheets
+	if isSheetURL(*i) {
+		sheets = o.sheetsClient(ctx)
+		in.sheets = sheets
+	}
+	at := airtableTable{
+		word:       *wordField,
+		link:       *linkField,
+		attachment: *attachment,
+		view:       *view,
+		formula:    *formula,
+	}
+	var ac *airtable.Client
+	if isAirtableURL(*i) || isAirtableURL(*out) {
+		if *airtableToken == "" {
+			exitf("airtable token missing, set it with \"airtable-token\" or %s", envAirtableToken)
+		}
+		ac = airtable.New(*airtableToken)
+	}
+	if isAirtableURL(*i) {
+		// Airtable records are read as their id and word.
+		*c = 1
+		in.airtable, in.table = ac, at
+	}
+
+	if *dryRun || *estimate || *confirmRun {
+		path, cleanup, err := spoolInput(ctx, in)
+		if err != nil {
+			exitf(err.Error())
+		}
+		atExit(cleanup)
+		in.path = path
+
+		r, err := openInputFile(path)
+		if err != nil {
+			exitf(err.Error())
+		}
+		p, err := planBatch(r, *c, o.store())
+		r.Close()
+		if err != nil {
+			exitf(err.Error())
+		}
+		used, err := usedToday(*o.journal, providerGoogle)
+		if err != nil {
+			exitf(err.Error())
+		}
+		if *dryRun {
+			p.print(os.Stdout, providerGoogle, used)
+			return
+		}
+		p.print(os.Stderr, providerGoogle, used)
+		if *confirmRun {
+			ok, err := confirm("proceed?")
+			if err != nil {
+				exitf(err.Error())
+			}
+			if !ok {
+				notice("run canceled by user")
+				exit(exitCanceled)
+			}
+		}
+	}
+
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
+	}
+	if *debugAddr != "" {
+		go serveDebug(*debugAddr)
+	}
+	p := &pipeline{
+		gsc:     o.searchClient(),
+		c:       *c,
+		maxcc:   *j,
+		opts:    o.filters(),
+		caches:  newResultCaches(o.dirStore(), o.sel),
+		journal: o.journalWriter(),
+	}
+	outp := &output{
+		path: *out,
+		table: &postgresTable{
+			name:     *table,
+			key:      *key,
+			keyField: *keyField,
+			link:     *link,
+			upsert:   *upsert,
+			txSize:   *txSize,
+		},
+		sheets:        sheets,
+		sheetColumn:   *sheetColumn,
+		airtable:      ac,
+		airtableTable: at,
+		append:        *appendOut,
+		splitRows:     *splitRows,
+	}
+	if *bqColumns != "" {
+		outp.bigQueryColumns = strings.Split(*bqColumns, ",")
+	}
+	w, err := outp.open(ctx)
+	if err != nil {
+		exitf(err.Error())
+	}
+	so, _ := w.(*sheetOutput)
+	af := outputCommitter(w)
+	if af != nil {
+		atExit(af.discard)
+	}
+	events := eo.publisher()
+	events.publish(&progressEvent{Type: "start", Job: *jobID, Time: time.Now()})
+	sum := handleSSearch(ctx, p, in, w, func(r *ImageRequest, sum *summary) {
+		if so != nil {
+			so.record(r)
+		}
+		events.publish(newRowEvent(*jobID, r, sum))
+	})
+	if err := w.Close(); err != nil && sum.Error == "" {
+		sum.Error = fmt.Sprintf("unable to write output: %v", err)
+	}
+	// Output files are only replaced by complete runs.
+	if af != nil && sum.Error == "" && !sum.Canceled {
+		if err := af.commit(); err != nil {
+			sum.Error = err.Error()
+		}
+	}
+	events.publish(&progressEvent{Type: "summary", Job: *jobID, Time: time.Now(), Summary: sum})
+	events.close()
+	if verbosity >= levelDefault {
+		sum.print(os.Stderr)
+	}
+	if *summaryPath != "" {
+		if err := sum.writeFile(*summaryPath); err != nil {
+			exitf(err.Error())
+		}
+	}
+	exit(sum.exitCode())
+}
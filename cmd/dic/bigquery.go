@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// bigQueryBatchSize is the number of rows appended per request.
+const bigQueryBatchSize = 500
+
+func isBigQueryURL(s string) bool {
+	return strings.HasPrefix(s, "bigquery://")
+}
+
+// bigQueryTable is a table given as bigquery://<project>/<dataset>/<table>.
+type bigQueryTable struct {
+	project, dataset, table string
+	// columns are the names of the columns of the table, one per field
+	// of the records, if it is created.
+	columns []string
+}
+
+func parseBigQueryURL(s string) (*bigQueryTable, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "bigquery://"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid bigquery url %q, expected bigquery://<project>/<dataset>/<table>", s)
+	}
+	return &bigQueryTable{project: parts[0], dataset: parts[1], table: parts[2]}, nil
+}
+
+// schema returns the schema of the table created for records of n
+// fields: all strings, named after t.columns, or field_1, field_2, ...
+// and link, the last field.
+func (t *bigQueryTable) schema(n int) (bigquery.Schema, error) {
+	names := t.columns
+	if len(names) == 0 {
+		for i := 1; i < n; i++ {
+			names = append(names, fmt.Sprintf("field_%d", i))
+		}
+		names = append(names, "link")
+	}
+	if len(names) != n {
+		return nil, fmt.Errorf("%d bigquery columns for records of %d fields", len(names), n)
+	}
+	s := make(bigquery.Schema, n)
+	for i, name := range names {
+		s[i] = &bigquery.FieldSchema{Name: name, Type: bigquery.StringFieldType}
+	}
+	return s, nil
+}
+
+// bigQueryOutput streams the records written to it to a table with the
+// storage write API, creating the table from the first record if
+// needed. The fields of the records are the columns of the table, in
+// order.
+type bigQueryOutput struct {
+	*recordSink
+	// mw is the client of the stream, once opened.
+	mw *managedwriter.Client
+}
+
+// openBigQueryOutput returns the output writing to the table at url,
+// authenticated with the application default credentials.
+func openBigQueryOutput(url string, columns []string) (*bigQueryOutput, error) {
+	t, err := parseBigQueryURL(url)
+	if err != nil {
+		return nil, err
+	}
+	t.columns = columns
+	o := &bigQueryOutput{}
+	o.recordSink = newRecordSink(func(recs <-chan []string) error {
+		return o.write(recs, t)
+	})
+	return o, nil
+}
+
+// open returns the descriptor of the rows of t, creating it for records
+// of n fields if it does not exist, and a stream appending to it.
+func (o *bigQueryOutput) open(ctx context.Context, t *bigQueryTable, n int) (protoreflect.MessageDescriptor, *managedwriter.ManagedStream, error) {
+	bq, err := bigquery.NewClient(ctx, t.project)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create bigquery client: %w", err)
+	}
+	defer bq.Close()
+	table := bq.Dataset(t.dataset).Table(t.table)
+	md, err := table.Metadata(ctx)
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+		schema, serr := t.schema(n)
+		if serr != nil {
+			return nil, nil, serr
+		}
+		if err = table.Create(ctx, &bigquery.TableMetadata{Schema: schema}); err == nil {
+			logger.Info("bigquery table created", "table", table.FullyQualifiedName())
+			md, err = table.Metadata(ctx)
+		}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get bigquery table: %w", err)
+	}
+
+	ts, err := adapt.BQSchemaToStorageTableSchema(md.Schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := adapt.StorageSchemaToProto2Descriptor(ts, "row")
+	if err != nil {
+		return nil, nil, err
+	}
+	desc, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected bigquery row descriptor %T", d)
+	}
+	dp, err := adapt.NormalizeDescriptor(desc)
+	if err != nil {
+		return nil, nil, err
+	}
+	mw, err := managedwriter.NewClient(ctx, t.project)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create bigquery write client: %w", err)
+	}
+	ms, err := mw.NewManagedStream(ctx,
+		managedwriter.WithDestinationTable(managedwriter.TableParentFromParts(t.project, t.dataset, t.table)),
+		managedwriter.WithType(managedwriter.DefaultStream),
+		managedwriter.WithSchemaDescriptor(dp),
+	)
+	if err != nil {
+		mw.Close()
+		return nil, nil, fmt.Errorf("unable to open bigquery stream: %w", err)
+	}
+	o.mw = mw
+	return desc, ms, nil
+}
+
+// row encodes rec as a row of desc, empty fields being NULL.
+func row(desc protoreflect.MessageDescriptor, rec []string) ([]byte, error) {
+	fields := desc.Fields()
+	if len(rec) > fields.Len() {
+		return nil, fmt.Errorf("record of %d fields for a bigquery table of %d columns", len(rec), fields.Len())
+	}
+	m := dynamicpb.NewMessage(desc)
+	for i, s := range rec {
+		if s == "" {
+			continue
+		}
+		fd := fields.Get(i)
+		var v protoreflect.Value
+		var err error
+		switch fd.Kind() {
+		case protoreflect.StringKind:
+			v = protoreflect.ValueOfString(s)
+		case protoreflect.Int64Kind:
+			var n int64
+			n, err = strconv.ParseInt(s, 10, 64)
+			v = protoreflect.ValueOfInt64(n)
+		case protoreflect.DoubleKind:
+			var f float64
+			f, err = strconv.ParseFloat(s, 64)
+			v = protoreflect.ValueOfFloat64(f)
+		case protoreflect.BoolKind:
+			var b bool
+			b, err = strconv.ParseBool(s)
+			v = protoreflect.ValueOfBool(b)
+		default:
+			return nil, fmt.Errorf("unsupported type %v of bigquery column %s", fd.Kind(), fd.Name())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of bigquery column %s: %w", fd.Name(), err)
+		}
+		m.Set(fd, v)
+	}
+	return proto.Marshal(m)
+}
+
+// write appends the records of recs to t, by batches of
+// bigQueryBatchSize. The records are written even if the run is canceled,
+// as their search is complete.
+func (o *bigQueryOutput) write(recs <-chan []string, t *bigQueryTable) error {
+	ctx := context.Background()
+	var (
+		desc protoreflect.MessageDescriptor
+		ms   *managedwriter.ManagedStream
+		rows [][]byte
+	)
+	defer func() {
+		if ms != nil {
+			ms.Close()
+			o.mw.Close()
+		}
+	}()
+	appendRows := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		res, err := ms.AppendRows(ctx, rows)
+		if err == nil {
+			_, err = res.GetResult(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to append bigquery rows: %w", err)
+		}
+		logger.Debug("bigquery rows appended", "table", t.table, "rows", len(rows))
+		rows = rows[:0]
+		return nil
+	}
+
+	for rec := range recs {
+		if ms == nil {
+			var err error
+			if desc, ms, err = o.open(ctx, t, len(rec)); err != nil {
+				return err
+			}
+		}
+		b, err := row(desc, rec)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, b)
+		if len(rows) == bigQueryBatchSize {
+			if err := appendRows(); err != nil {
+				return err
+			}
+		}
+	}
+	return appendRows()
+}
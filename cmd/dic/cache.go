@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/discursive-image/dic/cache"
+)
+
+const cacheUsage = `Manages the persistent search cache.
+
+actions:
+  path          print the cache directory
+  list          list cached queries, with their age and result count
+  stats         print entry counts
+  get <query>   print the links cached for query, searched with the filters
+                of the flags
+  rm <query>... remove the entries of the given queries, searched with the
+                filters of the flags
+  prune         remove expired entries
+  clear         remove all entries`
+
+func runCache(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("cache", flag.ExitOnError)
+	o := registerOptions(fs)
+	fs.Usage = usageFor(fs, "cache [flags] <action> [args]", cacheUsage)
+	fs.Parse(args)
+	o.load(fs)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		exit(exitUsage)
+	}
+	dir := o.cacheDirStore()
+	if dir == nil {
+		exitf("persistent cache disabled, set either \"cache-dir\" or the cache dir in the config file")
+	}
+
+	action, args := fs.Arg(0), fs.Args()[1:]
+	switch action {
+	case "path":
+		fmt.Println(dir.Path)
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "QUERY\tAGE\tRESULTS\tEXPIRED\n")
+		if err := dir.Walk(func(_ string, e *cache.Entry) error {
+			age := time.Since(e.Created).Truncate(time.Second)
+			_, err := fmt.Fprintf(w, "%s\t%v\t%d\t%t\n", e.Key, age, len(e.Items), e.Expired(dir.TTL))
+			return err
+		}); err != nil {
+			exitf(err.Error())
+		}
+		w.Flush()
+	case "stats":
+		var total, expired, items int
+		if err := dir.Walk(func(_ string, e *cache.Entry) error {
+			total++
+			items += len(e.Items)
+			if e.Expired(dir.TTL) {
+				expired++
+			}
+			return nil
+		}); err != nil {
+			exitf(err.Error())
+		}
+		fmt.Printf("entries: %d\nexpired: %d\nresults: %d\n", total, expired, items)
+	case "get":
+		if len(args) != 1 {
+			exitf("get expects exactly one query")
+		}
+		items, ok, err := o.store().Get(queryKey(args[0]))
+		if err != nil {
+			exitf(err.Error())
+		}
+		if !ok {
+			exitf("%q is not cached", args[0])
+		}
+		for _, v := range items {
+			fmt.Println(v.Link)
+		}
+	case "rm":
+		for _, k := range args {
+			if err := dir.Delete(signedKey(queryKey(k), searchSignature(o.filters()))); err != nil {
+				exitf(err.Error())
+			}
+		}
+	case "prune", "clear":
+		n, err := dir.Prune(action == "clear")
+		if err != nil {
+			exitf(err.Error())
+		}
+		notice("cache entries removed", "count", n)
+	default:
+		fs.Usage()
+		logger.Error("unknown cache action", "action", action)
+		exit(exitUsage)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// casIndexFile is the index of a content addressed image directory,
+// whose csv records are the query, link and path of each image.
+const casIndexFile = "index.csv"
+
+// casPath returns the path, relative to the directory, of the image of
+// checksum sum stored by content, such as 3f/a1/3fa1...9c.jpg.
+func casPath(sum, ext string) string {
+	return filepath.Join(sum[:2], sum[2:4], sum+ext)
+}
+
+// casIndex maps the queries and links of the images stored by content
+// to their path.
+type casIndex struct {
+	mu    sync.Mutex
+	f     *os.File
+	w     *csv.Writer
+	links map[string]string // link to path, relative to the directory.
+}
+
+func openCASIndex(dir string) (*casIndex, error) {
+	f, err := os.OpenFile(filepath.Join(dir, casIndexFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open image index: %w", err)
+	}
+	idx := &casIndex{f: f, w: csv.NewWriter(f), links: make(map[string]string)}
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 3
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return idx, nil
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("unable to read image index: %w", err)
+		}
+		// Later entries take precedence.
+		idx.links[rec[1]] = rec[2]
+	}
+}
+
+func (idx *casIndex) lookup(link string) (string, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	path, ok := idx.links[link]
+	return path, ok
+}
+
+// add records that the image of query at link is stored at path,
+// relative to the directory.
+func (idx *casIndex) add(query, link, path string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.links[link] = path
+	idx.w.Write([]string{query, link, filepath.ToSlash(path)})
+	idx.w.Flush()
+	return idx.w.Error()
+}
+
+func (idx *casIndex) close() error {
+	return idx.f.Close()
+}
+
+// verifyCAS reports whether the image at path, stored by content,
+// matches the checksum of its name.
+func verifyCAS(path string) bool {
+	sum, err := fileChecksum(path)
+	return err == nil && sum == strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// storeCAS moves the image at path, of checksum sum, to the directory
+// dir stored by content, and returns its path relative to dir. Images
+// already stored, downloaded for another link, are not duplicated.
+func storeCAS(dir, path, sum string) (string, error) {
+	rel := casPath(sum, filepath.Ext(path))
+	dst := filepath.Join(dir, rel)
+	if verifyCAS(dst) {
+		logger.Debug("image already stored", "path", dst)
+		return rel, os.Remove(path)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", err
+	}
+	return rel, os.Rename(path, dst)
+}
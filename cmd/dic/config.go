@@ -0,0 +1,709 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discursive-image/dic/cache"
+	"github.com/discursive-image/dic/google"
+	"github.com/discursive-image/dic/journal"
+	"github.com/discursive-image/dic/rotate"
+	"gopkg.in/yaml.v3"
+)
+
+// googleCredentials identify a custom search engine and the account
+// billed for its queries.
+type googleCredentials struct {
+	Key string `yaml:"key"`
+	Cx  string `yaml:"cx"`
+}
+
+// config holds the defaults loaded from the configuration file.
+// Command line flags always take precedence over its values.
+type config struct {
+	Google      googleCredentials `yaml:"google"`
+	Provider    string            `yaml:"provider"`
+	Concurrency int               `yaml:"concurrency"`
+	Column      int               `yaml:"column"`
+	Normalize   string            `yaml:"normalize"`
+	MaxWords    int               `yaml:"max_words"`
+	Keywords    int               `yaml:"keywords"`
+	Lemmas      string            `yaml:"lemmas"`
+	Spell       string            `yaml:"spell"`
+	Template    string            `yaml:"query_template"`
+	Phrase      string            `yaml:"phrase"`
+	Type        string            `yaml:"type"`
+	Size        string            `yaml:"size"`
+	Within      string            `yaml:"within"`
+	MinWidth    int               `yaml:"min_width"`
+	MinHeight   int               `yaml:"min_height"`
+	Aspect      string            `yaml:"aspect"`
+	Faces       string            `yaml:"faces"`
+	Pick        string            `yaml:"pick"`
+	Diversify   bool              `yaml:"diversify"`
+	Blocklist   string            `yaml:"blocklist"`
+	Allowlist   string            `yaml:"allowlist"`
+	Dedup       bool              `yaml:"dedup"`
+	UniqueLinks bool              `yaml:"unique_links"`
+	DetectLang  bool              `yaml:"detect_language"`
+	Cache       struct {
+		Dir string        `yaml:"dir"`
+		TTL time.Duration `yaml:"ttl"`
+	} `yaml:"cache"`
+	NSFW struct {
+		Classifier string  `yaml:"classifier"`
+		Threshold  float64 `yaml:"threshold"`
+		// Token is overridden by the NSFW_API_TOKEN variable.
+		Token string `yaml:"token"`
+	} `yaml:"nsfw"`
+	Rank struct {
+		Enabled  bool   `yaml:"enabled"`
+		Types    string `yaml:"types"`
+		Penalize string `yaml:"penalize"`
+	} `yaml:"rank"`
+	OCR struct {
+		MaxText   float64 `yaml:"max_text"`
+		Tesseract string  `yaml:"tesseract"`
+	} `yaml:"ocr"`
+	LLM struct {
+		Endpoint string `yaml:"endpoint"`
+		Model    string `yaml:"model"`
+		// Token is overridden by the LLM_API_TOKEN variable.
+		Token  string `yaml:"token"`
+		Images bool   `yaml:"images"`
+	} `yaml:"llm"`
+	Synonyms struct {
+		Max       int    `yaml:"max"`
+		Thesaurus string `yaml:"thesaurus"`
+	} `yaml:"synonyms"`
+	Translate struct {
+		Target string `yaml:"target"`
+		Source string `yaml:"source"`
+	} `yaml:"translate"`
+	Relevance struct {
+		Model string `yaml:"model"`
+		// Token is overridden by the RELEVANCE_API_TOKEN variable.
+		Token string `yaml:"token"`
+	} `yaml:"relevance"`
+	Log struct {
+		Format  string        `yaml:"format"`
+		File    string        `yaml:"file"`
+		MaxSize int64         `yaml:"max_size"`
+		MaxAge  time.Duration `yaml:"max_age"`
+		Backups int           `yaml:"backups"`
+	} `yaml:"log"`
+	Tracing struct {
+		Endpoint string `yaml:"endpoint"`
+	} `yaml:"tracing"`
+	Journal  string `yaml:"journal"`
+	Postgres struct {
+		Query    string `yaml:"query"`
+		Table    string `yaml:"table"`
+		Key      string `yaml:"key"`
+		KeyField int    `yaml:"key_field"`
+		Link     string `yaml:"link"`
+		Upsert   bool   `yaml:"upsert"`
+		TxSize   int    `yaml:"tx_size"`
+	} `yaml:"postgres"`
+	Sheets struct {
+		Column string `yaml:"column"`
+	} `yaml:"sheets"`
+	Airtable struct {
+		// Token is overridden by the AIRTABLE_TOKEN variable.
+		Token      string `yaml:"token"`
+		WordField  string `yaml:"word_field"`
+		LinkField  string `yaml:"link_field"`
+		Attachment bool   `yaml:"attachment"`
+		View       string `yaml:"view"`
+		Formula    string `yaml:"formula"`
+	} `yaml:"airtable"`
+	BigQuery struct {
+		Columns []string `yaml:"columns"`
+	} `yaml:"bigquery"`
+	Serve struct {
+		Addr     string   `yaml:"addr"`
+		GRPCAddr string   `yaml:"grpc_addr"`
+		APIKeys  []apiKey `yaml:"api_keys"`
+		// Requests per second, per client.
+		RateLimit float64 `yaml:"rate_limit"`
+		RateBurst int     `yaml:"rate_burst"`
+	} `yaml:"serve"`
+	Worker struct {
+		Backend      string        `yaml:"backend"`
+		In           string        `yaml:"in"`
+		Out          string        `yaml:"out"`
+		RedisURL     string        `yaml:"redis_url"`
+		Brokers      []string      `yaml:"brokers"`
+		NATSURL      string        `yaml:"nats_url"`
+		AMQPURL      string        `yaml:"amqp_url"`
+		DeadLetter   string        `yaml:"dead_letter"`
+		GateRedisURL string        `yaml:"gate_redis_url"`
+		SearchRate   float64       `yaml:"search_rate"`
+		SearchBurst  int           `yaml:"search_burst"`
+		Group        string        `yaml:"group"`
+		Consumer     string        `yaml:"consumer"`
+		ClaimIdle    time.Duration `yaml:"claim_idle"`
+	} `yaml:"worker"`
+	Daemon struct {
+		Schedules []*schedule `yaml:"schedules"`
+	} `yaml:"daemon"`
+	Watch struct {
+		Dir       string        `yaml:"dir"`
+		Pattern   string        `yaml:"pattern"`
+		OutDir    string        `yaml:"out_dir"`
+		DoneDir   string        `yaml:"done_dir"`
+		FailedDir string        `yaml:"failed_dir"`
+		Interval  time.Duration `yaml:"interval"`
+	} `yaml:"watch"`
+	Events struct {
+		RedisURL string `yaml:"redis_url"`
+		Channel  string `yaml:"channel"`
+		Webhook  string `yaml:"webhook"`
+	} `yaml:"events"`
+	Report struct {
+		SentryDSN string        `yaml:"sentry_dsn"`
+		Webhook   string        `yaml:"webhook"`
+		Interval  time.Duration `yaml:"interval"`
+	} `yaml:"report"`
+}
+
+const providerGoogle = "google"
+
+func (c *config) validate() error {
+	switch {
+	case c.Provider != "" && c.Provider != providerGoogle:
+		return fmt.Errorf("unsupported provider %q", c.Provider)
+	case c.Concurrency < 0:
+		return fmt.Errorf("concurrency must be positive, got %d", c.Concurrency)
+	case c.Column < 0:
+		return fmt.Errorf("column must be positive, got %d", c.Column)
+	default:
+		return nil
+	}
+}
+
+// defaultConfigPath returns the location of the configuration file,
+// honoring XDG_CONFIG_HOME when set.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "dic", "config.yaml")
+}
+
+// expandHome replaces a leading "~" in p with the user's home directory.
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[1:])
+}
+
+// loadConfig reads the configuration file at path. A missing file is
+// not an error unless required is true, in which case the user
+// explicitly asked for it.
+func loadConfig(path string, required bool) (*config, error) {
+	c := &config{}
+	if path == "" {
+		return c, nil
+	}
+
+	f, err := os.Open(expandHome(path))
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open config file: %w", err)
+	}
+	defer f.Close()
+
+	if err := yaml.NewDecoder(f).Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unable to decode config file %s: %w", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// isFlagSet reports whether the flag called name was explicitly
+// provided on the command line.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	var set bool
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// applyConfig overrides the flags that were not explicitly set with
+// the configuration values in vals, keyed by flag name. Empty and zero
+// values, the ones of missing configuration keys, are ignored.
+func applyConfig(fs *flag.FlagSet, vals map[string]string) error {
+	for name, v := range vals {
+		if v == "" || v == "0" || v == "0s" || isFlagSet(fs, name) {
+			continue
+		}
+		if err := fs.Set(name, v); err != nil {
+			return fmt.Errorf("unable to apply config value for %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// options are the flags shared by all subcommands.
+type options struct {
+	config    *string
+	key       *string
+	cx        *string
+	imgType   *string
+	imgSize   *string
+	normalize *string
+	maxWords  *int
+	keywords  *int
+	lemmas    *string
+	spell     *string
+	synonyms  *int
+	thesaurus *string
+	template  *string
+	phrase    *string
+	translate *string
+	transFrom *string
+	detectLng *bool
+	within    *string
+	minWidth  *int
+	minHeight *int
+	aspect    *string
+	httpsOnly *bool
+	blocklist *string
+	allowlist *string
+	nsfw      *string
+	nsfwMin   *float64
+	nsfwToken *string
+	dedup     *bool
+	unique    *bool
+	rank      *bool
+	rankTypes *string
+	rankLast  *string
+	relevance *string
+	relToken  *string
+	llm       *string
+	llmModel  *string
+	llmToken  *string
+	llmImages *bool
+	textMax   *float64
+	tesseract *string
+	faces     *string
+	pick      *string
+	diversify *bool
+	cacheDir  *string
+	cacheTTL  *time.Duration
+	verbose   *bool
+	debug     *bool
+	quiet     *bool
+	logFormat *string
+	logFile   *string
+	logSize   *int64
+	logAge    *time.Duration
+	logKeep   *int
+	otlp      *string
+	sentryDSN *string
+	webhook   *string
+	reportInt *time.Duration
+	httpDump  *string
+	journal   *string
+	liveLinks *bool
+
+	jw  *journal.Writer
+	sel *selector // set by load.
+}
+
+func registerOptions(fs *flag.FlagSet) *options {
+	return &options{
+		config:    fs.String("config", defaultConfigPath(), "Configuration file providing the default values of the other flags."),
+		key:       fs.String("k", os.Getenv(envGoogleKey), "Google API key."),
+		cx:        fs.String("cx", os.Getenv(envGoogleCx), "Google custom search engine ID."),
+		imgType:   fs.String("t", "undefined", "Image type to search for (clipart|face|lineart|news|photo)."),
+		imgSize:   fs.String("s", "undefined", "Image size to search for (huge|icon|large|medium|small|xlarge|xxlarge)."),
+		normalize: fs.String("normalize", "", "Comma separated steps normalizing the queries before they are looked up in the cache and searched, in order: trim, space, collapsing whitespace, nfc, composing accented characters, lower, punct, stripping punctuation, stopwords, stripping English stopwords, stem, reducing English plurals, and lemma, replacing inflected forms by their lemma, as told by \"lemmas\", such as trim,space,nfc,lower. None if empty."),
+		lemmas:    fs.String("lemmas", "", "File listing the lemmas of the \"lemma\" normalization step, one form and its lemma per line, such as \"mice mouse\", on top of common irregular English ones."),
+		spell:     fs.String("spell", "", "Dictionary correcting the typos of the terms once normalized, one word per line, optionally followed by its frequency, such as /usr/share/dict/words. Batches record the corrections in a column before the link, empty for the terms not corrected."),
+		synonyms:  fs.Int("synonyms", 0, "Number of synonyms of the terms without results searched in turn, until one has, as told by \"thesaurus\". Batches record the synonym the link was found for in a column before it, after the one of \"spell\", empty for the terms found themselves. None if 0."),
+		thesaurus: fs.String("thesaurus", datamuseURL, "Datamuse compatible endpoint looking up the synonyms of \"synonyms\"."),
+		keywords:  fs.Int("keywords", 0, "Number of keywords searched instead of the terms longer than that many words, such as sentences, which produce poor results or none: their named entities first, then their nouns, as told by a part-of-speech tagger, before any normalization. Whole terms if 0."),
+		maxWords:  fs.Int("max-words", 0, "Number of words queries are truncated to once normalized, such as 6 for full sentences, which produce poor results or none. Any if 0."),
+		template:  fs.String("query-template", "", "Template of the text searched for each term, such as \"{{.Term}} illustration white background\", the term alone if empty. Results are cached by term and template."),
+		phrase:    fs.String("phrase", phraseAsIs, "How multi-word terms are searched: \"as-is\", \"exact\", as quoted phrases, or \"all\", requiring each of their words. Results are cached by term and phrase mode."),
+		translate: fs.String("translate", "", "Language the terms are translated to before they are searched, such as en for the larger English image index, with the Cloud Translation API enabled for the google key. Results are cached by, and records keep, the original terms, apart from the untranslated ones. None if empty."),
+		transFrom: fs.String("translate-from", "", "Language of the terms translated by \"translate\", such as fr, detected for each term if empty."),
+		detectLng: fs.Bool("detect-language", false, "Detect the language of each term, with the Cloud Translation API enabled for the google key, and search it in that language, which favors the results in it, for lists mixing languages. Terms translated by \"translate\" are searched in its language instead."),
+		within:    fs.String("within", "", "Period within which the pages of the images were published: d, w, m or y, for days, weeks, months or years, followed by their number, such as w2. Restricts the searches, and skips the images whose page metadata tell they are older. Any if empty."),
+		minWidth:  fs.Int("min-width", 0, "Minimum width of the images, in pixels, as told by the search results or else the image header, skipping icons and thumbnails the \"s\" filter lets through. 0 means any."),
+		minHeight: fs.Int("min-height", 0, "Minimum height of the images, in pixels, as \"min-width\". 0 means any."),
+		aspect:    fs.String("aspect", "", "Aspect ratio of the images, width to height, as told as \"min-width\": a ratio such as 16:9, within 10%, or a range such as 1:1-4:3 or 1.2-1.5. Any if empty."),
+		httpsOnly: fs.Bool("https-only", false, "Only use HTTPS links, plain HTTP ones being upgraded if their host serves the image over HTTPS as well, or else skipped, as required by pages that block mixed content."),
+		blocklist: fs.String("blocklist", "", "File listing the domains, one per line, whose images, or the pages they are found on, are skipped, such as stock photo sites. Subdomains are skipped as well."),
+		allowlist: fs.String("allowlist", "", "File listing the only domains, as \"blocklist\", whose images, or the pages they are found on, are used, such as *.wikimedia.org."),
+		nsfw:      fs.String("nsfw", "", "Optional classifier of the images not safe for work, skipped, or that cannot be classified: the URL of an API, posted {\"url\": <link>} and answering {\"score\": <0 to 1>}, or the command line of a local one, run with the link appended and printing the score."),
+		nsfwMin:   fs.Float64("nsfw-threshold", 0.5, "Score from which the \"nsfw\" classifier flags images."),
+		nsfwToken: fs.String("nsfw-token", os.Getenv(envNSFWToken), "Bearer token of the \"nsfw\" API, if required. Defaults to NSFW_API_TOKEN."),
+		dedup:     fs.Bool("dedup", false, "Skip the images nearly identical, as told by their perceptual hash, to the ones used for other queries, falling back on the next result. Images are downloaded to be hashed."),
+		unique:    fs.Bool("unique-links", false, "Never write the same link twice in a run, falling back on the next result when a link was written already, as happens with synonyms."),
+		rank:      fs.Bool("rank", false, "Try the results best scored first, rather than in the order of the provider: the larger images, of the \"rank-types\" and not on the \"rank-penalize\" domains."),
+		rankTypes: fs.String("rank-types", "", "Comma separated image types preferred by \"rank\", such as jpeg,png."),
+		rankLast:  fs.String("rank-penalize", "", "File listing the domains, as \"blocklist\", whose images are tried last by \"rank\", such as stock photo sites watermarking them."),
+		relevance: fs.String("relevance", "", "Optional model scoring the relevance of the results to their query, such as with CLIP, tried from the most relevant: the URL of a service, or the command line of a local one, given {\"text\": <query>, \"images\": [<link>...]} in JSON and answering {\"scores\": [<score>...]}."),
+		relToken:  fs.String("relevance-token", os.Getenv(envRelevanceToken), "Bearer token of the \"relevance\" service, if required. Defaults to RELEVANCE_API_TOKEN."),
+		textMax:   fs.Float64("max-text", 0, "Skip the images mostly text, such as memes, screenshots and dictionary pages, whose words recognized by tesseract cover at least this fraction of their area, such as 0.2. 0 means any."),
+		tesseract: fs.String("tesseract", "tesseract", "Path of the tesseract executable, recognizing the text of the images for \"max-text\"."),
+		faces:     fs.String("faces", "", "Skip the images without faces, if \"require\", such as for people, or with faces, if \"forbid\", as detected in them once downloaded. Any if empty."),
+		pick:      fs.String("pick", pickFirst, "Result used first for each query, the next ones being used for its next records: \"first\", \"random\", for varied images across runs, or \"nth=<k>\", the kth one, such as nth=2."),
+		diversify: fs.Bool("diversify", false, "Alternate the sites the results are found on, for the next records of a query, or fallbacks, not to be crops of the same photo."),
+		llm:       fs.String("llm", "", "Optional OpenAI compatible chat completions endpoint, such as https://api.openai.com/v1/chat/completions, of an LLM choosing the result tried first from their titles, snippets and pages. Its choices are cached in the llm subdirectory of \"cache-dir\", if set."),
+		llmModel:  fs.String("llm-model", "", "Model of the \"llm\" endpoint, such as gpt-4o-mini."),
+		llmToken:  fs.String("llm-token", os.Getenv(envLLMToken), "Bearer token of the \"llm\" endpoint, if required. Defaults to LLM_API_TOKEN."),
+		llmImages: fs.Bool("llm-images", false, "Send the thumbnails of the results to the \"llm\" as well, for models supporting images."),
+		cacheDir:  fs.String("cache-dir", "", "Directory where search results are persisted across runs. Disabled if empty."),
+		cacheTTL:  fs.Duration("cache-ttl", 0, "Time after which persisted search results are refreshed. 0 means never."),
+		verbose:   fs.Bool("v", false, "Verbose output, logs the outcome of each record."),
+		debug:     fs.Bool("vv", false, "Debug output, logs search and cache details."),
+		quiet:     fs.Bool("q", false, "Quiet mode, only fatal errors are printed. Other errors are reported in the summary."),
+		logFormat: fs.String("log-format", logFormatText, "Log output format (text|json)."),
+		logFile:   fs.String("log-file", "", "Write logs to this file instead of stderr."),
+		logSize:   fs.Int64("log-max-size", 0, "If \"log-file\" is used, size in MB after which the file is rotated. 0 means never."),
+		logAge:    fs.Duration("log-max-age", 0, "If \"log-file\" is used, age after which the file is rotated. 0 means never."),
+		logKeep:   fs.Int("log-backups", 0, "If \"log-file\" is used, number of rotated files to keep. 0 means all."),
+		sentryDSN: fs.String("report-sentry-dsn", os.Getenv(envSentryDSN), "Sentry DSN where unexpected failures are reported."),
+		webhook:   fs.String("report-webhook", "", "URL where unexpected failures are posted as JSON."),
+		reportInt: fs.Duration("report-interval", time.Minute, "Minimum interval between two reports of the same error type."),
+		journal:   fs.String("journal", "", "Append-only file where every provider call is recorded."),
+		httpDump:  fs.String("debug-http", "", "File where the outbound search requests and their responses are dumped, with the API key redacted."),
+		otlp:      fs.String("otlp-endpoint", "", "OTLP/HTTP endpoint URL where traces are exported. If empty, the standard OTEL_EXPORTER_OTLP_ENDPOINT variable is used, if set."),
+		liveLinks: fs.Bool("check-links", true, "Check that the links, cached or found, are alive with a HEAD request, or a ranged GET if refused, before writing them, falling back on the next result, then on a new search."),
+	}
+}
+
+// load reads the configuration file and applies its values to the
+// shared flags that were not explicitly set. Must be called after fs
+// is parsed; the returned config may be used by the subcommand to
+// apply its own flags.
+func (o *options) load(fs *flag.FlagSet) *config {
+	switch {
+	case *o.quiet:
+		verbosity = levelQuiet
+	case *o.debug:
+		verbosity = levelDebug
+	case *o.verbose:
+		verbosity = levelVerbose
+	}
+
+	cfg, err := loadConfig(*o.config, isFlagSet(fs, "config"))
+	if err != nil {
+		exitf(err.Error())
+	}
+	vals := map[string]string{
+		"t":               cfg.Type,
+		"s":               cfg.Size,
+		"normalize":       cfg.Normalize,
+		"query-template":  cfg.Template,
+		"phrase":          cfg.Phrase,
+		"translate":       cfg.Translate.Target,
+		"translate-from":  cfg.Translate.Source,
+		"detect-language": strconv.FormatBool(cfg.DetectLang),
+		"max-words":       strconv.Itoa(cfg.MaxWords),
+		"keywords":        strconv.Itoa(cfg.Keywords),
+		"lemmas":          cfg.Lemmas,
+		"spell":           cfg.Spell,
+		"synonyms":        strconv.Itoa(cfg.Synonyms.Max),
+		"thesaurus":       cfg.Synonyms.Thesaurus,
+		"within":          cfg.Within,
+		"min-width":       strconv.Itoa(cfg.MinWidth),
+		"min-height":      strconv.Itoa(cfg.MinHeight),
+		"aspect":          cfg.Aspect,
+		"blocklist":       cfg.Blocklist,
+		"allowlist":       cfg.Allowlist,
+		"dedup":           strconv.FormatBool(cfg.Dedup),
+		"unique-links":    strconv.FormatBool(cfg.UniqueLinks),
+		"rank":            strconv.FormatBool(cfg.Rank.Enabled),
+		"rank-types":      cfg.Rank.Types,
+		"rank-penalize":   cfg.Rank.Penalize,
+		"relevance":       cfg.Relevance.Model,
+		"llm":             cfg.LLM.Endpoint,
+		"llm-model":       cfg.LLM.Model,
+		"llm-images":      strconv.FormatBool(cfg.LLM.Images),
+		"max-text":        strconv.FormatFloat(cfg.OCR.MaxText, 'g', -1, 64),
+		"tesseract":       cfg.OCR.Tesseract,
+		"faces":           cfg.Faces,
+		"pick":            cfg.Pick,
+		"diversify":       strconv.FormatBool(cfg.Diversify),
+		"nsfw":            cfg.NSFW.Classifier,
+		"nsfw-threshold":  strconv.FormatFloat(cfg.NSFW.Threshold, 'g', -1, 64),
+		"cache-dir":       cfg.Cache.Dir,
+		"cache-ttl":       cfg.Cache.TTL.String(),
+		"log-format":      cfg.Log.Format,
+		"log-file":        cfg.Log.File,
+		"log-max-size":    strconv.FormatInt(cfg.Log.MaxSize, 10),
+		"log-max-age":     cfg.Log.MaxAge.String(),
+		"log-backups":     strconv.Itoa(cfg.Log.Backups),
+		"otlp-endpoint":   cfg.Tracing.Endpoint,
+		"report-webhook":  cfg.Report.Webhook,
+		"report-interval": cfg.Report.Interval.String(),
+		"journal":         cfg.Journal,
+	}
+	// Environment variables take precedence over the config file.
+	if os.Getenv(envGoogleKey) == "" {
+		vals["k"] = cfg.Google.Key
+	}
+	if os.Getenv(envGoogleCx) == "" {
+		vals["cx"] = cfg.Google.Cx
+	}
+	if os.Getenv(envSentryDSN) == "" {
+		vals["report-sentry-dsn"] = cfg.Report.SentryDSN
+	}
+	if os.Getenv(envNSFWToken) == "" {
+		vals["nsfw-token"] = cfg.NSFW.Token
+	}
+	if os.Getenv(envRelevanceToken) == "" {
+		vals["relevance-token"] = cfg.Relevance.Token
+	}
+	if os.Getenv(envLLMToken) == "" {
+		vals["llm-token"] = cfg.LLM.Token
+	}
+	if err := applyConfig(fs, vals); err != nil {
+		exitf(err.Error())
+	}
+	if queryNormalizers, err = parseNormalize(*o.normalize); err != nil {
+		exitf(err.Error())
+	}
+	maxQueryWords, maxKeywords = *o.maxWords, *o.keywords
+	lemmas = irregularLemmas
+	if *o.lemmas != "" {
+		if lemmas, err = loadLemmas(expandHome(*o.lemmas)); err != nil {
+			exitf("unable to load lemmas: %v", err)
+		}
+	}
+	spellChecker = nil
+	if *o.spell != "" {
+		if spellChecker, err = loadSpellDict(expandHome(*o.spell)); err != nil {
+			exitf("unable to load spelling dictionary: %v", err)
+		}
+	}
+	synonymSource = nil
+	if *o.synonyms > 0 {
+		synonymSource = newThesaurus(*o.thesaurus, *o.synonyms)
+	}
+	queryTranslator = nil
+	if *o.translate != "" {
+		queryTranslator = newTermTranslator(&google.Translate{Key: *o.key}, *o.transFrom, *o.translate)
+	}
+	languageDetector = nil
+	if *o.detectLng {
+		languageDetector = newTermLanguages(&google.Translate{Key: *o.key})
+	}
+	switch phraseMode = *o.phrase; phraseMode {
+	case phraseAsIs, phraseExact, phraseAll:
+	default:
+		exitf("invalid phrase mode %q, expected %s, %s or %s", phraseMode, phraseAsIs, phraseExact, phraseAll)
+	}
+	queryTemplate = nil
+	if *o.template != "" {
+		if queryTemplate, err = parseQueryTemplate(*o.template); err != nil {
+			exitf(err.Error())
+		}
+	}
+	o.sel = o.selector()
+	var w io.Writer
+	if *o.logFile != "" {
+		rw, err := rotate.Open(expandHome(*o.logFile), *o.logSize<<20, *o.logAge, *o.logKeep)
+		if err != nil {
+			exitf(err.Error())
+		}
+		w = rw
+		atExit(func() { rw.Close() })
+	}
+	if err := setupLogger(*o.logFormat, verbosity, w); err != nil {
+		exitf(err.Error())
+	}
+	if err := setupTracing(*o.otlp); err != nil {
+		exitf(err.Error())
+	}
+	if err := setupReporter(*o.sentryDSN, *o.webhook, *o.reportInt); err != nil {
+		exitf(err.Error())
+	}
+	return cfg
+}
+
+// selector returns the selector of the images set by the options.
+func (o *options) selector() *selector {
+	s := &selector{
+		checkLinks: *o.liveLinks,
+		httpsOnly:  *o.httpsOnly,
+		unique:     *o.unique,
+		dedup:      *o.dedup,
+		minWidth:   *o.minWidth,
+		minHeight:  *o.minHeight,
+		textMax:    *o.textMax,
+		tesseract:  *o.tesseract,
+		faces:      *o.faces,
+		rank:       *o.rank,
+		diversify:  *o.diversify,
+	}
+	var err error
+	if *o.within != "" {
+		if s.maxPageAge, err = google.ParseDateRestrict(*o.within); err != nil {
+			exitf(err.Error())
+		}
+	}
+	if s.minAspect, s.maxAspect, err = parseAspect(*o.aspect); err != nil {
+		exitf(err.Error())
+	}
+	if *o.blocklist != "" {
+		if s.blocklist, err = loadDomainList(expandHome(*o.blocklist)); err != nil {
+			exitf("unable to load blocklist: %v", err)
+		}
+	}
+	if *o.allowlist != "" {
+		if s.allowlist, err = loadDomainList(expandHome(*o.allowlist)); err != nil {
+			exitf("unable to load allowlist: %v", err)
+		}
+	}
+	if s.pick, s.pickIndex, err = parsePick(*o.pick); err != nil {
+		exitf(err.Error())
+	}
+	for _, t := range strings.Split(*o.rankTypes, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			s.rankTypes = append(s.rankTypes, t)
+		}
+	}
+	if *o.rankLast != "" {
+		if s.rankPenalized, err = loadDomainList(expandHome(*o.rankLast)); err != nil {
+			exitf("unable to load penalized domains: %v", err)
+		}
+	}
+	switch s.faces {
+	case "", facesRequire, facesForbid:
+	default:
+		exitf("invalid faces mode %q, expected %s or %s", s.faces, facesRequire, facesForbid)
+	}
+	if *o.llm != "" {
+		s.llm = newLLMRanker(*o.llm, *o.llmModel, *o.llmToken, *o.llmImages, o.namespaceStore(llmNamespace))
+	}
+	if *o.relevance != "" {
+		s.relevance = newRelevanceModel(*o.relevance, *o.relToken)
+	}
+	if *o.nsfw != "" {
+		s.nsfw, s.nsfwThreshold = newNSFWClassifier(*o.nsfw, *o.nsfwToken), *o.nsfwMin
+	}
+	return s
+}
+
+func (o *options) searchClient() *google.SC {
+	return o.searchClientFor(*o.key, *o.cx)
+}
+
+// searchClientFor returns a search client using the key and cx
+// credentials instead of the configured ones.
+func (o *options) searchClientFor(key, cx string) *google.SC {
+	sc := google.NewSC(key, cx)
+	if *o.httpDump != "" {
+		hc, err := newDumpClient(*o.httpDump, *o.key, key)
+		if err != nil {
+			exitf(err.Error())
+		}
+		sc.Client = hc
+	}
+	return sc
+}
+
+func (o *options) filters() []func(url.Values) {
+	return []func(url.Values){
+		google.FilterImgType(*o.imgType),
+		google.FilterImgSize(*o.imgSize),
+		google.FilterDateRestrict(*o.within),
+	}
+}
+
+// journalWriter opens the audit journal. Returns nil if it is
+// disabled.
+func (o *options) journalWriter() *journal.Writer {
+	if *o.journal == "" {
+		return nil
+	}
+	if o.jw != nil {
+		return o.jw
+	}
+	jw, err := journal.Open(expandHome(*o.journal))
+	if err != nil {
+		exitf(err.Error())
+	}
+	atExit(func() { jw.Close() })
+	o.jw = jw
+	return jw
+}
+
+// cacheDirStore opens the persistent cache. Returns nil if it is
+// disabled.
+func (o *options) cacheDirStore() *cache.Dir {
+	if *o.cacheDir == "" {
+		return nil
+	}
+	dir, err := cache.NewDir(expandHome(*o.cacheDir), *o.cacheTTL)
+	if err != nil {
+		exitf(err.Error())
+	}
+	return dir
+}
+
+// store opens the persistent cache of the results of the searches
+// with the configured filters. Returns nil if it is disabled.
+func (o *options) store() resultStore {
+	return signStore(o.dirStore(), searchSignature(o.filters()))
+}
+
+// dirStore opens the persistent cache of the results of the searches
+// with any filters, to be signed. Returns nil if it is disabled.
+func (o *options) dirStore() resultStore {
+	if dir := o.cacheDirStore(); dir != nil {
+		return dir
+	}
+	return nil
+}
+
+// llmNamespace is the cache namespace of the LLM choices.
+const llmNamespace = "llm"
+
+// reservedNamespaces are the cache namespaces taken by dic itself,
+// which the tenants can't use.
+var reservedNamespaces = []string{llmNamespace}
+
+// namespaceStore opens the persistent cache in the ns subdirectory of
+// the cache directory. Returns nil if it is disabled.
+func (o *options) namespaceStore(ns string) resultStore {
+	if *o.cacheDir == "" {
+		return nil
+	}
+	dir, err := cache.NewDir(filepath.Join(expandHome(*o.cacheDir), ns), *o.cacheTTL)
+	if err != nil {
+		exitf(err.Error())
+	}
+	return dir
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+	"github.com/redis/go-redis/v9"
+)
+
+// searchGate coordinates the searches of pipelines, possibly in other
+// processes.
+type searchGate interface {
+	// search searches for k with search, unless the results of a
+	// search of k with the same opts issued elsewhere can be shared,
+	// as reported by shared.
+	search(ctx context.Context, k string, opts []func(url.Values), search func(context.Context) ([]*google.ISR, error)) (items []*google.ISR, shared bool, err error)
+}
+
+// takeToken reserves a token of the bucket in KEYS[1], refilled at
+// ARGV[1] tokens per second up to ARGV[2], and returns the time to
+// wait before using it, in milliseconds. The bucket holds the time at
+// which it is full, as in GCRA, using the server clock so that the
+// clocks of the workers do not matter.
+var takeToken = redis.NewScript(`
+local interval = 1000 / tonumber(ARGV[1])
+local burst = tonumber(ARGV[2]) * interval
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + tonumber(t[2]) / 1000
+local tat = tonumber(redis.call("GET", KEYS[1]) or "0")
+tat = math.max(tat, now) + interval
+redis.call("SET", KEYS[1], tostring(tat), "PX", math.ceil(tat - now) + 1000)
+return math.max(0, math.ceil(tat - now - burst))
+`)
+
+// releaseLock deletes the lock in KEYS[1] if it is still held with the
+// ARGV[1] token.
+var releaseLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// redisGate is a searchGate shared by the workers using the same Redis
+// server. Searches are rate limited by a shared token bucket, and a
+// single worker searches for a query at a time: the others wait for
+// its results.
+type redisGate struct {
+	rdb *redis.Client
+	// rate is the number of searches per second, 0 meaning no limit,
+	// with bursts of up to burst searches.
+	rate  float64
+	burst int
+}
+
+const (
+	gatePrefix = "dic:gate:"
+	// gateLockTTL bounds the time a query is locked by a worker that
+	// dies while searching.
+	gateLockTTL = 30 * time.Second
+	// gateResultTTL is the time the results of a search are shared.
+	gateResultTTL = time.Minute
+	gatePoll      = 100 * time.Millisecond
+)
+
+func newRedisGate(url string, rate float64, burst int) (*redisGate, error) {
+	rdb, err := newRedisClient(url)
+	if err != nil {
+		return nil, err
+	}
+	return &redisGate{rdb: rdb, rate: rate, burst: max(burst, 1)}, nil
+}
+
+func (g *redisGate) search(ctx context.Context, k string, opts []func(url.Values), search func(context.Context) ([]*google.ISR, error)) ([]*google.ISR, bool, error) {
+	sk := signedKey(k, searchSignature(opts))
+	lock, res := gatePrefix+"lock:"+sk, gatePrefix+"result:"+sk
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	for {
+		// Results of a search completed by another worker.
+		v, err := g.rdb.Get(ctx, res).Bytes()
+		if err == nil {
+			var items []*google.ISR
+			if err := json.Unmarshal(v, &items); err != nil {
+				return nil, false, fmt.Errorf("unable to decode shared results: %w", err)
+			}
+			return items, true, nil
+		}
+		if !errors.Is(err, redis.Nil) {
+			return nil, false, fmt.Errorf("unable to read shared results: %w", err)
+		}
+
+		ok, err := g.rdb.SetNX(ctx, lock, token, gateLockTTL).Result()
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to lock query: %w", err)
+		}
+		if ok {
+			break
+		}
+		// Another worker is searching.
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-time.After(gatePoll):
+		}
+	}
+	defer releaseLock.Run(context.Background(), g.rdb, []string{lock}, token)
+
+	if err := g.wait(ctx, lock); err != nil {
+		return nil, false, err
+	}
+	items, err := search(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if v, err := json.Marshal(items); err == nil {
+		if err := g.rdb.Set(ctx, res, v, gateResultTTL).Err(); err != nil {
+			logger.Warn("unable to share results", "query", k, "error", err)
+		}
+	}
+	return items, false, nil
+}
+
+// wait blocks until a search is allowed by the shared rate limit,
+// extending the lock of the query meanwhile.
+func (g *redisGate) wait(ctx context.Context, lock string) error {
+	if g.rate <= 0 {
+		return nil
+	}
+	ms, err := takeToken.Run(ctx, g.rdb, []string{gatePrefix + "bucket"}, g.rate, g.burst).Int64()
+	if err != nil {
+		return fmt.Errorf("unable to take rate limit token: %w", err)
+	}
+	if ms == 0 {
+		return nil
+	}
+	d := time.Duration(ms) * time.Millisecond
+	logger.Debug("waiting for rate limit", "delay", d)
+	g.rdb.Expire(ctx, lock, d+gateLockTTL)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
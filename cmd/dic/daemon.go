@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// schedule is a batch run repeated by the daemon.
+type schedule struct {
+	Name string `yaml:"name"`
+	// Cron is a five fields cron expression, such as "0 3 * * *", or a
+	// descriptor such as "@daily" or "@every 6h".
+	Cron   string `yaml:"cron"`
+	Input  string `yaml:"input"`
+	Output string `yaml:"output"`
+	// Column, Type and Size override the ones of the daemon, if set.
+	Column *int   `yaml:"column"`
+	Type   string `yaml:"type"`
+	Size   string `yaml:"size"`
+	// Summary is an optional file where the summary of the last run is
+	// written as JSON.
+	Summary string `yaml:"summary"`
+
+	running atomic.Bool
+}
+
+func (s *schedule) validate() error {
+	switch {
+	case s.Name == "":
+		return errors.New("schedule name missing")
+	case s.Input == "" || s.Input == "-":
+		return fmt.Errorf("schedule %q: input file missing", s.Name)
+	case s.Output == "" || s.Output == "-":
+		return fmt.Errorf("schedule %q: output file missing", s.Name)
+	case s.Column != nil && *s.Column < 0:
+		return fmt.Errorf("schedule %q: column must be positive, got %d", s.Name, *s.Column)
+	default:
+		return nil
+	}
+}
+
+// daemon runs the batches of its schedules.
+type daemon struct {
+	ctx    context.Context
+	p      *pipeline
+	store  resultStore // optional.
+	sel    *selector
+	events *eventPublisher
+	// wg tracks the runs started outside of the cron scheduler.
+	wg sync.WaitGroup
+}
+
+// run processes the input of s, replacing its output once the run
+// completes. Runs overlapping a previous one are skipped.
+func (d *daemon) run(s *schedule) {
+	if !s.running.CompareAndSwap(false, true) {
+		logger.Warn("previous run still in progress, skipping", "schedule", s.Name)
+		return
+	}
+	defer s.running.Store(false)
+
+	job := newJobID()
+	notice("scheduled run started", "schedule", s.Name, "job", job)
+	d.events.publish(&progressEvent{Type: "start", Job: job, Time: time.Now()})
+
+	sum, err := d.process(s, job)
+	d.events.publish(&progressEvent{Type: "summary", Job: job, Time: time.Now(), Summary: sum})
+	if s.Summary != "" {
+		if err := sum.writeFile(expandHome(s.Summary)); err != nil {
+			logger.Error("unable to write summary", "schedule", s.Name, "error", err)
+		}
+	}
+	if err != nil {
+		logger.Error("scheduled run failed", "schedule", s.Name, "job", job, "error", err)
+		reportError(err, false, map[string]string{"schedule": s.Name})
+		return
+	}
+	notice("scheduled run finished", "schedule", s.Name, "job", job,
+		"rows", sum.Rows, "failed", sum.Failed, "api_calls", sum.APICalls, "elapsed", sum.End.Sub(sum.Start))
+}
+
+// process runs the batch of s, replacing its output unless the run
+// failed or was canceled.
+func (d *daemon) process(s *schedule, job string) (*summary, error) {
+	p := *d.p
+	if s.Column != nil {
+		p.c = *s.Column
+	}
+	p.opts = p.filters(s.Type, s.Size)
+	// A new ring cache checks again the links found by the previous
+	// runs, replacing the dead ones.
+	p.caches = newResultCaches(d.store, d.sel)
+	sum := newSummary()
+	p.onRecord = func(r *ImageRequest) {
+		d.events.publish(newRowEvent(job, r, sum))
+	}
+	err := p.processFile(d.ctx, expandHome(s.Input), expandHome(s.Output), sum)
+	sum.close()
+	return sum, err
+}
+
+func runDaemon(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	o := registerOptions(fs)
+	c := fs.Int("c", 3, "Default column of the word in the inputs of the schedules.")
+	j := fs.Int("j", 10, "Maximum number of concurrent searches per run.")
+	now := fs.Bool("now", false, "Run every schedule once at startup, then as scheduled.")
+	metricsAddr := fs.String("metrics-addr", "", "Optional address where Prometheus metrics are served, at /metrics.")
+	eo := registerEventOptions(fs)
+	fs.Usage = usageFor(fs, "daemon [flags]", `Runs the batches of the schedules listed in the "daemon" section of the
+config file, until interrupted:
+
+  daemon:
+    schedules:
+      - name: vocabulary
+        cron: "0 3 * * *" # or "@daily", "@every 6h", ...
+        input: ~/data/vocabulary.csv
+        output: ~/data/vocabulary+img.csv
+        column: 2 # optional, as type and size.
+        summary: ~/data/vocabulary.json # optional.
+
+Each run processes the whole input, searching for the new words, and
+replaces the output once it completes; runs failing or interrupted leave
+the previous output in place. The links found by the previous runs are
+checked again, and the dead ones replaced. Use "cache-dir" so that the
+words already searched are not searched again, and "cache-ttl" to
+refresh them periodically.
+
+A run is skipped if the previous run of its schedule is still in
+progress. Schedules are evaluated in the local time zone, unless the
+expression starts with "CRON_TZ=<zone>".
+
+If "events-redis-url" or "events-webhook" is set, the progress of the
+runs is published as JSON events, as in batch mode.`)
+	fs.Parse(args)
+
+	cfg := o.load(fs)
+	if err := applyConfig(fs, map[string]string{
+		"c": strconv.Itoa(cfg.Column),
+		"j": strconv.Itoa(cfg.Concurrency),
+	}); err != nil {
+		exitf(err.Error())
+	}
+	if err := eo.applyConfig(fs, cfg); err != nil {
+		exitf(err.Error())
+	}
+	if *j <= 0 {
+		exitf("concurrency must be positive, got %d", *j)
+	}
+	schedules := cfg.Daemon.Schedules
+	if len(schedules) == 0 {
+		exitf("no schedules configured")
+	}
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
+	}
+
+	d := &daemon{
+		ctx: ctx,
+		p: &pipeline{
+			gsc:     o.searchClient(),
+			c:       *c,
+			maxcc:   *j,
+			opts:    o.filters(),
+			journal: o.journalWriter(),
+		},
+		store:  o.dirStore(),
+		sel:    o.sel,
+		events: eo.publisher(),
+	}
+	defer d.events.close()
+
+	cr := cron.New()
+	for _, s := range schedules {
+		if err := s.validate(); err != nil {
+			exitf(err.Error())
+		}
+		if _, err := cr.AddFunc(s.Cron, func() { d.run(s) }); err != nil {
+			exitf("schedule %q: invalid cron expression: %v", s.Name, err)
+		}
+	}
+	cr.Start()
+	notice("daemon started", "schedules", len(schedules))
+	if *now {
+		for _, s := range schedules {
+			d.wg.Add(1)
+			go func() {
+				defer d.wg.Done()
+				d.run(s)
+			}()
+		}
+	}
+
+	<-ctx.Done()
+	// Wait for the runs in progress, which complete the records already
+	// read.
+	<-cr.Stop().Done()
+	d.wg.Wait()
+	notice("daemon stopped")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"image"
+	"math/bits"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// dedupDistance is the maximum number of bits differing between the
+// hashes of images nearly identical.
+const dedupDistance = 6
+
+// dHash returns the difference hash of img: whether each pixel of its
+// grayscale version, scaled down to 9x8, is brighter than the next one
+// in its row. Images scaled, recompressed or slightly retouched keep
+// close hashes.
+func dHash(img image.Image) uint64 {
+	g := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.BiLinear.Scale(g, g.Bounds(), img, img.Bounds(), draw.Src, nil)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if g.GrayAt(x, y).Y < g.GrayAt(x+1, y).Y {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// imageHashes records the perceptual hashes of the images used, with
+// their query. Images are downloaded without holding its lock.
+type imageHashes struct {
+	mu      sync.Mutex
+	queries map[uint64]string
+	// links are the hashes of the images already hashed, by link.
+	links map[string]uint64
+}
+
+func newImageHashes() *imageHashes {
+	return &imageHashes{
+		queries: make(map[uint64]string),
+		links:   make(map[string]uint64),
+	}
+}
+
+// claim reports whether the image of c may be used for query, not being
+// nearly identical to one used for another query, and records it if so.
+// Images that cannot be hashed may.
+func (h *imageHashes) claim(query string, c *candidate) bool {
+	link := c.link
+	h.mu.Lock()
+	sum, ok := h.links[link]
+	h.mu.Unlock()
+	if !ok {
+		var err error
+		if sum, err = h.hash(c); err != nil {
+			logger.Warn("unable to hash image", "link", link, "error", err)
+			return true
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.links[link] = sum
+	for s, q := range h.queries {
+		if q != query && bits.OnesCount64(s^sum) <= dedupDistance {
+			logger.Debug("skipping near-duplicate image", "query", query, "link", link, "duplicate_of", q)
+			return false
+		}
+	}
+	h.queries[sum] = query
+	return true
+}
+
+// hash returns the hash of the image of c.
+func (h *imageHashes) hash(c *candidate) (uint64, error) {
+	img, err := c.image()
+	if err != nil {
+		return 0, err
+	}
+	return dHash(img), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"testing"
+)
+
+// testImage returns a w by h image of smooth shades, brightened by
+// shift, mirrored if flip is set.
+func testImage(w, h int, shift float64, flip bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			if flip {
+				fx = 1 - fx
+			}
+			v := 100 + 80*math.Sin(9*fx+4*fy) + 40*math.Cos(5*fy-3*fx) + shift
+			img.SetGray(x, y, color.Gray{Y: uint8(max(0, min(255, v)))})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	orig := dHash(testImage(300, 200, 0, false))
+	for _, tt := range []struct {
+		name string
+		img  image.Image
+		near bool
+	}{
+		{"same", testImage(300, 200, 0, false), true},
+		{"scaled down", testImage(120, 80, 0, false), true},
+		{"scaled up", testImage(900, 600, 0, false), true},
+		{"brightened", testImage(300, 200, 10, false), true},
+		{"mirrored", testImage(300, 200, 0, true), false},
+	} {
+		d := bits.OnesCount64(orig ^ dHash(tt.img))
+		if near := d <= dedupDistance; near != tt.near {
+			t.Errorf("%s: got distance %d, want near %t", tt.name, d, tt.near)
+		}
+	}
+}
+
+func TestImageHashesClaim(t *testing.T) {
+	decoded := func(link string, img image.Image) *candidate {
+		c := &candidate{link: link, fetched: true, decoded: true, img: img}
+		if img == nil {
+			c.imgErr = errors.New("unable to decode image")
+		}
+		return c
+	}
+	h := newImageHashes()
+	for _, tt := range []struct {
+		query string
+		c     *candidate
+		want  bool
+	}{
+		{"cat", decoded("http://a", testImage(300, 200, 0, false)), true},
+		{"cat", decoded("http://b", testImage(150, 100, 0, false)), true},
+		{"kitten", decoded("http://c", testImage(600, 400, 5, false)), false},
+		{"kitten", decoded("http://a", nil), false},
+		{"kitten", decoded("http://d", testImage(300, 200, 0, true)), true},
+		{"feline", decoded("http://e", nil), true},
+	} {
+		if got := h.claim(tt.query, tt.c); got != tt.want {
+			t.Errorf("%s, %s: got %t, want %t", tt.query, tt.c.link, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/discursive-image/dic/google"
+)
+
+// domainList is a set of domains, matching their subdomains as well.
+type domainList map[string]bool
+
+// loadDomainList reads the domains listed in the file at path, one per
+// line, ignoring blank lines and comments starting with #. Domains may
+// be written as *.example.com or as links as well.
+func loadDomainList(path string) (domainList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	l := make(domainList)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		d := strings.TrimSpace(line)
+		if strings.Contains(d, "://") {
+			d = linkHost(d)
+		}
+		d = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(d), "*"), ".")
+		if d != "" {
+			l[d] = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// match reports whether host is one of the domains, or a subdomain of
+// one.
+func (l domainList) match(host string) bool {
+	for host != "" {
+		if l[host] {
+			return true
+		}
+		_, host, _ = strings.Cut(host, ".")
+	}
+	return false
+}
+
+// has reports whether the image of isr, or the page it was found on, is
+// hosted on one of the domains.
+func (l domainList) has(isr *google.ISR) bool {
+	if len(l) == 0 {
+		return false
+	}
+	if l.match(linkHost(isr.Link)) || l.match(strings.ToLower(isr.DisplayLink)) {
+		return true
+	}
+	return isr.Image != nil && isr.Image.ContextLink != "" && l.match(linkHost(isr.Image.ContextLink))
+}
@@ -0,0 +1,939 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+)
+
+// downloadTimeout is the time allowed to download an image, or to get
+// the response headers if the bandwidth is limited, downloads taking as
+// long as the images are large then.
+const downloadTimeout = 30 * time.Second
+
+// newDownloadClient returns the client of the downloads, whose bandwidth
+// is limited if throttled is set.
+func newDownloadClient(throttled bool) *http.Client {
+	if !throttled {
+		return &http.Client{Timeout: downloadTimeout}
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = downloadTimeout
+	return &http.Client{Transport: t}
+}
+
+// imageExts are the extensions of the usual image types, as chosen by
+// mime.ExtensionsByType varies with the system.
+var imageExts = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+	"image/bmp":     ".bmp",
+	"image/avif":    ".avif",
+}
+
+// imageExt returns the extension of an image of the given content type,
+// downloaded from link.
+func imageExt(contentType, link string) string {
+	t, _, _ := mime.ParseMediaType(contentType)
+	if ext, ok := imageExts[t]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(t); len(exts) > 0 {
+		return exts[0]
+	}
+	if ext := path.Ext(strings.SplitN(link, "?", 2)[0]); len(ext) > 1 && len(ext) <= 5 {
+		return strings.ToLower(ext)
+	}
+	return ".img"
+}
+
+// slug returns q lowercased, with the runs of characters other than
+// letters and digits replaced by dashes, to be used as a file name.
+func slug(q string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(q) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// imageName returns the name, without extension, of the image at link
+// found for q: the hash of link, also used until images stored by
+// content are complete, preceded by q if naming is "query", as the
+// records of q may get different images and different queries the same
+// slug.
+func imageName(naming, q, link string) string {
+	sum := sha256.Sum256([]byte(link))
+	if naming == "query" {
+		if s := slug(q); s != "" {
+			return s + "-" + hex.EncodeToString(sum[:4])
+		}
+	}
+	return hex.EncodeToString(sum[:8])
+}
+
+// checksumsFile is the manifest of the images of a directory, in the
+// format of sha256sum.
+const checksumsFile = "SHA256SUMS"
+
+// checksums are the checksums of the images downloaded to a directory,
+// which tell the complete images from those to download again.
+type checksums struct {
+	mu   sync.Mutex
+	f    *os.File
+	sums map[string]string // file name to hex checksum.
+}
+
+func openChecksums(dir string) (*checksums, error) {
+	f, err := os.OpenFile(filepath.Join(dir, checksumsFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open checksums: %w", err)
+	}
+	cs := &checksums{f: f, sums: make(map[string]string)}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// Later entries take precedence.
+		if sum, name, ok := strings.Cut(sc.Text(), "  "); ok {
+			cs.sums[name] = sum
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to read checksums: %w", err)
+	}
+	return cs, nil
+}
+
+// verify reports whether the file at path matches its checksum.
+func (cs *checksums) verify(path string) bool {
+	cs.mu.Lock()
+	want, ok := cs.sums[filepath.Base(path)]
+	cs.mu.Unlock()
+	if !ok {
+		return false
+	}
+	sum, err := fileChecksum(path)
+	return err == nil && sum == want
+}
+
+// add records the checksum of the file at path.
+func (cs *checksums) add(path, sum string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.sums[filepath.Base(path)] = sum
+	_, err := fmt.Fprintf(cs.f, "%s  %s\n", sum, filepath.Base(path))
+	return err
+}
+
+func (cs *checksums) close() error {
+	return cs.f.Close()
+}
+
+// fileInfo returns the checksum, size and content type, as detected from
+// its first bytes, of the file at path.
+func fileInfo(path string) (sum string, size int64, contentType string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", 0, "", err
+	}
+	h.Write(head[:n])
+	size, err = io.Copy(h, f)
+	if err != nil {
+		return "", 0, "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size + int64(n), http.DetectContentType(head[:n]), nil
+}
+
+// dataURI returns the file at path as a base64 data URI of the type
+// detected from its content, or "" if it is larger than max bytes.
+func dataURI(path string, max int64) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Size() > max {
+		return "", err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + http.DetectContentType(b) + ";base64," + base64.StdEncoding.EncodeToString(b), nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// downloader downloads images to a directory.
+type downloader struct {
+	dir string
+	// overwrite is set to download the images already present again,
+	// and retries is the number of times transient failures are
+	// retried.
+	overwrite bool
+	retries   int
+	sums      *checksums
+	thumbs    *thumbnailer // optional.
+	conv      *converter   // optional.
+	// upload is set to upload the images, whose object URL replaces
+	// their link in the records.
+	upload imageUploader
+	// ipfs is set to add the images to IPFS, their CID being appended
+	// to the records.
+	ipfs *ipfsAdder
+	// archive is set to keep the downloads completed, to be archived.
+	archive bool
+	// dataURIMax is the size up to which the images are inlined in the
+	// records as data URIs, replacing their link. 0 disables it.
+	dataURIMax int64
+	// index is set to store the images by content, and sums otherwise.
+	index *casIndex
+	// manifest is set to record the provenance of the images, looking
+	// up their source in store, if set, and with license.
+	manifest bool
+	store    resultStore
+	license  string
+	// info is set to append the checksum, size and content type of the
+	// images to the records, palette the number of their most common
+	// colors appended, and exif to append their EXIF metadata.
+	info    bool
+	palette int
+	exif    bool
+	// locks serialize the downloads of images of the same name, which
+	// share their partial file.
+	locks sync.Map
+	// maxBytes is the size above which images are not downloaded, and
+	// limiter, if set, limits the bandwidth of all downloads in bytes
+	// per second.
+	maxBytes int64
+	limiter  *rate.Limiter
+	client   *http.Client
+	// hosts limits the downloads from each host, and robots, if set,
+	// tells the images that may be downloaded. Requests are made as
+	// agent.
+	hosts  *hostLimiter
+	robots *robotsChecker
+	agent  string
+}
+
+// newBandwidthLimiter returns a limiter of bandwidth bytes per second,
+// or nil if bandwidth is 0.
+func newBandwidthLimiter(bandwidth int64) *rate.Limiter {
+	if bandwidth <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bandwidth), int(min(bandwidth, 64<<10)))
+}
+
+// limitedReader reads from r no faster than allowed by l.
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rate.Limiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.l.Burst() {
+		p = p[:r.l.Burst()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.WaitN(r.ctx, n); err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// download is the download of the image of a record.
+type download struct {
+	rec   []string
+	li    int // index of the link in rec.
+	query string
+	link  string
+	name  string
+	path  string // of the image downloaded, once done.
+	// thumb is the path of its thumbnail, if any, sum the checksum of
+	// the image, if downloaded, and entry its manifest entry, if any.
+	thumb string
+	sum   string
+	entry *manifestEntry
+	// size and contentType are set with sum if dl.info is.
+	size        int64
+	contentType string
+	// url is the URL of the image uploaded, if dl.upload is set, and
+	// cid its CID, if dl.ipfs is.
+	url string
+	cid string
+	// dataURI is the image as a data URI, if inlined.
+	dataURI string
+	// palette are its most common colors, if dl.palette is set, and
+	// exif its EXIF metadata, if dl.exif is.
+	palette []string
+	exif    []string
+	// present is set if the image was already downloaded.
+	present bool
+	err     error
+	done    chan struct{}
+}
+
+// run downloads the image, uploads it if dl.upload is set, adds it to
+// IPFS if dl.ipfs is, and makes its thumbnail if dl.thumbs is.
+// Its info, palette and EXIF metadata, if dl.info, dl.palette and
+// dl.exif are set, are those of the image as stored, converted if
+// dl.conv is set, which strips the metadata.
+// Thumbnails that cannot be made are logged and left out.
+func (d *download) run(ctx context.Context, dl *downloader) {
+	defer close(d.done)
+	mu, _ := dl.locks.LoadOrStore(d.name, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	d.fetch(ctx, dl)
+	if d.err != nil {
+		return
+	}
+	if dl.info {
+		if d.sum, d.size, d.contentType, d.err = fileInfo(d.path); d.err != nil {
+			return
+		}
+	}
+	if dl.palette > 0 {
+		if d.palette, d.err = palette(d.path, dl.palette); d.err != nil {
+			return
+		}
+	}
+	if dl.exif {
+		if d.exif, d.err = exifInfo(d.path); d.err != nil {
+			return
+		}
+	}
+	if dl.upload != nil {
+		if d.url, d.err = dl.upload.upload(context.WithoutCancel(ctx), d.path, d.query); d.err != nil {
+			return
+		}
+	}
+	if dl.dataURIMax > 0 {
+		if d.dataURI, d.err = dataURI(d.path, dl.dataURIMax); d.err != nil {
+			return
+		}
+	}
+	if dl.ipfs != nil {
+		if d.cid, d.err = dl.ipfs.add(context.WithoutCancel(ctx), d.path); d.err != nil {
+			return
+		}
+	}
+	if dl.manifest {
+		if d.entry, d.err = d.manifestEntry(dl.store, dl.license); d.err != nil {
+			return
+		}
+	}
+	if dl.thumbs == nil {
+		return
+	}
+	// Named as the image, whose name is its checksum if stored by
+	// content.
+	name := strings.TrimSuffix(filepath.Base(d.path), filepath.Ext(d.path))
+	thumb, err := dl.thumbs.make(d.path, name)
+	if err != nil {
+		logger.Warn("unable to make thumbnail", "path", d.path, "error", err)
+		return
+	}
+	d.thumb = thumb
+}
+
+// record returns the record of the image downloaded, with its link
+// replaced by its data URI or else its upload URL, if any, and path and
+// thumb, the path of its image and thumbnail, appended with its info,
+// CID, palette and EXIF metadata, as requested by dl.
+func (d *download) record(dl *downloader, path, thumb string) []string {
+	rec := append([]string(nil), d.rec...)
+	switch {
+	case d.dataURI != "":
+		rec[d.li] = d.dataURI
+	case d.url != "":
+		rec[d.li] = d.url
+	}
+	rec = append(rec, path)
+	if dl.thumbs != nil {
+		rec = append(rec, thumb)
+	}
+	if dl.info {
+		rec = append(rec, d.sum, strconv.FormatInt(d.size, 10), d.contentType)
+	}
+	if dl.ipfs != nil {
+		rec = append(rec, d.cid)
+	}
+	if dl.palette > 0 {
+		// Padded for images of fewer colors, to keep the columns.
+		rec = append(rec, d.palette...)
+		rec = append(rec, make([]string, dl.palette-len(d.palette))...)
+	}
+	if dl.exif {
+		rec = append(rec, d.exif...)
+	}
+	return rec
+}
+
+// fetch downloads the image, unless an image of the same name matching
+// its checksum is already there and overwrite is not set. Downloads
+// started are completed when ctx is canceled, but not retried.
+func (d *download) fetch(ctx context.Context, dl *downloader) {
+	if !dl.overwrite && d.downloaded(dl) {
+		logger.Debug("image already downloaded", "link", d.link, "path", d.path)
+		d.present = true
+		return
+	}
+	host := linkHost(d.link)
+	for i := 0; ; i++ {
+		if d.err = dl.hosts.acquire(ctx, host); d.err != nil {
+			return
+		}
+		var sum string
+		d.path, sum, d.err = dl.fetchAllowed(context.WithoutCancel(ctx), d.link, filepath.Join(dl.dir, d.name))
+		dl.hosts.release(host)
+		if d.err == nil {
+			d.sum = sum
+			d.err = d.store(dl, sum)
+			return
+		}
+		var terr *transientError
+		if !errors.As(d.err, &terr) || i == dl.retries {
+			return
+		}
+		wait := time.Second << i
+		logger.Warn("retrying image download", "link", d.link, "error", d.err, "wait", wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// downloaded reports whether the image was downloaded already, setting
+// its path if so.
+func (d *download) downloaded(dl *downloader) bool {
+	if dl.index != nil {
+		rel, ok := dl.index.lookup(d.link)
+		path := filepath.Join(dl.dir, rel)
+		if ok && (dl.conv == nil || filepath.Ext(path) == imageFormatExt(dl.conv.format)) && verifyCAS(path) {
+			d.path = path
+			return true
+		}
+		return false
+	}
+	pattern := d.name + ".*"
+	if dl.conv != nil {
+		// Images downloaded before being converted are not.
+		pattern = d.name + imageFormatExt(dl.conv.format)
+	}
+	m, _ := filepath.Glob(filepath.Join(dl.dir, pattern))
+	for _, path := range m {
+		if dl.sums.verify(path) {
+			d.path = path
+			return true
+		}
+	}
+	return false
+}
+
+// store records the image downloaded, of checksum sum, moving it to
+// its path by content if dl.index is set.
+func (d *download) store(dl *downloader, sum string) error {
+	if dl.index == nil {
+		return dl.sums.add(d.path, sum)
+	}
+	rel, err := storeCAS(dl.dir, d.path, sum)
+	if err != nil {
+		return err
+	}
+	d.path = filepath.Join(dl.dir, rel)
+	return dl.index.add(d.query, d.link, rel)
+}
+
+// transientError is a download failure that may not happen again, the
+// partial image being kept to resume the download.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// fetchAllowed downloads the image at link to base as fetchImage does,
+// if allowed by dl.robots.
+func (dl *downloader) fetchAllowed(ctx context.Context, link, base string) (string, string, error) {
+	if dl.robots != nil {
+		ok, err := dl.robots.allowed(ctx, link)
+		if err != nil {
+			return "", "", err
+		}
+		if !ok {
+			return "", "", fmt.Errorf("disallowed by robots.txt")
+		}
+	}
+	return dl.fetchImage(ctx, link, base)
+}
+
+// fetchImage downloads the image at link to base, with the extension of
+// its type appended, converted by dl.conv if set, and returns its path
+// and checksum. The image is written to a hidden partial file first, so
+// that only complete images are found in the directory, and whose
+// download is resumed if a previous one failed.
+func (dl *downloader) fetchImage(ctx context.Context, link, base string) (string, string, error) {
+	part := filepath.Join(filepath.Dir(base), "."+filepath.Base(base)+".part")
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	off, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("User-Agent", dl.agent)
+	if off > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+	resp, err := dl.client.Do(req)
+	if err != nil {
+		return "", "", &transientError{err}
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && off > 0 &&
+		strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", off)):
+		logger.Debug("resuming image download", "link", link, "offset", off)
+	case resp.StatusCode == http.StatusOK:
+		// Ranges are not supported, or there is no partial file.
+		off = 0
+		if err := f.Truncate(0); err != nil {
+			return "", "", err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", "", err
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file does not match the image anymore.
+		f.Truncate(0)
+		return "", "", &transientError{fmt.Errorf("unexpected status %s", resp.Status)}
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return "", "", &transientError{fmt.Errorf("unexpected status %s", resp.Status)}
+	default:
+		return "", "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var body io.Reader = resp.Body
+	ct := resp.Header.Get("Content-Type")
+	if genericType(ct) {
+		// The type of the image is told by its first bytes, sniffed when
+		// the download was started if resumed.
+		br := bufio.NewReader(resp.Body)
+		if off == 0 {
+			head, _ := br.Peek(512)
+			ct = http.DetectContentType(head)
+		} else {
+			head := make([]byte, 512)
+			n, _ := f.ReadAt(head, 0)
+			ct = http.DetectContentType(head[:n])
+		}
+		body = br
+	}
+	if !imageType(ct) {
+		os.Remove(part)
+		return "", "", fmt.Errorf("unexpected content type %q", ct)
+	}
+	// Images announced larger are not downloaded at all.
+	if resp.ContentLength > 0 && off+resp.ContentLength > dl.maxBytes {
+		os.Remove(part)
+		return "", "", fmt.Errorf("image larger than %d bytes", dl.maxBytes)
+	}
+
+	if dl.limiter != nil {
+		body = &limitedReader{ctx: ctx, r: body, l: dl.limiter}
+	}
+	n, err := io.Copy(f, io.LimitReader(body, dl.maxBytes+1-off))
+	if err != nil {
+		return "", "", &transientError{err}
+	}
+	if off+n > dl.maxBytes {
+		os.Remove(part)
+		return "", "", fmt.Errorf("image larger than %d bytes", dl.maxBytes)
+	}
+	if err := f.Close(); err != nil {
+		return "", "", err
+	}
+	path := base + imageExt(ct, link)
+	if dl.conv != nil {
+		path = base + imageFormatExt(dl.conv.format)
+		err = dl.conv.convert(part, path)
+		os.Remove(part)
+	} else {
+		err = os.Rename(part, path)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	sum, err := fileChecksum(path)
+	if err != nil {
+		return "", "", err
+	}
+	return path, sum, nil
+}
+
+func runDownload(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	o := registerOptions(fs)
+	i := fs.String("i", "-", "Input file containing the records whose images are downloaded, such as a batch output. csv encoded, use - for stdin.")
+	out := fs.String("o", "-", "Output where the records are written, with the path of their image appended: - for stdout, or a file.")
+	dir := fs.String("dir", "images", "Directory where the images are downloaded.")
+	c := fs.Int("c", 3, "Selects the column holding the query, for images named after it.")
+	l := fs.Int("l", -1, "Selects the column holding the image link. Negative values count from the last column, -1.")
+	naming := fs.String("name", "hash", "Naming of the images: \"hash\", after the hash of their link, \"query\", after their query and the hash of their link, such as cat-3fa1b2c4, or \"content\", stored after their SHA-256 in sharded subdirectories, such as 3f/a1/3fa1...9c.jpg, and indexed in the index.csv file of \"dir\".")
+	overwrite := fs.Bool("overwrite", false, "Download the images already present in \"dir\" again.")
+	j := fs.Int("j", 4, "Maximum number of concurrent downloads.")
+	convert := fs.String("convert", "", "Format the images are converted to, without their metadata: jpeg or png. Kept as downloaded if empty.")
+	convertQuality := fs.Int("convert-quality", 90, "Quality of the images converted to JPEG, from 1 to 100.")
+	thumbSize := fs.String("thumb-size", "", "Maximum size of the thumbnails made of the images, such as 256x256, their path being appended to the records after the image one. Disabled if empty.")
+	thumbDir := fs.String("thumb-dir", "", "Directory where the thumbnails are written. Defaults to the thumbs subdirectory of \"dir\".")
+	thumbFormat := fs.String("thumb-format", "jpeg", "Format of the thumbnails: jpeg or png.")
+	thumbQuality := fs.Int("thumb-quality", 85, "Quality of the JPEG thumbnails, from 1 to 100.")
+	manifest := fs.String("manifest", "", "Optional file where the provenance of the images is written: query, path, link, source page, license, dimensions, checksum and fetch time. csv encoded if its extension is .csv, JSON otherwise. Source pages are taken from the cache, if enabled.")
+	license := fs.String("license", "", "License of the images recorded in the manifest, such as the usage rights they were searched with, as searches do not report it.")
+	maxBytes := fs.Int64("max-bytes", 32<<20, "Size in bytes above which images are not downloaded, and reported as failed.")
+	bandwidth := fs.Int64("bandwidth", 0, "Maximum bandwidth of all the downloads together, in bytes per second. 0 means unlimited.")
+	info := fs.Bool("info", false, "Append the SHA-256, size in bytes and content type, detected from their content, of the images to the records, after their paths.")
+	hostConcurrency := fs.Int("host-concurrency", 2, "Maximum number of concurrent downloads from the same host, within \"j\".")
+	hostDelay := fs.Duration("host-delay", 0, "Minimum delay between the starts of the downloads from the same host.")
+	agent := fs.String("user-agent", "dic", "User agent of the downloads, whose rules are followed in the robots.txt of the hosts.")
+	ignoreRobots := fs.Bool("ignore-robots", false, "Download the images disallowed by the robots.txt of their host.")
+	upload := fs.String("upload", "", "Optional s3://<bucket>/<prefix>, gs://<bucket>/<prefix>, azblob://<container>/<prefix>, sftp://[<user>@]<host>/<dir> or webdav(s)://[<user>@]<host>/<dir> URL where the images are uploaded, their URL replacing their link in the records. SFTP and WebDAV passwords may be set with SFTP_PASSWORD and WEBDAV_PASSWORD. {query} and {date} in the prefix are replaced with the query of the images and the date, such as s3://images/{date}/{query}.")
+	gcsPublic := fs.Bool("gcs-public", false, "Make the images uploaded to Google Cloud Storage readable by anyone. Not supported by buckets with uniform access.")
+	gcsSigned := fs.Duration("gcs-signed", 0, "If positive, write URLs of the images uploaded to Google Cloud Storage signed for that long, such as 168h, instead of their plain URL.")
+	azureSAS := fs.Duration("azure-sas", 0, "If positive, write URLs of the images uploaded to Azure Blob Storage with a read-only SAS valid for that long, such as 168h, instead of their plain URL.")
+	ipfsAPI := fs.String("ipfs", "", "Optional URL of the RPC API of an IPFS node, such as http://127.0.0.1:5001, or of a pinning service compatible with it, where the images are added and pinned, their CID being appended to the records.")
+	ipfsToken := fs.String("ipfs-token", os.Getenv("IPFS_API_TOKEN"), "Bearer token of the \"ipfs\" API, if required. Defaults to IPFS_API_TOKEN.")
+	archive := fs.String("zip", "", "Optional zip archive where the images are written once downloaded, in the images directory by query, with their thumbnails, records in records.csv and manifest in manifest.json, whose paths are those in the archive.")
+	dataURIMax := fs.Int64("data-uri", 0, "Inline the images of at most this many bytes in the records as base64 data URIs, replacing their link, for outputs without external links. 0 disables it.")
+	paletteSize := fs.Int("palette", 0, "Append the given number of colors most common in the images, as hex codes such as #1a2b3c, the dominant one first, to the records, after their CID. Images of fewer colors have empty ones.")
+	exifMeta := fs.Bool("exif", false, "Append the capture date, camera, latitude and longitude of the images, as recorded in their EXIF metadata, to the records, after their palette. Empty if missing, as in the images converted.")
+	retries := fs.Int("retries", 3, "Number of times the downloads failing with network or server errors are retried, the partial images being resumed.")
+	fs.Usage = usageFor(fs, "download [flags]", `Downloads the images linked by the records of a csv input, such as the
+output of batch, to a directory. Images already downloaded are skipped, as
+told by their checksum in the SHA256SUMS file of the directory, or by the
+index of the images stored by content. Images disallowed by the robots.txt
+of their host are not downloaded, unless "ignore-robots" is set.`)
+	fs.Parse(args)
+	o.load(fs)
+
+	switch {
+	case *naming != "hash" && *naming != "query" && *naming != "content":
+		exitf("invalid naming %q, expected hash, query or content", *naming)
+	case *j <= 0:
+		exitf("concurrency must be positive, got %d", *j)
+	case *hostConcurrency <= 0:
+		exitf("host concurrency must be positive, got %d", *hostConcurrency)
+	case *hostDelay < 0:
+		exitf("host delay must be positive, got %v", *hostDelay)
+	case *retries < 0:
+		exitf("retries must be positive, got %d", *retries)
+	case *maxBytes <= 0:
+		exitf("max bytes must be positive, got %d", *maxBytes)
+	case *paletteSize < 0:
+		exitf("palette size must be positive, got %d", *paletteSize)
+	case *dataURIMax < 0:
+		exitf("data uri size must be positive, got %d", *dataURIMax)
+	case *bandwidth < 0:
+		exitf("bandwidth must be positive, got %d", *bandwidth)
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		exitf("unable to create images directory: %v", err)
+	}
+	dl := &downloader{
+		dir:        *dir,
+		info:       *info,
+		palette:    *paletteSize,
+		exif:       *exifMeta,
+		dataURIMax: *dataURIMax,
+		overwrite:  *overwrite,
+		retries:    *retries,
+		maxBytes:   *maxBytes,
+		limiter:    newBandwidthLimiter(*bandwidth),
+		client:     newDownloadClient(*bandwidth > 0),
+		hosts:      newHostLimiter(*hostConcurrency, *hostDelay),
+		agent:      *agent,
+	}
+	if *upload != "" {
+		up, err := newImageUploader(ctx, *upload, uploadOptions{
+			gcsPublic: *gcsPublic,
+			gcsSigned: *gcsSigned,
+			azureSAS:  *azureSAS,
+		})
+		if err != nil {
+			exitf(err.Error())
+		}
+		dl.upload = up
+	}
+	if *ipfsAPI != "" {
+		dl.ipfs = newIPFSAdder(*ipfsAPI, *ipfsToken)
+	}
+	if !*ignoreRobots {
+		dl.robots = newRobotsChecker(*agent, dl.client)
+	}
+	if *manifest != "" || *archive != "" {
+		dl.manifest, dl.store, dl.license = true, o.store(), *license
+	}
+	dl.archive = *archive != ""
+	if *naming == "content" {
+		idx, err := openCASIndex(*dir)
+		if err != nil {
+			exitf(err.Error())
+		}
+		defer idx.close()
+		dl.index = idx
+	} else {
+		sums, err := openChecksums(*dir)
+		if err != nil {
+			exitf(err.Error())
+		}
+		defer sums.close()
+		dl.sums = sums
+	}
+	if *convert != "" {
+		switch {
+		case *convert != "jpeg" && *convert != "png":
+			exitf("invalid conversion format %q, expected jpeg or png", *convert)
+		case *convertQuality < 1 || *convertQuality > 100:
+			exitf("conversion quality must be between 1 and 100, got %d", *convertQuality)
+		}
+		dl.conv = &converter{format: *convert, quality: *convertQuality}
+	}
+	if *thumbSize != "" {
+		w, h, err := parseSize(*thumbSize)
+		if err != nil {
+			exitf(err.Error())
+		}
+		switch {
+		case *thumbFormat != "jpeg" && *thumbFormat != "png":
+			exitf("invalid thumbnail format %q, expected jpeg or png", *thumbFormat)
+		case *thumbQuality < 1 || *thumbQuality > 100:
+			exitf("thumbnail quality must be between 1 and 100, got %d", *thumbQuality)
+		}
+		if *thumbDir == "" {
+			*thumbDir = filepath.Join(*dir, "thumbs")
+		}
+		if err := os.MkdirAll(*thumbDir, 0o755); err != nil {
+			exitf("unable to create thumbnails directory: %v", err)
+		}
+		dl.thumbs = &thumbnailer{dir: *thumbDir, width: w, height: h, format: *thumbFormat, quality: *thumbQuality}
+	}
+	r, err := openInputFile(*i)
+	if err != nil {
+		exitf(err.Error())
+	}
+	defer r.Close()
+	var w io.WriteCloser = nopCloser{os.Stdout}
+	if *out != "-" {
+		f, err := createAtomic(*out)
+		if err != nil {
+			exitf(err.Error())
+		}
+		atExit(f.discard)
+		w = f
+	}
+
+	res, err := downloadAll(ctx, r, w, dl, *c, *l, *naming, *j)
+	if cerr := w.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("unable to write output: %w", cerr)
+	}
+	if ctx.Err() != nil {
+		exit(exitCanceled)
+	}
+	if err != nil {
+		exitf(err.Error())
+	}
+	if f, ok := w.(*atomicFile); ok {
+		if err := f.commit(); err != nil {
+			exitf(err.Error())
+		}
+	}
+	if *manifest != "" {
+		if err := writeManifest(*manifest, res.entries); err != nil {
+			exitf("unable to write manifest: %v", err)
+		}
+	}
+	if *archive != "" {
+		if err := writeArchive(*archive, dl, res.downloads); err != nil {
+			exitf("unable to write archive: %v", err)
+		}
+	}
+	notice("images downloaded", "downloaded", res.downloaded, "present", res.present, "failed", res.failed)
+	if res.failed > 0 {
+		exit(exitRowErrors)
+	}
+}
+
+// downloadResult accounts for the records of a download run.
+type downloadResult struct {
+	downloaded, present, failed int
+	// entries are the manifest entries of the images, if recorded, and
+	// downloads the downloads completed, if archived.
+	entries   []*manifestEntry
+	downloads []*download
+}
+
+// downloadAll downloads the images of the records of r with dl, writing
+// the records with the path of their image appended to w, in the same
+// order. Records whose image cannot be downloaded are not written.
+// Images being downloaded when ctx is canceled are completed, but the
+// run fails with the error of ctx.
+func downloadAll(ctx context.Context, r io.Reader, w io.Writer, dl *downloader, c, l int, naming string, maxcc int) (*downloadResult, error) {
+	res := &downloadResult{}
+	csvr := csv.NewReader(r)
+	csvr.FieldsPerRecord = -1
+	csvw := csv.NewWriter(w)
+	// The reader and the writer, canceled once either fails, which stops
+	// the downloads waiting for their host as well.
+	g, gctx := errgroup.WithContext(ctx)
+	// The downloads, up to maxcc at once.
+	var workers errgroup.Group
+	workers.SetLimit(maxcc)
+	// The downloads, in input order, up to one per worker ahead of the
+	// writer.
+	tx := make(chan *download, maxcc)
+
+	g.Go(func() error {
+		defer close(tx)
+		for row := 1; ; row++ {
+			if err := gctx.Err(); err != nil {
+				// Canceled, as told by ctx, or the writer failed, as
+				// it reports first.
+				return err
+			}
+			rec, err := csvr.Read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("unable to read input: %w", err)
+			}
+			li := l
+			if li < 0 {
+				li += len(rec)
+			}
+			d := &download{rec: rec, li: li, done: make(chan struct{})}
+			switch {
+			case li < 0 || li >= len(rec):
+				d.err = fmt.Errorf("row %d: tried to access column %d out of %d", row, l, len(rec))
+				close(d.done)
+			case rec[li] == "":
+				d.err = fmt.Errorf("row %d: link missing", row)
+				close(d.done)
+			default:
+				var q string
+				if c < len(rec) {
+					q = rec[c]
+				}
+				d.query, d.link = q, rec[li]
+				d.name = imageName(naming, q, d.link)
+				workers.Go(func() error {
+					d.run(gctx, dl)
+					return nil
+				})
+			}
+			select {
+			case tx <- d:
+			case <-gctx.Done():
+				// d is not written, possibly once downloaded.
+				return gctx.Err()
+			}
+		}
+	})
+	g.Go(func() error {
+		for d := range tx {
+			<-d.done
+			if d.err != nil {
+				res.failed++
+				logger.Error("unable to download image", "link", d.link, "error", d.err)
+				continue
+			}
+			err := csvw.Write(d.record(dl, d.path, d.thumb))
+			if err == nil {
+				csvw.Flush()
+				err = csvw.Error()
+			}
+			if err != nil {
+				return fmt.Errorf("unable to write output: %w", err)
+			}
+			if d.entry != nil {
+				res.entries = append(res.entries, d.entry)
+			}
+			if dl.archive {
+				res.downloads = append(res.downloads, d)
+			}
+			if d.present {
+				res.present++
+			} else {
+				res.downloaded++
+				logger.Info("image downloaded", "link", d.link, "path", d.path)
+			}
+		}
+		return nil
+	})
+
+	err := g.Wait()
+	workers.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
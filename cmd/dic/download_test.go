@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchImageRange(t *testing.T) {
+	img := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte("x"), 1000)...)
+	const partial = 100
+	for _, tt := range []struct {
+		name      string
+		partial   int
+		handler   func(w http.ResponseWriter, r *http.Request)
+		transient bool // set if the download fails transiently.
+		wantPart  int  // size of the partial file left on failure.
+	}{
+		{
+			name: "no partial file",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") != "" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.Write(img)
+			},
+		},
+		{
+			name:    "resumed",
+			partial: partial,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") != fmt.Sprintf("bytes=%d-", partial) {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.Header().Set("Content-Type", "image/png")
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", partial, len(img)-1, len(img)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(img[partial:])
+			},
+		},
+		{
+			name:    "resumed, type sniffed",
+			partial: partial,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", partial, len(img)-1, len(img)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(img[partial:])
+			},
+		},
+		{
+			name:    "ranges not supported",
+			partial: partial,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write(img)
+			},
+		},
+		{
+			name:    "partial file not matching",
+			partial: partial,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			},
+			transient: true,
+		},
+		{
+			name:    "other range served",
+			partial: partial,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(img)-1, len(img)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(img)
+			},
+			wantPart: partial,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(tt.handler))
+			defer srv.Close()
+			dir := t.TempDir()
+			base := filepath.Join(dir, "cat")
+			part := filepath.Join(dir, ".cat.part")
+			if tt.partial > 0 {
+				if err := os.WriteFile(part, img[:tt.partial], 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			dl := &downloader{client: srv.Client(), maxBytes: 1 << 20, agent: "dic"}
+
+			path, sum, err := dl.fetchImage(context.Background(), srv.URL+"/cat.png", base)
+			if tt.transient || tt.wantPart > 0 {
+				var terr *transientError
+				if err == nil || errors.As(err, &terr) != tt.transient {
+					t.Fatalf("got %v, want transient %t", err, tt.transient)
+				}
+				fi, err := os.Stat(part)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fi.Size() != int64(tt.wantPart) {
+					t.Errorf("got partial file of %d bytes, want %d", fi.Size(), tt.wantPart)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, img) {
+				t.Errorf("got %d bytes, want the %d of the image", len(b), len(img))
+			}
+			if want, _ := fileChecksum(path); sum != want {
+				t.Errorf("got checksum %s, want %s", sum, want)
+			}
+			if _, err := os.Stat(part); !os.IsNotExist(err) {
+				t.Errorf("partial file left: %v", err)
+			}
+		})
+	}
+}
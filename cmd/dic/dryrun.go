@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// runPlan describes the work a batch would perform.
+type runPlan struct {
+	rows    int // records read.
+	invalid int // records missing the word column.
+	hits    int // records served by the cache.
+	calls   int // live search API calls.
+}
+
+// planBatch reads the csv input from r and computes how many records
+// would be served by the cache and how many would require a search.
+// Repeated words are searched only once during a run.
+func planBatch(r io.Reader, c int, store resultStore) (*runPlan, error) {
+	p := &runPlan{}
+	seen := make(map[string]bool)
+	csvr := csv.NewReader(r)
+	for {
+		rec, err := csvr.Read()
+		if errors.Is(err, io.EOF) {
+			return p, nil
+		}
+		if err != nil {
+			return p, fmt.Errorf("unable to read input: %w", err)
+		}
+		p.rows++
+		if c >= len(rec) {
+			p.invalid++
+			continue
+		}
+
+		k := queryKey(rec[c])
+		if seen[k] {
+			p.hits++
+			continue
+		}
+		seen[k] = true
+		if store != nil {
+			_, ok, err := store.Get(k)
+			if err != nil {
+				logger.Error("unable to read cache", "query", k, "error", err)
+			}
+			if ok {
+				p.hits++
+				continue
+			}
+		}
+		p.calls++
+	}
+}
+
+// print writes the plan to w. used is the number of billable queries
+// already issued today, which affects the estimated cost.
+func (p *runPlan) print(w io.Writer, provider string, used int) {
+	fmt.Fprintf(w, "rows:       %d\n", p.rows)
+	fmt.Fprintf(w, "invalid:    %d\n", p.invalid)
+	fmt.Fprintf(w, "cache hits: %d\n", p.hits)
+	fmt.Fprintf(w, "api calls:  %d\n", p.calls)
+	pr, ok := pricing[provider]
+	if !ok {
+		return
+	}
+	fmt.Fprintf(w, "provider:   %s\n", provider)
+	fmt.Fprintf(w, "est. quota: %d+%d/%d free daily queries\n", used, p.calls, pr.freePerDay)
+	fmt.Fprintf(w, "est. cost:  $%.2f\n", pr.estimate(p.calls, used))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+	"github.com/discursive-image/dic/journal"
+)
+
+// providerPricing describes how a provider bills its queries.
+type providerPricing struct {
+	freePerDay int
+	// estimate returns the cost in USD of n queries, given that used
+	// queries were already issued today.
+	estimate func(n, used int) float64
+}
+
+var pricing = map[string]providerPricing{
+	providerGoogle: {google.FreeQueriesPerDay, google.EstimateCost},
+}
+
+// usedToday counts the calls to provider recorded in the journal at
+// path since local midnight. Returns 0 if path is empty.
+func usedToday(path, provider string) (int, error) {
+	if path == "" {
+		return 0, nil
+	}
+	f, err := os.Open(expandHome(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to open journal: %w", err)
+	}
+	defer f.Close()
+
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var n int
+	err = journal.Read(f, func(e *journal.Entry) error {
+		if e.Provider == provider && !e.Time.Before(midnight) {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// spoolInput copies the records of remote inputs, such as stdin, to a
+// temporary file, so that they can be read more than once. Returns the
+// path to read and a function removing the temporary file, if any.
+func spoolInput(ctx context.Context, in *input) (string, func(), error) {
+	if !in.remote() {
+		return in.path, func() {}, nil
+	}
+	r, err := in.open(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+	f, err := os.CreateTemp("", "dic-input-*.csv")
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to spool input: %w", err)
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("unable to spool input: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("unable to spool input: %w", err)
+	}
+	return f.Name(), cleanup, nil
+}
+
+// confirm asks a yes/no question on the controlling terminal, as
+// stdin may be carrying the input.
+func confirm(question string) (bool, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false, fmt.Errorf("unable to ask for confirmation, no terminal available: %w", err)
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "%s [y/N] ", question)
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("unable to read confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
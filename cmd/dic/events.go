@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// progressEvent reports the progress of a batch job. Type is "start"
+// when the job starts, "row" for each processed record, and "summary"
+// once the job is over.
+type progressEvent struct {
+	Type string    `json:"type"`
+	Job  string    `json:"job"`
+	Time time.Time `json:"time"`
+	// Processed and Failed count the records processed so far, in row
+	// events.
+	Processed int        `json:"processed,omitempty"`
+	Failed    int        `json:"failed,omitempty"`
+	Row       *rowResult `json:"row,omitempty"`
+	Summary   *summary   `json:"summary,omitempty"`
+}
+
+func newRowEvent(job string, r *ImageRequest, sum *summary) *progressEvent {
+	row := newRowResult(r)
+	return &progressEvent{
+		Type:      "row",
+		Job:       job,
+		Time:      time.Now(),
+		Processed: sum.Rows,
+		Failed:    sum.Failed,
+		Row:       &row,
+	}
+}
+
+// eventSink delivers progress events.
+type eventSink interface {
+	send(ctx context.Context, b []byte) error
+}
+
+// redisChannel publishes the events to a Redis channel.
+type redisChannel struct {
+	rdb     *redis.Client
+	channel string
+}
+
+func (c *redisChannel) send(ctx context.Context, b []byte) error {
+	return c.rdb.Publish(ctx, c.channel, b).Err()
+}
+
+// eventWebhook posts the events as JSON to url.
+type eventWebhook struct {
+	url string
+}
+
+func (w *eventWebhook) send(ctx context.Context, b []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// eventPublisher delivers progress events to its sinks in the
+// background, so that slow sinks do not slow down the jobs: events are
+// dropped when too many are pending. A nil publisher discards the
+// events.
+type eventPublisher struct {
+	sinks []eventSink
+	ch    chan *progressEvent
+	done  chan struct{}
+
+	mu      sync.RWMutex
+	closed  bool
+	dropped int
+}
+
+func newEventPublisher(sinks ...eventSink) *eventPublisher {
+	p := &eventPublisher{
+		sinks: sinks,
+		ch:    make(chan *progressEvent, 1024),
+		done:  make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// publish queues e. It does not block, and may be called with statusMu
+// held.
+func (p *eventPublisher) publish(e *progressEvent) {
+	if p == nil {
+		return
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.ch <- e:
+	default:
+		go p.drop(e)
+	}
+}
+
+func (p *eventPublisher) drop(e *progressEvent) {
+	p.mu.Lock()
+	p.dropped++
+	n := p.dropped
+	p.mu.Unlock()
+	// Log the first drop of each thousand, to avoid flooding.
+	if n%1000 == 1 {
+		logger.Warn("progress events dropped", "job", e.Job, "dropped", n)
+	}
+}
+
+func (p *eventPublisher) run() {
+	defer close(p.done)
+	for e := range p.ch {
+		b, err := json.Marshal(e)
+		if err != nil {
+			logger.Error("unable to encode progress event", "job", e.Job, "error", err)
+			continue
+		}
+		for _, s := range p.sinks {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := s.send(ctx, b); err != nil {
+				logger.Warn("unable to deliver progress event", "job", e.Job, "type", e.Type, "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// close delivers the pending events. Later events are discarded.
+func (p *eventPublisher) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.ch)
+	}
+	p.mu.Unlock()
+	<-p.done
+}
+
+// eventOptions are the flags of the commands running batch jobs.
+type eventOptions struct {
+	redisURL *string
+	channel  *string
+	webhook  *string
+}
+
+func registerEventOptions(fs *flag.FlagSet) *eventOptions {
+	return &eventOptions{
+		redisURL: fs.String("events-redis-url", "", "Optional Redis server URL where the progress events of batch jobs are published, to \"events-channel\"."),
+		channel:  fs.String("events-channel", "dic:events", "Redis channel where progress events are published."),
+		webhook:  fs.String("events-webhook", "", "Optional URL where the progress events of batch jobs are posted as JSON."),
+	}
+}
+
+func (eo *eventOptions) applyConfig(fs *flag.FlagSet, cfg *config) error {
+	return applyConfig(fs, map[string]string{
+		"events-redis-url": cfg.Events.RedisURL,
+		"events-channel":   cfg.Events.Channel,
+		"events-webhook":   cfg.Events.Webhook,
+	})
+}
+
+// publisher returns the publisher of the configured sinks, or nil if
+// there are none.
+func (eo *eventOptions) publisher() *eventPublisher {
+	var sinks []eventSink
+	if *eo.redisURL != "" {
+		rdb, err := newRedisClient(*eo.redisURL)
+		if err != nil {
+			exitf(err.Error())
+		}
+		sinks = append(sinks, &redisChannel{rdb: rdb, channel: *eo.channel})
+	}
+	if *eo.webhook != "" {
+		sinks = append(sinks, &eventWebhook{url: *eo.webhook})
+	}
+	if len(sinks) == 0 {
+		return nil
+	}
+	return newEventPublisher(sinks...)
+}
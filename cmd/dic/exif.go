@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// exifColumns is the number of columns of exifInfo.
+const exifColumns = 4
+
+// exifInfo returns the capture date, camera, latitude and longitude of
+// the image at path, as recorded in its EXIF metadata, each empty if
+// missing. Dates are in RFC 3339 format, without offset if their time
+// zone is not recorded.
+func exifInfo(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info := make([]string, exifColumns)
+	x, err := exif.Decode(f)
+	if x == nil {
+		// Images without metadata are common, and not errors.
+		logger.Debug("no exif metadata", "path", path, "error", err)
+		return info, nil
+	}
+	if t, err := x.DateTime(); err == nil {
+		if tz, _ := x.TimeZone(); tz != nil {
+			info[0] = t.Format("2006-01-02T15:04:05Z07:00")
+		} else {
+			info[0] = t.Format("2006-01-02T15:04:05")
+		}
+	}
+	var camera []string
+	for _, name := range []exif.FieldName{exif.Make, exif.Model} {
+		if tag, err := x.Get(name); err == nil {
+			if s, err := tag.StringVal(); err == nil && strings.TrimSpace(s) != "" {
+				camera = append(camera, strings.TrimSpace(s))
+			}
+		}
+	}
+	info[1] = strings.Join(camera, " ")
+	if lat, long, err := x.LatLong(); err == nil {
+		info[2] = strconv.FormatFloat(lat, 'f', 6, 64)
+		info[3] = strconv.FormatFloat(long, 'f', 6, 64)
+	}
+	return info, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sync"
+
+	_ "embed"
+
+	pigo "github.com/esimov/pigo/core"
+	"golang.org/x/image/draw"
+)
+
+// Face modes of the images: required or forbidden.
+const (
+	facesRequire = "require"
+	facesForbid  = "forbid"
+)
+
+// facefinder is the face detection cascade of pigo, copied from its
+// repository along with its license.
+//
+//go:embed cascade/facefinder
+var facefinder []byte
+
+var faceDetector = sync.OnceValues(func() (*pigo.Pigo, error) {
+	return pigo.NewPigo().Unpack(facefinder)
+})
+
+// faceImageSize is the maximum size, in pixels, of the images faces are
+// detected in, larger ones being scaled down first.
+const faceImageSize = 640
+
+// faceMinQuality is the minimum score of the faces detected.
+const faceMinQuality = 5
+
+// hasFace reports whether a face is detected in img.
+func hasFace(img image.Image) (bool, error) {
+	pg, err := faceDetector()
+	if err != nil {
+		return false, fmt.Errorf("unable to load face detector: %w", err)
+	}
+	b := img.Bounds()
+	w, h := fit(b.Dx(), b.Dy(), faceImageSize, faceImageSize)
+	small := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, b, draw.Src, nil)
+
+	// Faces smaller than a tenth of the image do not make it a portrait.
+	dets := pg.RunCascade(pigo.CascadeParams{
+		MinSize:     max(20, min(w, h)/10),
+		MaxSize:     min(w, h),
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{
+			Pixels: pigo.RgbToGrayscale(small),
+			Rows:   h,
+			Cols:   w,
+			Dim:    w,
+		},
+	}, 0)
+	for _, d := range pg.ClusterDetections(dets, 0.2) {
+		if d.Q >= faceMinQuality {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// facesMismatch reports whether the image of c has faces although
+// forbidden, or none although required, by the faces mode of s. Images
+// that cannot be checked do not.
+func (s *selector) facesMismatch(c *candidate) bool {
+	link := c.link
+	found, err := candidateHasFace(c)
+	if err != nil {
+		logger.Warn("unable to detect image faces", "link", link, "error", err)
+		return false
+	}
+	if found != (s.faces == facesRequire) {
+		logger.Debug("image faces mismatch", "link", link, "faces", found, "mode", s.faces)
+		return true
+	}
+	return false
+}
+
+// candidateHasFace reports whether a face is detected in the image of c.
+func candidateHasFace(c *candidate) (bool, error) {
+	img, err := c.image()
+	if err != nil {
+		return false, err
+	}
+	return hasFace(img)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+)
+
+// pageMaxBytes is the size of the beginning of the pages read for their
+// metadata.
+const pageMaxBytes = 1 << 20
+
+var (
+	// pageDateMeta matches the meta tags of the publication date of a
+	// page, whose content is matched by metaContent.
+	pageDateMeta = regexp.MustCompile(`(?i)<meta[^>]+(?:property|name|itemprop)\s*=\s*["'](?:article:published_time|og:published_time|datePublished|pubdate|publishdate|date|dc\.date\.issued|dcterms\.created)["'][^>]*>`)
+	metaContent  = regexp.MustCompile(`(?i)content\s*=\s*["']([^"']+)["']`)
+	// pageDateLD matches the publication date of the structured data of
+	// a page.
+	pageDateLD = regexp.MustCompile(`"datePublished"\s*:\s*"([^"]+)"`)
+)
+
+// pageDateLayouts are the layouts of the dates of the pages.
+var pageDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123,
+	time.RFC1123Z,
+}
+
+func parsePageDate(s string) (time.Time, bool) {
+	for _, layout := range pageDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// pageDate returns the publication date of the page at link, as told by
+// its meta tags or structured data, and whether it is.
+func pageDate(link string) (time.Time, bool, error) {
+	resp, err := candidateClient.Get(link)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, pageMaxBytes))
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	var dates []string
+	for _, tag := range pageDateMeta.FindAll(b, -1) {
+		if m := metaContent.FindSubmatch(tag); m != nil {
+			dates = append(dates, string(m[1]))
+		}
+	}
+	if m := pageDateLD.FindSubmatch(b); m != nil {
+		dates = append(dates, string(m[1]))
+	}
+	for _, s := range dates {
+		if t, ok := parsePageDate(s); ok {
+			return t, true, nil
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+// stale reports whether the page the image of isr was found on was
+// published before the maximum page age of s. Images whose page date is
+// unknown are not, the provider restricting the dates already.
+func (s *selector) stale(isr *google.ISR) bool {
+	if isr.Image == nil || isr.Image.ContextLink == "" {
+		return false
+	}
+	t, ok, err := pageDate(isr.Image.ContextLink)
+	if err != nil {
+		logger.Debug("unable to read page date", "page", isr.Image.ContextLink, "error", err)
+		return false
+	}
+	if ok && time.Since(t) > s.maxPageAge {
+		logger.Debug("skipping image of stale page", "link", isr.Link, "page", isr.Image.ContextLink, "published", t)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+// jobSnapshot is a copy of a job taken under statusMu, so that it can
+// be resolved while the job runs.
+type jobSnapshot struct {
+	id       string
+	status   string
+	created  time.Time
+	finished *time.Time
+	summary  summary
+	rows     []rowResult
+}
+
+func snapshot(j *job) *jobSnapshot {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	sum := *j.Summary
+	sum.RowErrors = maps.Clone(j.Summary.RowErrors)
+	return &jobSnapshot{
+		id:       j.ID,
+		status:   j.Status,
+		created:  j.Created,
+		finished: j.Finished,
+		summary:  sum,
+		// Rows are only appended: the prefix is stable.
+		rows: j.rows[:len(j.rows):len(j.rows)],
+	}
+}
+
+// jobList returns the jobs submitted by the client authenticated in
+// ctx, newest first.
+func (s *server) jobList(ctx context.Context) []*job {
+	client := clientName(ctx)
+	s.mu.Lock()
+	jobs := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		if j.client == client {
+			jobs = append(jobs, j)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(jobs, func(i, k int) bool {
+		if a, b := jobs[i].Created, jobs[k].Created; !a.Equal(b) {
+			return a.After(b)
+		}
+		return jobs[i].ID < jobs[k].ID
+	})
+	return jobs
+}
+
+// nullable returns nil for an empty string, so that it is resolved
+// as null.
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func summaryMap(s *summary) map[string]interface{} {
+	msgs := make([]string, 0, len(s.RowErrors))
+	for k := range s.RowErrors {
+		msgs = append(msgs, k)
+	}
+	sort.Strings(msgs)
+	rowErrors := make([]interface{}, len(msgs))
+	for i, k := range msgs {
+		rowErrors[i] = map[string]interface{}{"message": k, "count": s.RowErrors[k]}
+	}
+	return map[string]interface{}{
+		"rows":      s.Rows,
+		"succeeded": s.Succeeded,
+		"failed":    s.Failed,
+		"cacheHits": s.CacheHits,
+		"apiCalls":  s.APICalls,
+		"elapsed":   s.Elapsed,
+		"canceled":  s.Canceled,
+		"error":     nullable(s.Error),
+		"rowErrors": rowErrors,
+	}
+}
+
+func rowMap(r *rowResult) map[string]interface{} {
+	return map[string]interface{}{
+		"row":      r.Row,
+		"query":    r.Query,
+		"link":     nullable(r.Link),
+		"cacheHit": r.CacheHit,
+		"error":    nullable(r.Error),
+	}
+}
+
+// jobField returns a field resolved from a job snapshot.
+func jobField(t graphql.Output, f func(*jobSnapshot) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type: t,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return f(p.Source.(*jobSnapshot)), nil
+		},
+	}
+}
+
+// pageSize returns the "first" argument of a paginated field.
+func pageSize(p graphql.ResolveParams) (int, error) {
+	n, _ := p.Args["first"].(int)
+	if n <= 0 {
+		return 0, fmt.Errorf("first must be positive, got %d", n)
+	}
+	return n, nil
+}
+
+// newGraphQLSchema returns the schema of the GraphQL API served by s.
+func newGraphQLSchema(s *server) (graphql.Schema, error) {
+	filters := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "Filters",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"type": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Image type (clipart|face|lineart|news|photo).",
+			},
+			"size": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Image size (huge|icon|large|medium|small|xlarge|xxlarge).",
+			},
+		},
+	})
+	searchResult := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchResult",
+		Fields: graphql.Fields{
+			"query":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"link":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"cacheHit": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+	rowError := graphql.NewObject(graphql.ObjectConfig{
+		Name: "RowError",
+		Fields: graphql.Fields{
+			"message": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"count":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+	summaryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Summary",
+		Fields: graphql.Fields{
+			"rows":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"succeeded": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"failed":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"cacheHits": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"apiCalls":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"elapsed":   &graphql.Field{Type: graphql.NewNonNull(graphql.Float), Description: "Elapsed time, in seconds."},
+			"canceled":  &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"error":     &graphql.Field{Type: graphql.String, Description: "Fatal error that aborted the batch, if any."},
+			"rowErrors": &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(rowError))},
+		},
+	})
+	row := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Row",
+		Fields: graphql.Fields{
+			"row":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Description: "Row number in the input, from 1."},
+			"query":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"link":     &graphql.Field{Type: graphql.String},
+			"cacheHit": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"error":    &graphql.Field{Type: graphql.String},
+		},
+	})
+	rowPage := graphql.NewObject(graphql.ObjectConfig{
+		Name: "RowPage",
+		Fields: graphql.Fields{
+			"rows":        &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(row))},
+			"total":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Description: "Number of rows processed so far."},
+			"endCursor":   &graphql.Field{Type: graphql.Int, Description: "Row number to pass as \"after\" to get the next page."},
+			"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+	jobType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Job",
+		Fields: graphql.Fields{
+			"id":       jobField(graphql.NewNonNull(graphql.ID), func(j *jobSnapshot) interface{} { return j.id }),
+			"status":   jobField(graphql.NewNonNull(graphql.String), func(j *jobSnapshot) interface{} { return j.status }),
+			"created":  jobField(graphql.NewNonNull(graphql.DateTime), func(j *jobSnapshot) interface{} { return j.created }),
+			"finished": jobField(graphql.DateTime, func(j *jobSnapshot) interface{} { return j.finished }),
+			"summary":  jobField(graphql.NewNonNull(summaryType), func(j *jobSnapshot) interface{} { return summaryMap(&j.summary) }),
+			"rows": &graphql.Field{
+				Type:        graphql.NewNonNull(rowPage),
+				Description: "Outcome of the records processed so far, in input order.",
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 100},
+					"after": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					j := p.Source.(*jobSnapshot)
+					n, err := pageSize(p)
+					if err != nil {
+						return nil, err
+					}
+					// Rows are numbered from 1, in order.
+					after, _ := p.Args["after"].(int)
+					if after < 0 || after > len(j.rows) {
+						return nil, fmt.Errorf("invalid cursor %d", after)
+					}
+					page := j.rows[after:]
+					if len(page) > n {
+						page = page[:n]
+					}
+					rows := make([]interface{}, len(page))
+					for i := range page {
+						rows[i] = rowMap(&page[i])
+					}
+					res := map[string]interface{}{
+						"rows":        rows,
+						"total":       len(j.rows),
+						"hasNextPage": after+len(page) < len(j.rows),
+					}
+					if len(page) > 0 {
+						res["endCursor"] = page[len(page)-1].Row
+					}
+					return res, nil
+				},
+			},
+		},
+	})
+	jobPage := graphql.NewObject(graphql.ObjectConfig{
+		Name: "JobPage",
+		Fields: graphql.Fields{
+			"jobs":        &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(jobType))},
+			"total":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"endCursor":   &graphql.Field{Type: graphql.ID, Description: "Job ID to pass as \"after\" to get the next page."},
+			"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"searchImage": &graphql.Field{
+				Type:        searchResult,
+				Description: "Returns the first image found for query, or null if there is none.",
+				Args: graphql.FieldConfigArgument{
+					"query":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"filters": &graphql.ArgumentConfig{Type: filters},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					q, _ := p.Args["query"].(string)
+					if q == "" {
+						return nil, fmt.Errorf("query missing")
+					}
+					f, _ := p.Args["filters"].(map[string]interface{})
+					typ, _ := f["type"].(string)
+					size, _ := f["size"].(string)
+					rw := s.search(p.Context, q, typ, size)
+					switch {
+					case errors.Is(rw.err, errNoResults):
+						return nil, nil
+					case rw.err != nil:
+						return nil, rw.err
+					}
+					return map[string]interface{}{
+						"query":    q,
+						"link":     rw.rec[len(rw.rec)-1],
+						"cacheHit": rw.hit,
+					}, nil
+				},
+			},
+			"job": &graphql.Field{
+				Type: jobType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, _ := p.Args["id"].(string)
+					j, ok := s.job(p.Context, id)
+					if !ok {
+						return nil, nil
+					}
+					return snapshot(j), nil
+				},
+			},
+			"jobs": &graphql.Field{
+				Type:        graphql.NewNonNull(jobPage),
+				Description: "Batch jobs, newest first.",
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 20},
+					"after": &graphql.ArgumentConfig{Type: graphql.ID},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					n, err := pageSize(p)
+					if err != nil {
+						return nil, err
+					}
+					all := s.jobList(p.Context)
+					start := 0
+					if after, _ := p.Args["after"].(string); after != "" {
+						start = -1
+						for i, j := range all {
+							if j.ID == after {
+								start = i + 1
+								break
+							}
+						}
+						if start < 0 {
+							return nil, fmt.Errorf("invalid cursor %q", after)
+						}
+					}
+					page := all[start:]
+					if len(page) > n {
+						page = page[:n]
+					}
+					jobs := make([]interface{}, len(page))
+					for i, j := range page {
+						jobs[i] = snapshot(j)
+					}
+					res := map[string]interface{}{
+						"jobs":        jobs,
+						"total":       len(all),
+						"hasNextPage": start+len(page) < len(all),
+					}
+					if len(page) > 0 {
+						res["endCursor"] = page[len(page)-1].ID
+					}
+					return res, nil
+				},
+			},
+		},
+	})
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"submitBatch": &graphql.Field{
+				Type:        graphql.NewNonNull(jobType),
+				Description: "Starts an asynchronous batch searching for each query.",
+				Args: graphql.FieldConfigArgument{
+					"queries": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+					"filters": &graphql.ArgumentConfig{Type: filters},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					qs, _ := p.Args["queries"].([]interface{})
+					req := &batchRequest{}
+					for _, q := range qs {
+						req.Records = append(req.Records, []string{q.(string)})
+					}
+					f, _ := p.Args["filters"].(map[string]interface{})
+					req.Type, _ = f["type"].(string)
+					req.Size, _ = f["size"].(string)
+					data, err := encodeRecords(req.Records)
+					if err != nil {
+						return nil, err
+					}
+					return snapshot(s.start(p.Context, req, data)), nil
+				},
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    query,
+		Mutation: mutation,
+	})
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLHandler serves schema, accepting JSON posted queries.
+func (s *server) graphQLHandler(schema graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBody)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unable to decode request: %w", err))
+			return
+		}
+		if req.Query == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("query missing"))
+			return
+		}
+
+		// Query errors are reported in the result, as usual.
+		res := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			OperationName:  req.OperationName,
+			VariableValues: req.Variables,
+			Context:        r.Context(),
+		})
+		writeJSON(w, http.StatusOK, res)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+	"github.com/discursive-image/dic/imagesearch"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// grpcServer implements the ImageSearch gRPC service on top of the
+// HTTP server pipeline and filters.
+type grpcServer struct {
+	imagesearch.UnimplementedImageSearchServer
+	s *server
+}
+
+// searchErrorCode maps a search failure to a gRPC status code, the way
+// searchErrorStatus maps it to an HTTP status.
+func searchErrorCode(err error) codes.Code {
+	var e *google.Error
+	var ue *url.Error
+	switch {
+	case errors.Is(err, errNoResults):
+		return codes.NotFound
+	case errors.As(err, &e) && e.Quota():
+		return codes.ResourceExhausted
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, errDeadLinks), errors.As(err, &e), errors.As(err, &ue):
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+func (g *grpcServer) Search(ctx context.Context, req *imagesearch.SearchRequest) (*imagesearch.SearchResponse, error) {
+	if req.Query == "" {
+		return nil, grpcstatus.Error(codes.InvalidArgument, "query missing")
+	}
+
+	rw := g.s.search(ctx, req.Query, req.Type, req.Size)
+	if rw.err != nil {
+		return nil, grpcstatus.Error(searchErrorCode(rw.err), rw.err.Error())
+	}
+	return &imagesearch.SearchResponse{
+		Query:    req.Query,
+		Link:     rw.rec[len(rw.rec)-1],
+		CacheHit: rw.hit,
+	}, nil
+}
+
+// copyRecords writes req and the records received afterwards from
+// stream to w, as csv, until the client closes the stream.
+func copyRecords(stream imagesearch.ImageSearch_BatchServer, req *imagesearch.BatchRequest, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	for {
+		if len(req.Fields) > 0 {
+			// Flush each record, so that its search starts while the
+			// client is still streaming.
+			cw.Write(req.Fields)
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+		}
+		var err error
+		req, err = stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (g *grpcServer) Batch(stream imagesearch.ImageSearch_BatchServer) error {
+	req, err := stream.Recv()
+	eof := errors.Is(err, io.EOF)
+	if err != nil && !eof {
+		return err
+	}
+	if eof {
+		req = &imagesearch.BatchRequest{}
+	}
+
+	p := *g.s.pipeline(stream.Context())
+	if o := req.Options; o != nil {
+		if o.Column != nil {
+			if *o.Column < 0 {
+				return grpcstatus.Errorf(codes.InvalidArgument, "invalid column %d", *o.Column)
+			}
+			p.c = int(*o.Column)
+		}
+		p.opts = g.s.filters(o.Type, o.Size)
+	}
+
+	r, w := io.Pipe()
+	if eof {
+		w.Close()
+	} else {
+		go func() {
+			w.CloseWithError(copyRecords(stream, req, w))
+		}()
+	}
+
+	logger.Info("grpc batch started")
+	var out bytes.Buffer
+	sum := newSummary()
+	p.process(stream.Context(), r, flushWriter{csv.NewWriter(&out)}, sum, nil)
+	// Unblock the copy, in case processing was aborted.
+	r.Close()
+	sum.close()
+	logger.Info("grpc batch finished", "rows", sum.Rows, "failed", sum.Failed)
+
+	recs, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		return grpcstatus.Errorf(codes.Internal, "unable to decode records: %v", err)
+	}
+	res := &imagesearch.BatchResponse{
+		Records: make([]*imagesearch.Record, len(recs)),
+		Summary: sum.proto(),
+	}
+	for i, rec := range recs {
+		res.Records[i] = &imagesearch.Record{Fields: rec}
+	}
+	return stream.SendAndClose(res)
+}
+
+// proto returns the summary as a gRPC message.
+func (s *summary) proto() *imagesearch.Summary {
+	m := &imagesearch.Summary{
+		Rows:      int32(s.Rows),
+		Succeeded: int32(s.Succeeded),
+		Failed:    int32(s.Failed),
+		CacheHits: int32(s.CacheHits),
+		ApiCalls:  int32(s.APICalls),
+		Elapsed:   s.Elapsed,
+		Canceled:  s.Canceled,
+		Error:     s.Error,
+	}
+	if len(s.RowErrors) > 0 {
+		m.RowErrors = make(map[string]int32, len(s.RowErrors))
+		for k, v := range s.RowErrors {
+			m.RowErrors[k] = int32(v)
+		}
+	}
+	return m
+}
+
+// serveGRPC serves the ImageSearch service at addr until ctx is done.
+func serveGRPC(ctx context.Context, addr string, s *server) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		exitf("unable to listen for gRPC: %v", err)
+	}
+	gs := grpc.NewServer(
+		grpc.MaxRecvMsgSize(int(s.maxBody)),
+		grpc.ChainUnaryInterceptor(s.auth.unaryInterceptor, s.limiter.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.auth.streamInterceptor, s.limiter.streamInterceptor),
+	)
+	imagesearch.RegisterImageSearchServer(gs, &grpcServer{s: s})
+	go func() {
+		<-ctx.Done()
+		t := time.AfterFunc(10*time.Second, gs.Stop)
+		defer t.Stop()
+		gs.GracefulStop()
+	}()
+
+	notice("serving gRPC", "addr", addr)
+	if err := gs.Serve(l); err != nil {
+		exitf("gRPC server failed: %v", err)
+	}
+}
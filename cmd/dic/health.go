@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/discursive-image/dic/google"
+)
+
+var (
+	authMu sync.Mutex
+	// authErrs holds the last authentication failure of each search
+	// client, cleared by its next successful search.
+	authErrs = make(map[*google.SC]error)
+)
+
+// observeAuth tracks the authentication failures of sc, from the
+// outcome of its searches. Readiness probes rely on it rather than on
+// searching themselves, which would spend quota.
+func observeAuth(sc *google.SC, err error) {
+	var e *google.Error
+	authMu.Lock()
+	defer authMu.Unlock()
+	switch {
+	case err == nil:
+		delete(authErrs, sc)
+	case errors.As(err, &e) && e.Auth():
+		authErrs[sc] = err
+	}
+}
+
+// checkProvider reports whether sc is expected to be authorized.
+func checkProvider(sc *google.SC) error {
+	if err := sc.Validate(); err != nil {
+		return err
+	}
+	authMu.Lock()
+	defer authMu.Unlock()
+	return authErrs[sc]
+}
+
+// pinger is implemented by the result stores that can check their
+// connectivity.
+type pinger interface {
+	Ping() error
+}
+
+// checkCache reports whether store, the persistent cache of a pipeline,
+// is usable, if any.
+func checkCache(store resultStore) error {
+	if p, ok := store.(pinger); ok {
+		return p.Ping()
+	}
+	return nil
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+type readiness struct {
+	Status string `json:"status"`
+	// Checks maps each check to "ok" or its error.
+	Checks map[string]string `json:"checks"`
+}
+
+// handleReadyz checks the cache and the provider credentials of the
+// server and tenant pipelines. It fails while the server shuts down.
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	res := &readiness{Status: "ok", Checks: make(map[string]string)}
+	check := func(name string, err error) {
+		if err != nil {
+			res.Status = "unavailable"
+			res.Checks[name] = err.Error()
+			return
+		}
+		res.Checks[name] = "ok"
+	}
+
+	select {
+	case <-s.ctx.Done():
+		check("server", errors.New("shutting down"))
+	default:
+		check("server", nil)
+	}
+	check("cache", checkCache(s.p.caches.store))
+	check("provider", checkProvider(s.p.gsc))
+	names := make([]string, 0, len(s.tenants))
+	for k := range s.tenants {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		p := s.tenants[k]
+		check(k+".cache", checkCache(p.caches.store))
+		check(k+".provider", checkProvider(p.gsc))
+	}
+
+	status := http.StatusOK
+	if res.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, res)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostLimiter limits the concurrent requests made to each host, and
+// spaces the requests made to a host by a delay.
+type hostLimiter struct {
+	n     int
+	delay time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlots
+}
+
+type hostSlots struct {
+	sem chan struct{}
+
+	mu   sync.Mutex
+	next time.Time // at which the next request may start.
+}
+
+func newHostLimiter(n int, delay time.Duration) *hostLimiter {
+	return &hostLimiter{n: n, delay: delay, hosts: make(map[string]*hostSlots)}
+}
+
+// linkHost returns the host of link, lowercased, or link itself if it
+// cannot be parsed.
+func linkHost(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func (l *hostLimiter) slots(host string) *hostSlots {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.hosts[host]
+	if !ok {
+		s = &hostSlots{sem: make(chan struct{}, l.n)}
+		l.hosts[host] = s
+	}
+	return s
+}
+
+// acquire waits until a request may be made to host, which must be
+// released once done.
+func (l *hostLimiter) acquire(ctx context.Context, host string) error {
+	s := l.slots(host)
+	select {
+	case s.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if l.delay <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	now := time.Now()
+	start := s.next
+	if start.Before(now) {
+		start = now
+	}
+	s.next = start.Add(l.delay)
+	s.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(start)):
+		return nil
+	case <-ctx.Done():
+		<-s.sem
+		return ctx.Err()
+	}
+}
+
+func (l *hostLimiter) release(host string) {
+	<-l.slots(host).sem
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dumpTransport writes the outbound requests and their raw responses
+// to w, replacing the secrets with a placeholder.
+type dumpTransport struct {
+	next    http.RoundTripper
+	secrets []string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (t *dumpTransport) redact(b []byte) string {
+	s := string(b)
+	for _, v := range t.secrets {
+		if v != "" {
+			s = strings.ReplaceAll(s, v, "REDACTED")
+		}
+	}
+	return s
+}
+
+func (t *dumpTransport) write(header string, b []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "### %s %s\n%s\n\n", time.Now().Format(time.RFC3339Nano), header, t.redact(b))
+}
+
+func (t *dumpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := fmt.Sprintf("%p", req)
+	if b, err := httputil.DumpRequestOut(req, true); err == nil {
+		t.write("request "+id, b)
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.write("error "+id, []byte(err.Error()))
+		return nil, err
+	}
+	// DumpResponse restores the body after reading it.
+	if b, err := httputil.DumpResponse(resp, true); err == nil {
+		t.write("response "+id, b)
+	}
+	return resp, nil
+}
+
+// newDumpClient returns an HTTP client dumping its traffic to the
+// file at path. Occurrences of secrets are redacted.
+func newDumpClient(path string, secrets ...string) (*http.Client, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open http dump file: %w", err)
+	}
+	atExit(func() { f.Close() })
+	return &http.Client{
+		Transport: &dumpTransport{
+			next:    http.DefaultTransport,
+			secrets: secrets,
+			w:       f,
+		},
+	}, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ipfsAdder adds images to IPFS through the RPC API of a node, or of a
+// pinning service compatible with it, pinning them.
+type ipfsAdder struct {
+	api    string
+	token  string // optional bearer token.
+	client *http.Client
+}
+
+func newIPFSAdder(api, token string) *ipfsAdder {
+	return &ipfsAdder{
+		api:    strings.TrimSuffix(api, "/"),
+		token:  token,
+		client: &http.Client{Timeout: downloadTimeout},
+	}
+}
+
+// add adds the image at path, and returns its CID.
+func (a *ipfsAdder) add(ctx context.Context, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	req, err := http.NewRequestWithContext(ctx, "POST", a.api+"/api/v0/add?pin=true&cid-version=1", pr)
+	if err != nil {
+		pr.Close()
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to add image to ipfs: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("unable to add image to ipfs: unexpected status %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	var added struct {
+		Hash string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&added); err != nil {
+		return "", fmt.Errorf("unable to decode ipfs response: %w", err)
+	}
+	if added.Hash == "" {
+		return "", fmt.Errorf("ipfs response without cid")
+	}
+	return added.Hash, nil
+}
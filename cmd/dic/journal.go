@@ -0,0 +1,40 @@
+package main
+
+import (
+	"time"
+
+	"github.com/discursive-image/dic/google"
+	"github.com/discursive-image/dic/journal"
+)
+
+// journalCall appends a provider call searching q for term to j, which
+// may be nil. Failures are logged: they must not break the run, but
+// cannot go unnoticed.
+func journalCall(j *journal.Writer, term, q string, items []*google.ISR, link string, err error) {
+	if j == nil {
+		return
+	}
+	e := &journal.Entry{
+		Time:     time.Now(),
+		Query:    q,
+		Provider: providerGoogle,
+		Link:     link,
+		Items:    items,
+	}
+	if term != q {
+		e.Term = term
+	}
+	switch {
+	case err != nil:
+		e.Status = journal.StatusError
+		e.Error = err.Error()
+	case len(items) == 0:
+		e.Status = journal.StatusNoResults
+	default:
+		e.Status = journal.StatusOK
+	}
+	if err := j.Append(e); err != nil {
+		logger.Error("unable to journal provider call", "query", q, "error", err)
+		reportError(err, false, nil)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// kafkaOffset is a record being processed.
+type kafkaOffset struct {
+	rec  *kgo.Record
+	done bool
+}
+
+// kafkaQueue is a queue backed by a pair of Kafka topics, the input one
+// consumed as a member of a consumer group. The offset of a record is
+// committed once its result, and the ones of all the records before it
+// in its partition, are produced: those being processed when the
+// worker dies are consumed again.
+type kafkaQueue struct {
+	cl  *kgo.Client
+	out string
+
+	mu sync.Mutex
+	// pending holds the records of each partition in offset order, up
+	// to the last one processed.
+	pending map[int32][]*kafkaOffset
+	// err is the first failure to produce a result, which stops the
+	// worker since the record cannot be consumed again until then.
+	err error
+}
+
+func newKafkaQueue(brokers []string, in, out, group string) (*kafkaQueue, error) {
+	q := &kafkaQueue{out: out, pending: make(map[int32][]*kafkaOffset)}
+	cl, err := kgo.NewClient(
+		kgo.SeedBrokers(brokers...),
+		kgo.ClientID("dic"),
+		kgo.ConsumerGroup(group),
+		kgo.ConsumeTopics(in),
+		kgo.DisableAutoCommit(),
+		kgo.OnPartitionsRevoked(q.drop),
+		kgo.OnPartitionsLost(q.drop),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka configuration: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cl.Ping(ctx); err != nil {
+		cl.Close()
+		return nil, fmt.Errorf("unable to connect to kafka: %w", err)
+	}
+	q.cl = cl
+	return q, nil
+}
+
+// drop forgets the records of the partitions no longer assigned to the
+// worker. Their offsets, if not committed yet, will be consumed again
+// by the new owner.
+func (q *kafkaQueue) drop(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, ps := range lost {
+		for _, p := range ps {
+			delete(q.pending, p)
+		}
+	}
+}
+
+func (q *kafkaQueue) receive(ctx context.Context) (*delivery, error) {
+	for {
+		q.mu.Lock()
+		err := q.err
+		q.mu.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("unable to produce result: %w", err)
+		}
+
+		fs := q.cl.PollRecords(ctx, 1)
+		if err := fs.Err0(); err != nil {
+			return nil, err
+		}
+		if errs := fs.Errors(); len(errs) > 0 {
+			return nil, errs[0].Err
+		}
+		recs := fs.Records()
+		if len(recs) == 0 {
+			continue
+		}
+		off := q.track(recs[0])
+		return &delivery{
+			body: off.rec.Value,
+			reply: func(ctx context.Context, m *queryMessage, res []byte) error {
+				return q.reply(ctx, off, m, res)
+			},
+		}, nil
+	}
+}
+
+// reply produces the result of off, keyed like it, and commits the
+// offsets processed since the last commit of its partition.
+func (q *kafkaQueue) reply(ctx context.Context, off *kafkaOffset, m *queryMessage, res []byte) error {
+	out := q.out
+	if m.ReplyTo != "" {
+		out = m.ReplyTo
+	}
+	rec := &kgo.Record{Topic: out, Key: off.rec.Key, Value: res}
+	if err := q.cl.ProduceSync(ctx, rec).FirstErr(); err != nil {
+		q.mu.Lock()
+		if q.err == nil {
+			q.err = err
+		}
+		q.mu.Unlock()
+		return err
+	}
+
+	// Committing under the lock keeps the commits of a partition in
+	// order.
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	last := q.processed(off)
+	if last == nil {
+		return nil
+	}
+	if err := q.cl.CommitRecords(ctx, last); err != nil {
+		return fmt.Errorf("unable to commit offset: %w", err)
+	}
+	return nil
+}
+
+// track adds rec to the records being processed.
+func (q *kafkaQueue) track(rec *kgo.Record) *kafkaOffset {
+	off := &kafkaOffset{rec: rec}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending[rec.Partition] = append(q.pending[rec.Partition], off)
+	return off
+}
+
+// processed marks off as processed, returning the last record of its
+// partition whose offset can be committed, nil if the partition was
+// revoked or records before off are still being processed. q.mu must
+// be held.
+func (q *kafkaQueue) processed(off *kafkaOffset) *kgo.Record {
+	off.done = true
+	p, ok := q.pending[off.rec.Partition]
+	if !ok {
+		return nil
+	}
+	var last *kgo.Record
+	for len(p) > 0 && p[0].done {
+		last, p = p[0].rec, p[1:]
+	}
+	q.pending[off.rec.Partition] = p
+	return last
+}
+
+func (q *kafkaQueue) close() error {
+	q.cl.Close()
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestKafkaProcessed(t *testing.T) {
+	q := &kafkaQueue{pending: make(map[int32][]*kafkaOffset)}
+	var offs []*kafkaOffset
+	for i := range 4 {
+		offs = append(offs, q.track(&kgo.Record{Partition: int32(i % 2), Offset: int64(i / 2)}))
+	}
+	other := q.track(&kgo.Record{Partition: 0, Offset: 2})
+
+	for _, tt := range []struct {
+		off  *kafkaOffset
+		want *kafkaOffset
+	}{
+		// Partition 0 holds offs[0], offs[2] and other, partition 1
+		// offs[1] and offs[3].
+		{offs[2], nil},
+		{offs[3], nil},
+		{offs[0], offs[2]},
+		{offs[1], offs[3]},
+		{other, other},
+	} {
+		got := q.processed(tt.off)
+		switch {
+		case tt.want == nil && got != nil:
+			t.Errorf("%d/%d: got commit of %d, want none", tt.off.rec.Partition, tt.off.rec.Offset, got.Offset)
+		case tt.want != nil && got != tt.want.rec:
+			t.Errorf("%d/%d: got commit of %v, want %d", tt.off.rec.Partition, tt.off.rec.Offset, got, tt.want.rec.Offset)
+		}
+	}
+	for p, offs := range q.pending {
+		if len(offs) > 0 {
+			t.Errorf("partition %d: %d records still pending", p, len(offs))
+		}
+	}
+}
+
+func TestKafkaProcessedRevoked(t *testing.T) {
+	q := &kafkaQueue{pending: make(map[int32][]*kafkaOffset)}
+	first := q.track(&kgo.Record{Partition: 0, Offset: 0})
+	second := q.track(&kgo.Record{Partition: 0, Offset: 1})
+	q.drop(context.Background(), nil, map[string][]int32{"queries": {0}})
+
+	if got := q.processed(first); got != nil {
+		t.Errorf("got commit of %d, want none once revoked", got.Offset)
+	}
+	if got := q.processed(second); got != nil {
+		t.Errorf("got commit of %d, want none once revoked", got.Offset)
+	}
+	if _, ok := q.pending[0]; ok {
+		t.Errorf("revoked partition tracked again")
+	}
+}
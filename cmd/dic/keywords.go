@@ -0,0 +1,93 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jdkato/prose/v2"
+)
+
+// maxKeywords, if positive, is the number of keywords searched instead
+// of the terms longer than that many words, such as sentences.
+var maxKeywords int
+
+// tagModel is the model of the tagger, slow to load, which documents
+// are tagged with one at a time.
+var (
+	tagModel = sync.OnceValue(func() *prose.Model {
+		doc, _ := prose.NewDocument("", prose.WithSegmentation(false))
+		return doc.Model
+	})
+	tagMu sync.Mutex
+)
+
+// keyword is a named entity or a noun of a sentence.
+type keyword struct {
+	text   string
+	pos    int // of its first token.
+	entity bool
+}
+
+// keywords returns the n most imageable keywords of s, in their order:
+// its named entities first, then its nouns. s is returned as it is if it
+// has n words or fewer, or no keywords.
+func keywords(s string, n int) string {
+	if len(strings.Fields(s)) <= n {
+		return s
+	}
+	tagMu.Lock()
+	doc, err := prose.NewDocument(s, prose.WithSegmentation(false), prose.UsingModel(tagModel()))
+	tagMu.Unlock()
+	if err != nil {
+		logger.Warn("unable to tag query", "query", s, "error", err)
+		return s
+	}
+	var found []keyword
+	seen := make(map[string]bool)
+	add := func(k keyword) {
+		if l := strings.ToLower(k.text); !seen[l] && !stopwords[l] {
+			seen[l] = true
+			found = append(found, k)
+		}
+	}
+	tokens := doc.Tokens()
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case strings.HasPrefix(t.Label, "B-") || strings.HasPrefix(t.Tag, "NNP"):
+			// Named entities span the tokens labeled inside them, and
+			// the proper nouns following them, which the tagger labels
+			// less reliably.
+			words := []string{t.Text}
+			start := i
+			for i+1 < len(tokens) && (strings.HasPrefix(tokens[i+1].Label, "I-") || strings.HasPrefix(tokens[i+1].Tag, "NNP") && !strings.HasPrefix(tokens[i+1].Label, "B-")) {
+				i++
+				words = append(words, tokens[i].Text)
+			}
+			add(keyword{text: strings.Join(words, " "), pos: start, entity: true})
+		case strings.HasPrefix(t.Tag, "NN"):
+			add(keyword{text: t.Text, pos: i})
+		}
+	}
+	if len(found) == 0 {
+		return s
+	}
+	// Entities first, keeping the order of the sentence otherwise.
+	slices.SortStableFunc(found, func(a, b keyword) int {
+		switch {
+		case a.entity == b.entity:
+			return 0
+		case a.entity:
+			return -1
+		}
+		return 1
+	})
+	found = found[:min(n, len(found))]
+	slices.SortFunc(found, func(a, b keyword) int { return a.pos - b.pos })
+	texts := make([]string, len(found))
+	for i, k := range found {
+		texts[i] = k.text
+	}
+	return strings.Join(texts, " ")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/discursive-image/dic/google"
+)
+
+// languageDetector, if set, detects the language of each term, which
+// favors the results in that language.
+var languageDetector *termLanguages
+
+// termLanguages detects the language of terms with the Cloud
+// Translation API, remembering the languages of the run.
+type termLanguages struct {
+	tc *google.Translate
+
+	mu   sync.Mutex
+	done map[string]string
+}
+
+func newTermLanguages(tc *google.Translate) *termLanguages {
+	return &termLanguages{
+		tc:   tc,
+		done: make(map[string]string),
+	}
+}
+
+// detect returns the language of term, empty if it cannot be detected.
+func (l *termLanguages) detect(ctx context.Context, term string) string {
+	l.mu.Lock()
+	lang, ok := l.done[term]
+	l.mu.Unlock()
+	if ok {
+		return lang
+	}
+	lang, err := l.tc.Detect(ctx, term)
+	if err != nil {
+		logger.Warn("unable to detect query language", "query", term, "error", err)
+		return ""
+	}
+	logger.Debug("query language detected", "query", term, "language", lang)
+	l.mu.Lock()
+	l.done[term] = lang
+	l.mu.Unlock()
+	return lang
+}
+
+// termLanguage returns the language term is searched in: the one it is
+// translated to by queryTranslator, else the one detected by
+// languageDetector, if set.
+func termLanguage(ctx context.Context, term string) string {
+	switch {
+	case queryTranslator != nil:
+		return queryTranslator.target
+	case languageDetector != nil:
+		return languageDetector.detect(ctx, term)
+	}
+	return ""
+}
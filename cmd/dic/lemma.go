@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// irregularLemmas are the lemmas of common irregular English forms,
+// which suffix rules cannot find, leaving out the ones that are words
+// of their own as well, such as "saw" or "dice".
+var irregularLemmas = map[string]string{
+	"children": "child", "men": "man", "women": "woman", "people": "person",
+	"mice": "mouse", "geese": "goose", "feet": "foot", "teeth": "tooth",
+	"lice": "louse", "oxen": "ox", "wolves": "wolf",
+	"knives": "knife", "wives": "wife", "lives": "life", "leaves": "leaf",
+	"halves": "half", "shelves": "shelf", "loaves": "loaf", "thieves": "thief",
+	"cacti": "cactus", "fungi": "fungus", "nuclei": "nucleus", "octopi": "octopus",
+	"phenomena": "phenomenon", "criteria": "criterion",
+	"was": "be", "were": "be", "been": "be", "is": "be", "are": "be", "am": "be",
+	"went": "go", "gone": "go", "ran": "run", "running": "run", "ate": "eat",
+	"eaten": "eat", "seen": "see", "took": "take", "taken": "take",
+	"gave": "give", "given": "give", "came": "come", "made": "make", "making": "make",
+	"wrote": "write", "written": "write", "spoken": "speak",
+	"broke": "break", "broken": "break", "flew": "fly", "flown": "fly",
+	"swam": "swim", "swum": "swim", "swimming": "swim", "sang": "sing", "sung": "sing",
+	"drank": "drink", "drove": "drive", "driven": "drive",
+	"rode": "ride", "ridden": "ride", "fallen": "fall",
+	"grew": "grow", "grown": "grow", "threw": "throw", "thrown": "throw",
+	"caught": "catch", "taught": "teach", "bought": "buy", "brought": "bring",
+	"thought": "think", "fought": "fight", "sat": "sit", "sitting": "sit",
+	"stood": "stand", "slept": "sleep", "kept": "keep",
+	"built": "build", "sent": "send", "spent": "spend", "held": "hold",
+	"told": "tell", "sold": "sell", "found": "find",
+}
+
+// lemmas are the lemmas of inflected forms, by form, read from the
+// "lemmas" file on top of irregularLemmas.
+var lemmas = irregularLemmas
+
+// loadLemmas reads the lemmas of the file at path, one form and its
+// lemma per line, separated by whitespace, such as "mice mouse". Empty
+// lines and lines starting with # are skipped.
+func loadLemmas(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := make(map[string]string, len(irregularLemmas))
+	for form, lemma := range irregularLemmas {
+		m[form] = lemma
+	}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a form and its lemma, got %q", path, n, line)
+		}
+		m[strings.ToLower(fields[0])] = strings.ToLower(fields[1])
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// lemmatize replaces the inflected forms of s by their lemmas, such as
+// "running mice" by "run mouse", keeping the words whose lemma is
+// unknown and their surrounding punctuation.
+func lemmatize(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		core := strings.TrimFunc(w, unicode.IsPunct)
+		if lemma, ok := lemmas[strings.ToLower(core)]; ok && core != "" {
+			start := len(w) - len(strings.TrimLeftFunc(w, unicode.IsPunct))
+			words[i] = w[:start] + lemma + w[start+len(core):]
+		}
+	}
+	return strings.Join(words, " ")
+}
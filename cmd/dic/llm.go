@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+)
+
+// llmTimeout is the time allowed to an LLM to choose an image.
+const llmTimeout = time.Minute
+
+const llmPrompt = `You pick the image best illustrating a word or phrase, as used in a talk. You are given the query and numbered candidate images, with their title, snippet and page. Answer with the number of the most semantically appropriate image only.`
+
+// llmRanker chooses the most appropriate of the results of a query with
+// an LLM, through an OpenAI compatible chat completions endpoint.
+type llmRanker struct {
+	endpoint string
+	model    string
+	token    string // optional bearer token.
+	// images is set to send the thumbnails of the results as well, to
+	// models supporting them.
+	images bool
+	client *http.Client
+	// decisions, if set, persists the result chosen for each query.
+	decisions resultStore
+}
+
+func newLLMRanker(endpoint, model, token string, images bool, decisions resultStore) *llmRanker {
+	return &llmRanker{
+		endpoint:  endpoint,
+		model:     model,
+		token:     token,
+		images:    images,
+		client:    &http.Client{Timeout: llmTimeout},
+		decisions: decisions,
+	}
+}
+
+// llmMessage is a chat message, whose content is a string or parts.
+type llmMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type llmPart struct {
+	Type     string       `json:"type"`
+	Text     string       `json:"text,omitempty"`
+	ImageURL *llmImageURL `json:"image_url,omitempty"`
+}
+
+type llmImageURL struct {
+	URL string `json:"url"`
+}
+
+var llmAnswer = regexp.MustCompile(`\d+`)
+
+// choose returns the index of the result most appropriate to query.
+func (r *llmRanker) choose(ctx context.Context, query string, results []*google.ISR) (int, error) {
+	parts := []llmPart{{Type: "text", Text: "Query: " + query}}
+	for i, isr := range results {
+		desc := fmt.Sprintf("Image %d: %s\n%s", i+1, isr.Title, isr.Snippet)
+		if isr.Image != nil && isr.Image.ContextLink != "" {
+			desc += "\nPage: " + isr.Image.ContextLink
+		}
+		parts = append(parts, llmPart{Type: "text", Text: desc})
+		if r.images && isr.Image != nil && isr.Image.ThumbLink != "" {
+			parts = append(parts, llmPart{Type: "image_url", ImageURL: &llmImageURL{URL: isr.Image.ThumbLink}})
+		}
+	}
+	body, err := json.Marshal(struct {
+		Model       string       `json:"model,omitempty"`
+		Messages    []llmMessage `json:"messages"`
+		Temperature float64      `json:"temperature"`
+	}{
+		Model: r.model,
+		Messages: []llmMessage{
+			{Role: "system", Content: llmPrompt},
+			{Role: "user", Content: parts},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	var res struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return 0, fmt.Errorf("unable to decode response: %w", err)
+	}
+	if len(res.Choices) == 0 {
+		return 0, fmt.Errorf("response without choices")
+	}
+	answer := res.Choices[0].Message.Content
+	n, err := strconv.Atoi(llmAnswer.FindString(answer))
+	if err != nil || n < 1 || n > len(results) {
+		return 0, fmt.Errorf("unexpected answer %q", answer)
+	}
+	return n - 1, nil
+}
+
+// rerank returns results with the one chosen for query first, as
+// recorded in r.decisions or else chosen and recorded. Results are kept
+// as they are if none can be chosen.
+func (r *llmRanker) rerank(query string, results []*google.ISR) []*google.ISR {
+	chosen := -1
+	if r.decisions != nil {
+		if d, ok, err := r.decisions.Get(query); err == nil && ok && len(d) == 1 {
+			for i, isr := range results {
+				if isr.Link == d[0].Link {
+					chosen = i
+					break
+				}
+			}
+		}
+	}
+	if chosen < 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), llmTimeout)
+		defer cancel()
+		var err error
+		if chosen, err = r.choose(ctx, query, results); err != nil {
+			logger.Warn("unable to choose result with llm", "query", query, "error", err)
+			return results
+		}
+		logger.Debug("result chosen by llm", "query", query, "link", results[chosen].Link)
+		if r.decisions != nil {
+			if err := r.decisions.Set(query, results[chosen:chosen+1]); err != nil {
+				logger.Error("unable to write llm decision", "query", query, "error", err)
+			}
+		}
+	}
+	reranked := make([]*google.ISR, 0, len(results))
+	reranked = append(reranked, results[chosen])
+	reranked = append(reranked, results[:chosen]...)
+	return append(reranked, results[chosen+1:]...)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/discursive-image/dic/report"
+)
+
+// Log levels, in addition to the slog ones.
+const (
+	// levelNotice is used for messages printed by default, which
+	// do not report a problem.
+	levelNotice = slog.Level(2)
+	// levelFatal is used for the errors that abort the program.
+	levelFatal = slog.Level(12)
+)
+
+// logger is the program logger. Use setupLogger to configure it.
+var logger = slog.New(newLogHandler(os.Stderr, "text", levelNotice))
+
+// Log formats.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.LevelKey || len(groups) > 0 {
+		return a
+	}
+	switch a.Value.Any().(slog.Level) {
+	case levelNotice:
+		a.Value = slog.StringValue("NOTICE")
+	case levelFatal:
+		a.Value = slog.StringValue("FATAL")
+	}
+	return a
+}
+
+func newLogHandler(w io.Writer, format string, level slog.Level) slog.Handler {
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: replaceLevel,
+	}
+	if format == logFormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// setupLogger replaces the program logger with one emitting records
+// in format, starting from the level matching the verbosity. Records
+// are written to w, or to stderr if w is nil.
+func setupLogger(format string, verbosity int, w io.Writer) error {
+	if format != logFormatText && format != logFormatJSON {
+		return fmt.Errorf("unsupported log format %q", format)
+	}
+	level := levelNotice
+	switch {
+	case verbosity < levelDefault:
+		level = levelFatal
+	case verbosity == levelVerbose:
+		level = slog.LevelInfo
+	case verbosity >= levelDebug:
+		level = slog.LevelDebug
+	}
+	if w == nil {
+		w = statusWriter{os.Stderr}
+	}
+	logger = slog.New(newLogHandler(w, format, level))
+	return nil
+}
+
+// statusWriter writes to w keeping the progress status, if any, at
+// the bottom.
+type statusWriter struct {
+	w io.Writer
+}
+
+func (s statusWriter) Write(p []byte) (int, error) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	if status != nil {
+		status.clear()
+		defer status.draw()
+	}
+	return s.w.Write(p)
+}
+
+func notice(msg string, args ...any) {
+	logger.Log(context.Background(), levelNotice, msg, args...)
+}
+
+// exitf logs a fatal error, regardless of the verbosity, and exits.
+func exitf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	logger.Log(context.Background(), levelFatal, msg)
+	reportError(errors.New(msg), true, nil)
+	exit(exitFatal)
+}
+
+// reporter delivers unexpected failures, if configured.
+var reporter *report.Reporter
+
+func setupReporter(dsn, webhook string, interval time.Duration) error {
+	var sinks []report.Sink
+	if dsn != "" {
+		s, err := report.NewSentry(dsn)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, s)
+	}
+	if webhook != "" {
+		sinks = append(sinks, &report.Webhook{URL: webhook})
+	}
+	if len(sinks) > 0 {
+		reporter = report.New(interval, sinks...)
+	}
+	return nil
+}
+
+// reportError forwards err to the reporter, logging delivery failures.
+func reportError(err error, fatal bool, tags map[string]string) {
+	if rerr := reporter.Report(err, fatal, tags); rerr != nil {
+		logger.Error("unable to report error", "error", rerr)
+	}
+}
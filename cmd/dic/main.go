@@ -2,327 +2,143 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
-	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
-	"strconv"
-	"strings"
+	"sort"
 	"sync"
-	"time"
-
-	"github.com/discursive-image/dic/google"
 )
 
-func logf(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, os.Args[0]+" * "+format+"\n", args...)
-}
-
-func errorf(format string, args ...interface{}) {
-	logf("error: "+format, args...)
-}
-
-func exitf(format string, args ...interface{}) {
-	errorf(format, args...)
-	os.Exit(1)
-}
-
-func handleQSearch(ctx context.Context, gsc *google.SC, q string, opts ...func(url.Values)) {
-	items, err := gsc.SearchImages(ctx, q, opts...)
-	if err != nil {
-		exitf(err.Error())
-	}
-	switch {
-	case len(items) == 0:
-		fmt.Printf("no results\n")
-	default:
-		fmt.Println(items[0].Link)
-	}
-}
-
-func openInputFile(in string) (io.ReadCloser, error) {
-	if in == "-" {
-		return os.Stdin, nil
-	}
-
-	file, err := os.Open(in)
-	if err != nil {
-		return nil, fmt.Errorf("unable to open csv input reader: %w", err)
-	}
-	return file, nil
-}
-
-const maxcc int = 10
+// Verbosity levels.
+const (
+	levelQuiet   = -1 // only fatal errors.
+	levelDefault = 0  // errors and notices.
+	levelVerbose = 1  // per record outcomes.
+	levelDebug   = 2  // search and cache details.
+)
 
-type touchedImage struct {
-	image   *google.ISR
-	checked bool
-	valid   bool
-}
+// verbosity is the current verbosity level, set from the command line.
+var verbosity = levelDefault
 
-type imageRing struct {
-	all   []*touchedImage
-	index int
-}
+// Exit codes.
+const (
+	// All records were processed successfully.
+	exitOK = 0
+	// A fatal error, such as an input or output failure, aborted
+	// the run.
+	exitFatal = 1
+	// The command line was invalid.
+	exitUsage = 2
+	// The run completed but some records could not be enriched.
+	exitRowErrors = 3
+	// The run was aborted by a signal.
+	exitCanceled = 130
+)
 
-var fastClient = &http.Client{
-	Timeout: 2 * time.Second,
-}
+const (
+	envGoogleKey      = "GOOGLE_SEARCH_KEY"
+	envGoogleCx       = "GOOGLE_SEARCH_CX"
+	envSentryDSN      = "SENTRY_DSN"
+	envAPIKeys        = "DIC_API_KEYS"
+	envNSFWToken      = "NSFW_API_TOKEN"
+	envRelevanceToken = "RELEVANCE_API_TOKEN"
+	envLLMToken       = "LLM_API_TOKEN"
+)
 
-func discard(link string) bool {
-	resp, err := fastClient.Head(link)
-	if err != nil {
-		return true
-	}
-	resp.Body.Close()
+var (
+	exitMu    sync.Mutex
+	exitHooks []func()
+)
 
-	// Discard we do not get a positive HTTP response.
-	if resp.StatusCode >= 400 {
-		return true
-	}
-	// If content type is not image, discard.
-	t := resp.Header.Get("content-type")
-	if !strings.Contains(t, "image") {
-		return true
-	}
-	// If content-length is not greater than 0, discard.
-	l, err := strconv.Atoi(resp.Header.Get("content-length"))
-	if err != nil || l <= 0 {
-		return true
-	}
-	return false
+// atExit registers f to be called by exit, for example to flush
+// buffered telemetry. Hooks are run in reverse order.
+func atExit(f func()) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	exitHooks = append(exitHooks, f)
 }
 
-func (ir *imageRing) next() *google.ISR {
-	if len(ir.all) == 0 {
-		return nil
-	}
-
-	// Lazily check images before returning them.
+// exit runs the exit hooks and terminates the program with code.
+func exit(code int) {
+	exitMu.Lock()
+	hooks := exitHooks
+	exitHooks = nil
+	exitMu.Unlock()
 
-	var ti *touchedImage
-	var found bool
-	var index int
-	for i := ir.index; i < len(ir.all); i = (i + 1) % (len(ir.all) - 1) {
-		ti = ir.all[i]
-		if !ti.checked {
-			ti.valid = !discard(ti.image.Link)
-		}
-		if ti.valid {
-			found = true
-			index = i
-			break
-		}
-	}
-	if !found {
-		return nil
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
 	}
-	ir.index = (index + 1) % (len(ir.all) - 1)
-	return ti.image
+	os.Exit(code)
 }
 
-type ringCache struct {
-	sync.Mutex
-	m map[string]*imageRing
+// command is a dic subcommand. Each command parses its own flags
+// from args.
+type command struct {
+	run   func(ctx context.Context, args []string)
+	short string
 }
 
-func newRingCache() *ringCache {
-	return &ringCache{
-		m: make(map[string]*imageRing),
-	}
+var commands = map[string]command{
+	"search":   {runSearch, "search a single query and print the first image link"},
+	"batch":    {runBatch, "append image links to the records of a csv input"},
+	"download": {runDownload, "download the images linked by the records of a csv input"},
+	"daemon":   {runDaemon, "re-run batches periodically, as scheduled in the config file"},
+	"cache":    {runCache, "inspect and manage the persistent search cache"},
+	"replay":   {runReplay, "reconstruct a batch output from the audit journal"},
+	"serve":    {runServe, "serve searches and batches over HTTP"},
+	"shell":    {runShell, "search the queries typed on stdin interactively"},
+	"sheet":    {runSheet, "write a PDF contact sheet of downloaded images"},
+	"slides":   {runSlides, "write an HTML slideshow of terms and their images"},
+	"video":    {runVideo, "render an MP4 slideshow of downloaded images with ffmpeg"},
+	"watch":    {runWatch, "process the csv files dropped in a directory"},
+	"worker":   {runWorker, "search the queries received from a queue"},
 }
 
-func (c *ringCache) next(k string) (*google.ISR, bool) {
-	c.Lock()
-	defer c.Unlock()
-
-	ring, ok := c.m[k]
-	if !ok {
-		return nil, false
-	}
-	image := ring.next()
-	if image == nil {
-		// something is broken with this ring, delete it.
-		delete(c.m, k)
-		return nil, false
+// usageFor returns a usage function for a subcommand flag set.
+func usageFor(fs *flag.FlagSet, synopsis, description string) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s\n\n%s\n\nflags:\n", os.Args[0], synopsis, description)
+		fs.PrintDefaults()
 	}
-	return image, true
 }
 
-func (c *ringCache) set(k string, results []*google.ISR) {
-	c.Lock()
-	defer c.Unlock()
-
-	all := make([]*touchedImage, len(results))
-	for i, v := range results {
-		all[i] = &touchedImage{
-			image: v,
-		}
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for k := range commands {
+		names = append(names, k)
 	}
-	c.m[k] = &imageRing{
-		all:   all,
-		index: 0,
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", k, commands[k].short)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun \"%s <command> -h\" for the command flags.\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\nexit codes:\n")
+	fmt.Fprintf(os.Stderr, "  %-3d success\n", exitOK)
+	fmt.Fprintf(os.Stderr, "  %-3d fatal error, e.g. unable to read input or write output\n", exitFatal)
+	fmt.Fprintf(os.Stderr, "  %-3d invalid command line\n", exitUsage)
+	fmt.Fprintf(os.Stderr, "  %-3d completed, but some records could not be enriched\n", exitRowErrors)
+	fmt.Fprintf(os.Stderr, "  %-3d aborted by signal\n", exitCanceled)
 }
 
-type ImageRequest struct {
-	gsc   *google.SC
-	c     int
-	rec   []string
-	opts  []func(url.Values)
-	done  chan bool
-	err   error
-	cache *ringCache
-}
-
-func (r *ImageRequest) Run(ctx context.Context) {
-	defer func() { r.done <- true }()
-	if r.c >= len(r.rec) {
-		r.err = fmt.Errorf("tried to access column %d out of %d", r.c, len(r.rec))
-		return
-	}
-
-	k := r.rec[r.c]
-
-	// Check if the cache contains the value.
-	image, ok := r.cache.next(k)
-	if ok {
-		r.rec = append(r.rec, image.Link)
-		return
-	}
-
-	// If not, search for the image.
-	items, err := r.gsc.SearchImages(ctx, k, r.opts...)
-	if err != nil {
-		r.err = err
-		return
-	}
-	if len(items) == 0 {
-		r.err = fmt.Errorf("no results")
-		r.rec = append(r.rec, "")
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	name := os.Args[1]
+	switch name {
+	case "-h", "-help", "--help", "help":
+		usage()
 		return
 	}
-	r.cache.set(k, items)
-
-	image, ok = r.cache.next(k)
+	cmd, ok := commands[name]
 	if !ok {
-		r.err = fmt.Errorf("cache inconsistency")
-		r.rec = append(r.rec, "")
-		return
-	}
-	r.rec = append(r.rec, image.Link)
-}
-
-func (r *ImageRequest) Wait() {
-	<-r.done
-	return
-}
-
-func enqueueImageRequest(rx chan *ImageRequest, errc chan<- error) {
-	w := csv.NewWriter(os.Stdout)
-	for recw := range rx {
-		recw.Wait()
-		if err := recw.err; err != nil {
-			// This is a non critical error. The log is here to
-			// prevent records from being discarded silently.
-			errorf("unable to obtain link: %v", err)
-			continue
-		}
-		if err := w.Write(recw.rec); err != nil {
-			errc <- fmt.Errorf("unable to write record to stdout: %w", err)
-			return
-		}
-		w.Flush()
+		usage()
+		logger.Error("unknown command", "command", name)
+		os.Exit(exitUsage)
 	}
-}
-
-func handleSSearch(ctx context.Context, gsc *google.SC, in string, c int, opts ...func(url.Values)) {
-	r, err := openInputFile(in)
-	if err != nil {
-		exitf(err.Error())
-	}
-	defer r.Close()
-
-	csvr := csv.NewReader(r)          // the csv input reader.
-	sem := make(chan struct{}, maxcc) // concurrency semaphore.
-	errc := make(chan error)          // error channel, used for error reporting from writer.
-	tx := make(chan *ImageRequest)    // wrapped records transmitter.
-	cache := newRingCache()
-	defer close(tx)
-
-	go enqueueImageRequest(tx, errc)
-
-	for {
-		if err := func() error {
-			select {
-			case <-ctx.Done():
-				// In case of context cancelation, close the reader first
-				// and let the current searched images finish.
-				return ctx.Err()
-			case err := <-errc:
-				// This is critical: we're no longer able to write to stdout.
-				return err
-			default:
-				return nil
-			}
-		}(); err != nil {
-			errorf("exiting input processing loop: %v", err)
-			break
-		}
-
-		rec, err := csvr.Read()
-		if err != nil && errors.Is(err, io.EOF) {
-			break
-		}
-		if err != nil {
-			errorf("unable to read input: %v", err)
-			break
-		}
-
-		rw := &ImageRequest{
-			c:     c,
-			rec:   rec,
-			gsc:   gsc,
-			done:  make(chan bool),
-			cache: cache,
-		}
-
-		tx <- rw // send item though channel to preserve ordering.
-		sem <- struct{}{}
-
-		go func(rw *ImageRequest) {
-			defer func() { <-sem }()
-			_ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-			defer cancel()
-
-			rw.Run(_ctx) // Execute task in a different routine.
-		}(rw)
-	}
-
-	for i := 0; i < cap(sem); i++ {
-		sem <- struct{}{}
-	}
-}
-
-const (
-	envGoogleKey = "GOOGLE_SEARCH_KEY"
-	envGoogleCx  = "GOOGLE_SEARCH_CX"
-)
-
-func main() {
-	k := flag.String("k", os.Getenv(envGoogleKey), "Google API key.")
-	cx := flag.String("cx", os.Getenv(envGoogleCx), "Google custom search engine ID.")
-	q := flag.String("q", "", "Optional query to search for.")
-	t := flag.String("t", "undefined", "Image type to search for (clipart|face|lineart|news|photo).")
-	s := flag.String("s", "undefined", "Image size to search for (huge|icon|large|medium|small|xlarge|xxlarge).")
-	i := flag.String("i", "-", "Input file containing the words to retrive the image of. csv encoded, use the \"c\" flag to select the proper column. If \"q\" is present, this flag is ignored. Use - for stdin.")
-	c := flag.Int("c", 3, "If \"i\" is used, selects the column which will be used as word input.")
-	flag.Parse()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -331,14 +147,10 @@ func main() {
 	signal.Notify(sigc, os.Interrupt)
 	go func() {
 		sig := <-sigc
-		logf("signal %v received, canceling", sig)
+		notice("signal received, canceling", "signal", sig.String())
 		cancel()
 	}()
 
-	gsc := google.NewSC(*k, *cx)
-	if *q != "" {
-		handleQSearch(ctx, gsc, *q, google.FilterImgType(*t), google.FilterImgSize(*s))
-	} else {
-		handleSSearch(ctx, gsc, *i, *c, google.FilterImgType(*t), google.FilterImgSize(*s))
-	}
+	cmd.run(ctx, os.Args[2:])
+	exit(exitOK)
 }
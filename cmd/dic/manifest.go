@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// manifestEntry records the provenance of a downloaded image.
+type manifestEntry struct {
+	Query string `json:"query"`
+	Path  string `json:"path"`
+	Link  string `json:"link"`
+	// SourcePage is the page where the image was found, as reported by
+	// the search, if still in the cache.
+	SourcePage string    `json:"source_page,omitempty"`
+	License    string    `json:"license,omitempty"`
+	Width      int       `json:"width,omitempty"`
+	Height     int       `json:"height,omitempty"`
+	Checksum   string    `json:"sha256"`
+	Fetched    time.Time `json:"fetched"`
+}
+
+var manifestHeader = []string{"query", "path", "link", "source_page", "license", "width", "height", "sha256", "fetched"}
+
+// manifestEntry returns the entry of the image downloaded by d, whose
+// source page is looked up in store, if set.
+func (d *download) manifestEntry(store resultStore, license string) (*manifestEntry, error) {
+	e := &manifestEntry{Query: d.query, Path: d.path, Link: d.link, License: license, Checksum: d.sum}
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		return nil, err
+	}
+	// Present images were fetched when written.
+	e.Fetched = fi.ModTime().UTC()
+	if e.Checksum == "" {
+		if e.Checksum, err = fileChecksum(d.path); err != nil {
+			return nil, err
+		}
+	}
+	if f, err := os.Open(d.path); err == nil {
+		if cfg, _, err := image.DecodeConfig(f); err == nil {
+			e.Width, e.Height = cfg.Width, cfg.Height
+		}
+		f.Close()
+	}
+	if store != nil {
+		items, _, _ := store.Get(queryKey(d.query))
+		for _, it := range items {
+			// Links may have been upgraded to HTTPS once found.
+			if (it.Link == d.link || upgradeLink(it.Link) == d.link) && it.Image != nil {
+				e.SourcePage = it.Image.ContextLink
+				if e.Width == 0 {
+					e.Width, e.Height = it.Image.Width, it.Image.Height
+				}
+				break
+			}
+		}
+	}
+	return e, nil
+}
+
+// writeManifest writes entries to path, as csv if its extension is
+// .csv, or else as a JSON array.
+func writeManifest(path string, entries []*manifestEntry) error {
+	f, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	defer f.discard()
+	err = encodeManifest(f, filepath.Ext(path) == ".csv", entries)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return f.commit()
+}
+
+// encodeManifest writes entries to w, as csv if asCSV is set, or else as
+// a JSON array.
+func encodeManifest(w io.Writer, asCSV bool, entries []*manifestEntry) error {
+	if !asCSV {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if entries == nil {
+			entries = []*manifestEntry{}
+		}
+		return enc.Encode(entries)
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(manifestHeader)
+	for _, e := range entries {
+		cw.Write([]string{
+			e.Query, e.Path, e.Link, e.SourcePage, e.License,
+			strconv.Itoa(e.Width), strconv.Itoa(e.Height),
+			e.Checksum, e.Fetched.Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
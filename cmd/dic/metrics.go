@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/discursive-image/dic/google"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricSearches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dic_searches_total",
+		Help: "Searches issued to the provider, by outcome.",
+	}, []string{"provider", "outcome"})
+	metricProviderErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dic_provider_errors_total",
+		Help: "Errors returned by the provider, by HTTP status code and reason. Quota is true when the quota is exhausted.",
+	}, []string{"provider", "code", "reason", "quota"})
+	metricSearchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dic_search_duration_seconds",
+		Help:    "Latency of the searches issued to the provider.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"provider"})
+	metricCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dic_cache_lookups_total",
+		Help: "Cache lookups, by result (hit|miss).",
+	}, []string{"result"})
+	metricRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dic_records_total",
+		Help: "Records processed, by outcome.",
+	}, []string{"outcome"})
+	metricQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dic_queue_depth",
+		Help: "Records read and waiting to be written.",
+	})
+)
+
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// observeSearch accounts for a search issued to the provider.
+func observeSearch(provider string, r *ImageRequest, err error) {
+	metricSearches.WithLabelValues(provider, outcome(err)).Inc()
+	metricSearchDuration.WithLabelValues(provider).Observe(r.latency.Seconds())
+
+	var e *google.Error
+	if errors.As(err, &e) {
+		code := strconv.Itoa(e.StatusCode)
+		metricProviderErrors.WithLabelValues(provider, code, e.Reason, strconv.FormatBool(e.Quota())).Inc()
+	} else if err != nil {
+		metricProviderErrors.WithLabelValues(provider, "", "transport", "false").Inc()
+	}
+}
+
+func observeCache(hit bool) {
+	if hit {
+		metricCache.WithLabelValues("hit").Inc()
+	} else {
+		metricCache.WithLabelValues("miss").Inc()
+	}
+}
+
+// serveMetrics exposes the metrics at addr/metrics. It returns once
+// the listener fails, so it is meant to be run in its own goroutine.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	notice("serving metrics", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Error("metrics listener failed", "addr", addr, "error", err)
+	}
+}
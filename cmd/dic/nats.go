@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func newNATSConn(url string) (*nats.Conn, error) {
+	nc, err := nats.Connect(url, nats.Name("dic"), nats.Timeout(5*time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to nats: %w", err)
+	}
+	return nc, nil
+}
+
+// natsQueue is a queue backed by core NATS subjects, subscribed as a
+// member of a queue group. It serves requests: results are sent to the
+// reply subject of the message if any. Messages are not persisted:
+// those being processed when the worker dies are lost.
+type natsQueue struct {
+	nc  *nats.Conn
+	sub *nats.Subscription
+	out string
+}
+
+func newNATSQueue(url, in, out, group string) (*natsQueue, error) {
+	nc, err := newNATSConn(url)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := nc.QueueSubscribeSync(in, group)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to subscribe to %q: %w", in, err)
+	}
+	return &natsQueue{nc: nc, sub: sub, out: out}, nil
+}
+
+func (q *natsQueue) receive(ctx context.Context) (*delivery, error) {
+	msg, err := q.sub.NextMsgWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery{
+		body: msg.Data,
+		reply: func(ctx context.Context, m *queryMessage, res []byte) error {
+			out := q.out
+			switch {
+			case msg.Reply != "":
+				out = msg.Reply
+			case m.ReplyTo != "":
+				out = m.ReplyTo
+			}
+			return q.nc.Publish(out, res)
+		},
+	}, nil
+}
+
+func (q *natsQueue) close() error {
+	return closeNATSConn(q.nc)
+}
+
+// closeNATSConn closes nc once the results published so far are sent.
+func closeNATSConn(nc *nats.Conn) error {
+	err := nc.FlushTimeout(10 * time.Second)
+	nc.Close()
+	return err
+}
+
+// jetStreamQueue is a queue backed by a JetStream stream, read by a
+// durable pull consumer shared by the workers. Messages are
+// acknowledged once their result is published, and delivered again if
+// not acknowledged within ackWait, so that each is processed at least
+// once.
+type jetStreamQueue struct {
+	nc   *nats.Conn
+	msgs jetstream.MessagesContext
+	out  string
+}
+
+// defaultJetStream is the name of the stream created when none captures
+// the input subject.
+const defaultJetStream = "DIC"
+
+func newJetStreamQueue(url, in, out, group string, ackWait time.Duration) (*jetStreamQueue, error) {
+	nc, err := newNATSConn(url)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := consumeJetStream(nc, in, group, ackWait)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return &jetStreamQueue{nc: nc, msgs: msgs, out: out}, nil
+}
+
+// consumeJetStream returns the messages of the durable consumer named
+// group, created if needed on the stream capturing in.
+func consumeJetStream(nc *nats.Conn, in, group string, ackWait time.Duration) (jetstream.MessagesContext, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	name, err := js.StreamNameBySubject(ctx, in)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		name = defaultJetStream
+		_, err = js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:     name,
+			Subjects: []string{in},
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to find stream of %q: %w", in, err)
+	}
+	cons, err := js.CreateOrUpdateConsumer(ctx, name, jetstream.ConsumerConfig{
+		Durable:       group,
+		FilterSubject: in,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       ackWait,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create consumer: %w", err)
+	}
+	// Buffer few messages, so that the other workers get their share.
+	msgs, err := cons.Messages(jetstream.PullMaxMessages(1))
+	if err != nil {
+		return nil, fmt.Errorf("unable to consume: %w", err)
+	}
+	return msgs, nil
+}
+
+func (q *jetStreamQueue) receive(ctx context.Context) (*delivery, error) {
+	stop := context.AfterFunc(ctx, q.msgs.Stop)
+	defer stop()
+	msg, err := q.msgs.Next()
+	if err != nil {
+		return nil, err
+	}
+	return &delivery{
+		body: msg.Data(),
+		reply: func(ctx context.Context, m *queryMessage, res []byte) error {
+			out := q.out
+			if m.ReplyTo != "" {
+				out = m.ReplyTo
+			}
+			err := q.nc.Publish(out, res)
+			if err == nil {
+				err = q.nc.FlushTimeout(10 * time.Second)
+			}
+			if err != nil {
+				// Deliver the message again without waiting for
+				// ackWait.
+				msg.Nak()
+				return err
+			}
+			return msg.Ack()
+		},
+	}, nil
+}
+
+func (q *jetStreamQueue) close() error {
+	q.msgs.Stop()
+	return closeNATSConn(q.nc)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// queryNormalizers are the steps normalizing the queries before they are
+// looked up in the cache and searched, in order.
+var queryNormalizers []func(string) string
+
+// normalizeSteps are the steps queries can be normalized with.
+var normalizeSteps = map[string]func(string) string{
+	// trim removes leading and trailing whitespace.
+	"trim": strings.TrimSpace,
+	// space collapses whitespace into single spaces, trimming it as well.
+	"space": func(s string) string { return strings.Join(strings.Fields(s), " ") },
+	// nfc composes characters, so that accented ones match however they
+	// were typed.
+	"nfc":   norm.NFC.String,
+	"lower": strings.ToLower,
+	// punct strips punctuation.
+	"punct": func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsPunct(r) {
+				return -1
+			}
+			return r
+		}, s)
+	},
+	"stopwords": stripStopwords,
+	"stem":      stemWords,
+	"lemma":     lemmatize,
+}
+
+// stopwords are the English words stripped by the stopwords step.
+var stopwords = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(`a about above after again against all am an and any are as at be
+		because been before being below between both but by can could did do does doing down
+		during each few for from further had has have having he her here hers herself him
+		himself his how i if in into is it its itself just me more most my myself no nor not
+		now of off on once only or other our ours ourselves out over own same she should so
+		some such than that the their theirs them themselves then there these they this those
+		through to too under until up very was we were what when where which while who whom
+		why will with would you your yours yourself yourselves`) {
+		m[w] = true
+	}
+	return m
+}()
+
+// stripStopwords removes the stopwords of s, which is kept as it is if
+// made of stopwords only, such as "The Who".
+func stripStopwords(s string) string {
+	words := strings.Fields(s)
+	kept := make([]string, 0, len(words))
+	for _, w := range words {
+		if !stopwords[strings.ToLower(strings.TrimFunc(w, unicode.IsPunct))] {
+			kept = append(kept, w)
+		}
+	}
+	if len(kept) == 0 {
+		return s
+	}
+	return strings.Join(kept, " ")
+}
+
+// stemWords reduces the English plurals of s to their singular, as the
+// S stemmer of Harman does, so that "cats" and "cat" match. Stronger
+// stemmers mangle the words searched, which search engines stem already.
+func stemWords(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		lw := strings.ToLower(w)
+		switch {
+		case len(w) < 4:
+		case strings.HasSuffix(lw, "ies") && !strings.HasSuffix(lw, "eies") && !strings.HasSuffix(lw, "aies"):
+			words[i] = w[:len(w)-3] + "y"
+		case strings.HasSuffix(lw, "es") && !strings.HasSuffix(lw, "aes") && !strings.HasSuffix(lw, "ees") && !strings.HasSuffix(lw, "oes"):
+			words[i] = w[:len(w)-1]
+		case strings.HasSuffix(lw, "s") && !strings.HasSuffix(lw, "us") && !strings.HasSuffix(lw, "ss"):
+			words[i] = w[:len(w)-1]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// maxQueryWords, if positive, is the number of words queries are
+// truncated to once normalized, the next ones being dropped.
+var maxQueryWords int
+
+// parseNormalize parses a comma separated list of normalization steps,
+// such as trim,space,nfc,lower.
+func parseNormalize(s string) ([]func(string) string, error) {
+	var steps []func(string) string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		step, ok := normalizeSteps[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid normalization step %q, expected trim, space, nfc, lower, punct, stopwords, stem or lemma", name)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// queryKey returns the key of the results of term: term normalized and
+// with its typos corrected.
+func queryKey(term string) string {
+	return correctQuery(normalizeQuery(term))
+}
+
+// normalizeQuery returns q normalized by queryNormalizers, so that
+// queries differing only in their form share their cache entry, and
+// truncated to maxQueryWords. Queries longer than maxKeywords words are
+// reduced to their keywords first.
+func normalizeQuery(q string) string {
+	if maxKeywords > 0 {
+		q = keywords(q, maxKeywords)
+	}
+	for _, step := range queryNormalizers {
+		q = step(q)
+	}
+	if words := strings.Fields(q); maxQueryWords > 0 && len(words) > maxQueryWords {
+		q = strings.Join(words[:maxQueryWords], " ")
+	}
+	return q
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestParseNormalize(t *testing.T) {
+	for _, tt := range []struct {
+		steps, in, want string
+	}{
+		{"", "  Cats  ", "  Cats  "},
+		{"trim", "  Cats  ", "Cats"},
+		{"space", " red   apple ", "red apple"},
+		{"trim,lower", " Red Apple ", "red apple"},
+		{" Lower , punct ", "Rock'n'Roll!", "rocknroll"},
+		{"nfc", "café", "café"},
+		{"stopwords,stem", "the cats of the cities", "cat city"},
+	} {
+		steps, err := parseNormalize(tt.steps)
+		if err != nil {
+			t.Errorf("%q: %v", tt.steps, err)
+			continue
+		}
+		got := tt.in
+		for _, step := range steps {
+			got = step(got)
+		}
+		if got != tt.want {
+			t.Errorf("%q on %q: got %q, want %q", tt.steps, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseNormalizeInvalid(t *testing.T) {
+	for _, s := range []string{"upper", "trim,nope", "trim;lower"} {
+		if _, err := parseNormalize(s); err == nil {
+			t.Errorf("%q: got no error", s)
+		}
+	}
+}
+
+func TestStemWords(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"cats", "cat"},
+		{"Cats Dogs", "Cat Dog"},
+		{"cities", "city"},
+		{"CITIES", "CITy"},
+		{"boxes", "boxe"},
+		{"toes", "toe"},
+		{"trees", "tree"},
+		{"bus", "bus"},
+		{"virus", "virus"},
+		{"glass", "glass"},
+		{"gas", "gas"},
+		{"", ""},
+	} {
+		if got := stemWords(tt.in); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStripStopwords(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"a cat on the mat", "cat mat"},
+		{"The Cat", "Cat"},
+		{"cat, and dog", "cat, dog"},
+		{"The Who", "The Who"},
+		{"cat", "cat"},
+		{"", ""},
+	} {
+		if got := stripStopwords(tt.in); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
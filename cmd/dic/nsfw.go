@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nsfwTimeout is the time allowed to classify an image.
+const nsfwTimeout = 20 * time.Second
+
+// nsfwClassifier scores how likely images are not safe for work, from 0
+// to 1.
+type nsfwClassifier interface {
+	score(ctx context.Context, link string) (float64, error)
+}
+
+// newNSFWClassifier returns the classifier of spec: the URL of an API,
+// or else the command line of a local one, given the link of the images.
+func newNSFWClassifier(spec, token string) nsfwClassifier {
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return &nsfwAPI{endpoint: spec, token: token, client: &http.Client{Timeout: nsfwTimeout}}
+	}
+	return nsfwCommand(strings.Fields(spec))
+}
+
+// nsfwAPI classifies images with an API, posted {"url": link} and
+// answering {"score": score}.
+type nsfwAPI struct {
+	endpoint string
+	token    string // optional bearer token.
+	client   *http.Client
+}
+
+func (a *nsfwAPI) score(ctx context.Context, link string) (float64, error) {
+	body, _ := json.Marshal(map[string]string{"url": link})
+	req, err := http.NewRequestWithContext(ctx, "POST", a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	var res struct {
+		Score *float64 `json:"score"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return 0, fmt.Errorf("unable to decode response: %w", err)
+	}
+	if res.Score == nil {
+		return 0, fmt.Errorf("response without score")
+	}
+	return *res.Score, nil
+}
+
+// nsfwCommand classifies images with a local command, run with the link
+// of the images appended to its arguments and printing their score.
+type nsfwCommand []string
+
+func (c nsfwCommand) score(ctx context.Context, link string) (float64, error) {
+	out, err := exec.CommandContext(ctx, c[0], append(c[1:len(c):len(c)], link)...).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+}
+
+// flagged reports whether the image at link is not safe for work, as
+// scored by the classifier of s. Images that cannot be scored are.
+func (s *selector) flagged(link string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), nsfwTimeout)
+	defer cancel()
+	score, err := s.nsfw.score(ctx, link)
+	if err != nil {
+		logger.Warn("unable to classify image", "link", link, "error", err)
+		return true
+	}
+	if score >= s.nsfwThreshold {
+		logger.Debug("image flagged as not safe for work", "link", link, "score", score)
+		return true
+	}
+	return false
+}
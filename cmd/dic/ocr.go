@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// ocrTimeout is the time allowed to recognize the text of an image.
+const ocrTimeout = 30 * time.Second
+
+// ocrMinConfidence is the minimum confidence, out of 100, of the words
+// recognized that are counted.
+const ocrMinConfidence = 60
+
+// textCoverage returns the fraction of the area of the image of c
+// covered by the words recognized by tesseract, the path of its
+// executable.
+func textCoverage(c *candidate, tesseract string) (float64, error) {
+	b, err := c.bytes()
+	if err != nil {
+		return 0, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return 0, fmt.Errorf("unable to decode image: %w", err)
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ocrTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, tesseract, "stdin", "stdout", "tsv")
+	cmd.Stdin = bytes.NewReader(b)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("tesseract failed: %w", err)
+	}
+
+	// Words are the rows of level 5, with their box and confidence.
+	r := csv.NewReader(bytes.NewReader(out))
+	r.Comma, r.FieldsPerRecord, r.LazyQuotes = '\t', -1, true
+	var area int
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("unable to read tesseract output: %w", err)
+		}
+		if len(rec) < 12 || rec[0] != "5" {
+			continue
+		}
+		conf, _ := strconv.ParseFloat(rec[10], 64)
+		w, _ := strconv.Atoi(rec[8])
+		h, _ := strconv.Atoi(rec[9])
+		if conf >= ocrMinConfidence {
+			area += w * h
+		}
+	}
+	return float64(area) / float64(cfg.Width*cfg.Height), nil
+}
+
+// mostlyText reports whether the image of c is mostly text, as told by
+// textCoverage, such as memes, screenshots and dictionary pages. Images
+// whose text cannot be recognized are not.
+func (s *selector) mostlyText(c *candidate) bool {
+	cov, err := textCoverage(c, s.tesseract)
+	if err != nil {
+		logger.Warn("unable to recognize image text", "link", c.link, "error", err)
+		return false
+	}
+	if cov >= s.textMax {
+		logger.Debug("image mostly text", "link", c.link, "coverage", cov)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"os"
+	"slices"
+
+	"golang.org/x/image/draw"
+)
+
+// paletteImageSize is the maximum size, in pixels, of the images whose
+// palette is computed, larger ones being scaled down first.
+const paletteImageSize = 100
+
+// paletteMinDistance is the minimum distance between the colors of a
+// palette, so that shades of the same color do not fill it.
+const paletteMinDistance = 48
+
+// palette returns the n colors most common in the image at path, as hex
+// codes such as #1a2b3c, the dominant one first. Colors are counted in
+// buckets of close ones, averaged, and transparent pixels are ignored.
+// Fewer colors are returned for images with fewer distinct ones.
+func palette(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode image: %w", err)
+	}
+	b := src.Bounds()
+	w, h := fit(b.Dx(), b.Dy(), paletteImageSize, paletteImageSize)
+	small := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), src, b, draw.Src, nil)
+
+	type bucket struct {
+		n       int
+		r, g, b int
+	}
+	buckets := make(map[int]*bucket)
+	for i := 0; i < len(small.Pix); i += 4 {
+		r, g, b, a := int(small.Pix[i]), int(small.Pix[i+1]), int(small.Pix[i+2]), small.Pix[i+3]
+		if a < 128 {
+			continue
+		}
+		k := r>>4<<8 | g>>4<<4 | b>>4
+		bk, ok := buckets[k]
+		if !ok {
+			bk = &bucket{}
+			buckets[k] = bk
+		}
+		bk.n++
+		bk.r, bk.g, bk.b = bk.r+r, bk.g+g, bk.b+b
+	}
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, bk := range buckets {
+		sorted = append(sorted, bk)
+	}
+	slices.SortFunc(sorted, func(a, b *bucket) int {
+		return cmp.Compare(b.n, a.n)
+	})
+
+	var picked [][3]int
+	colors := make([]string, 0, n)
+	for _, bk := range sorted {
+		if len(colors) == n {
+			break
+		}
+		c := [3]int{bk.r / bk.n, bk.g / bk.n, bk.b / bk.n}
+		if slices.ContainsFunc(picked, func(p [3]int) bool {
+			dr, dg, db := c[0]-p[0], c[1]-p[1], c[2]-p[2]
+			return dr*dr+dg*dg+db*db < paletteMinDistance*paletteMinDistance
+		}) {
+			continue
+		}
+		picked = append(picked, c)
+		colors = append(colors, fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2]))
+	}
+	return colors, nil
+}
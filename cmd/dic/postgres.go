@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// isPostgresURL reports whether the input in is a PostgreSQL connection
+// URL rather than a file.
+func isPostgresURL(in string) bool {
+	return strings.HasPrefix(in, "postgres://") || strings.HasPrefix(in, "postgresql://")
+}
+
+// openPostgres runs query on the database at url and returns its rows
+// as csv records, with NULL values as empty fields. The rows are read
+// as they are consumed.
+func openPostgres(ctx context.Context, url, query string) (io.ReadCloser, error) {
+	if query == "" {
+		return nil, fmt.Errorf("query missing for postgres input")
+	}
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to postgres: %w", err)
+	}
+	// Values are requested as text, as they would be printed by psql.
+	rows, err := conn.Query(ctx, query, pgx.QueryResultFormats{pgx.TextFormatCode})
+	var more bool
+	if err == nil {
+		// Read the first row, so that the errors of the query are
+		// reported here.
+		if more = rows.Next(); !more {
+			err = rows.Err()
+		}
+	}
+	if err != nil {
+		conn.Close(context.Background())
+		return nil, fmt.Errorf("unable to run postgres query: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer conn.Close(context.Background())
+		defer rows.Close()
+		w := csv.NewWriter(pw)
+		for ; more; more = rows.Next() {
+			vals := rows.RawValues()
+			rec := make([]string, len(vals))
+			for i, v := range vals {
+				rec[i] = string(v)
+			}
+			if err := w.Write(rec); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		w.Flush()
+		err := rows.Err()
+		if err == nil {
+			err = w.Error()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// postgresTable describes the table where the links of a PostgreSQL
+// output are written.
+type postgresTable struct {
+	name string
+	// key is the column identifying the rows, whose value is the field
+	// keyField of the records, and link the column of the links.
+	key      string
+	keyField int
+	link     string
+	// upsert inserts the rows missing from the table instead of
+	// ignoring them.
+	upsert bool
+	// txSize is the number of records written per transaction.
+	txSize int
+}
+
+func (t *postgresTable) statement() string {
+	name := pgx.Identifier(strings.Split(t.name, ".")).Sanitize()
+	key, link := pgx.Identifier{t.key}.Sanitize(), pgx.Identifier{t.link}.Sanitize()
+	if t.upsert {
+		return fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES ($2, $1) ON CONFLICT (%s) DO UPDATE SET %s = EXCLUDED.%s", name, key, link, key, link, link)
+	}
+	return fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s = $2", name, link, key)
+}
+
+// postgresOutput writes the records written to it to a table, the link
+// being the last field of each record.
+type postgresOutput struct {
+	*recordSink
+}
+
+// openPostgresOutput connects to the database at url and returns the
+// output writing to t.
+func openPostgresOutput(ctx context.Context, url string, t *postgresTable) (*postgresOutput, error) {
+	switch {
+	case t.name == "":
+		return nil, fmt.Errorf("table missing for postgres output")
+	case t.key == "" || t.link == "":
+		return nil, fmt.Errorf("key and link columns required for postgres output")
+	case t.keyField < 0:
+		return nil, fmt.Errorf("key field must be positive, got %d", t.keyField)
+	case t.txSize <= 0:
+		return nil, fmt.Errorf("transaction size must be positive, got %d", t.txSize)
+	}
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to postgres: %w", err)
+	}
+	o := &postgresOutput{}
+	o.recordSink = newRecordSink(func(recs <-chan []string) error {
+		defer conn.Close(context.Background())
+		return o.write(conn, recs, t)
+	})
+	return o, nil
+}
+
+// write writes the records of recs to t, in transactions of t.txSize
+// records. The records are written even if the run is canceled, as
+// their search is complete.
+func (o *postgresOutput) write(conn *pgx.Conn, recs <-chan []string, t *postgresTable) error {
+	ctx := context.Background()
+	stmt := t.statement()
+	batch := &pgx.Batch{}
+	commit := func() error {
+		n := batch.Len()
+		if n == 0 {
+			return nil
+		}
+		var missing int
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			br := tx.SendBatch(ctx, batch)
+			defer br.Close()
+			for i := 0; i < n; i++ {
+				tag, err := br.Exec()
+				if err != nil {
+					return err
+				}
+				if tag.RowsAffected() == 0 {
+					missing++
+				}
+			}
+			return br.Close()
+		})
+		batch = &pgx.Batch{}
+		if err != nil {
+			return fmt.Errorf("unable to write to postgres: %w", err)
+		}
+		if missing > 0 {
+			logger.Warn("records missing from the postgres table", "table", t.name, "count", missing)
+		}
+		logger.Debug("postgres transaction committed", "table", t.name, "records", n)
+		return nil
+	}
+
+	for rec := range recs {
+		if t.keyField >= len(rec)-1 {
+			return fmt.Errorf("key field %d missing from record of %d fields", t.keyField, len(rec)-1)
+		}
+		batch.Queue(stmt, rec[len(rec)-1], rec[t.keyField])
+		if batch.Len() >= t.txSize {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+	}
+	return commit()
+}
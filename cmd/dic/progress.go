@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// isTerminal reports whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// countRecords returns the number of csv records in the file at
+// path. Returns 0 when the input is not a regular file, as it cannot
+// be read twice.
+func countRecords(path string) int {
+	if path == "-" {
+		return 0
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+		return 0
+	}
+
+	var n int
+	r := csv.NewReader(f)
+	r.ReuseRecord = true
+	for {
+		_, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return n
+		}
+		if err != nil {
+			// The processing loop will report the problem.
+			return n
+		}
+		n++
+	}
+}
+
+// statusMu serializes writes to stderr between the log helpers and
+// the progress status line.
+var statusMu sync.Mutex
+
+// status is the progress bar currently drawn on stderr, if any.
+var status *progress
+
+// progress draws the status of a batch run on stderr.
+// A nil progress is valid and does nothing.
+type progress struct {
+	w     io.Writer
+	total int // 0 if unknown.
+	sum   *summary
+	last  time.Time
+}
+
+const progressInterval = 200 * time.Millisecond
+
+// newProgress returns a progress bar drawing on stderr or nil if
+// stderr is not a terminal or quiet mode is on.
+func newProgress(total int, sum *summary) *progress {
+	if verbosity < levelDefault || !isTerminal(os.Stderr) {
+		return nil
+	}
+	p := &progress{
+		w:     os.Stderr,
+		total: total,
+		sum:   sum,
+	}
+	statusMu.Lock()
+	status = p
+	statusMu.Unlock()
+	return p
+}
+
+// update redraws the status, at most once per progressInterval.
+func (p *progress) update() {
+	if p == nil {
+		return
+	}
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	if time.Since(p.last) >= progressInterval {
+		p.draw()
+	}
+}
+
+// finish draws the final status and releases the terminal line.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	p.draw()
+	fmt.Fprintln(p.w)
+	status = nil
+}
+
+func (p *progress) line() string {
+	elapsed := time.Since(p.sum.Start)
+	done := p.sum.Rows
+	var rate float64
+	if done > 0 {
+		rate = float64(p.sum.CacheHits) / float64(done) * 100
+	}
+	s := fmt.Sprintf("%d", done)
+	if p.total > 0 {
+		s += fmt.Sprintf("/%d (%.0f%%)", p.total, float64(done)/float64(p.total)*100)
+	}
+	s += fmt.Sprintf(" rows, %.0f%% cache hits, %d errors, %v elapsed", rate, p.sum.Failed, elapsed.Truncate(time.Second))
+	if p.total > 0 && done > 0 && done < p.total {
+		eta := time.Duration(float64(elapsed) / float64(done) * float64(p.total-done))
+		s += fmt.Sprintf(", eta %v", eta.Truncate(time.Second))
+	}
+	return s
+}
+
+// draw must be called with statusMu held.
+func (p *progress) draw() {
+	p.last = time.Now()
+	fmt.Fprintf(p.w, "\r\033[K%s", p.line())
+}
+
+// clear must be called with statusMu held.
+func (p *progress) clear() {
+	fmt.Fprint(p.w, "\r\033[K")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// queryTemplate, if set, decorates the terms searched, which remain the
+// keys of their cached results.
+var queryTemplate *template.Template
+
+// Phrase modes of the multi-word terms: searched as they are, as exact
+// phrases, or with all their words required.
+const (
+	phraseAsIs  = "as-is"
+	phraseExact = "exact"
+	phraseAll   = "all"
+)
+
+// phraseMode is the phrase mode of the multi-word terms.
+var phraseMode = phraseAsIs
+
+// quoteTerm returns term quoted as told by phraseMode, if made of
+// several words, such as "red apple" for exact phrases and "red" "apple"
+// for all words. Quotes of the term are dropped first.
+func quoteTerm(term string) string {
+	words := strings.Fields(strings.ReplaceAll(term, `"`, ""))
+	if phraseMode == phraseAsIs || len(words) < 2 {
+		return term
+	}
+	if phraseMode == phraseExact {
+		return `"` + strings.Join(words, " ") + `"`
+	}
+	return `"` + strings.Join(words, `" "`) + `"`
+}
+
+// queryData is the data of queryTemplate.
+type queryData struct {
+	Term string
+}
+
+// parseQueryTemplate parses a query template such as
+// "{{.Term}} illustration white background", checking that it renders.
+func parseQueryTemplate(s string) (*template.Template, error) {
+	t, err := template.New("query").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query template: %w", err)
+	}
+	if err := t.Execute(new(strings.Builder), queryData{}); err != nil {
+		return nil, fmt.Errorf("invalid query template: %w", err)
+	}
+	return t, nil
+}
+
+// signRendering sets in v the options changing the text searched for
+// the terms, so that the results of different ones are cached apart:
+// the template, by hash, the phrase mode, the translation and the
+// language detection.
+func signRendering(v url.Values) {
+	if queryTemplate != nil {
+		h := sha256.Sum256([]byte(queryTemplate.Root.String()))
+		v.Set("template", hex.EncodeToString(h[:6]))
+	}
+	if phraseMode != phraseAsIs {
+		v.Set("phrase", phraseMode)
+	}
+	if queryTranslator != nil {
+		v.Set("translate", queryTranslator.source+":"+queryTranslator.target)
+	}
+	if languageDetector != nil {
+		v.Set("detect", "language")
+	}
+}
+
+// searchText returns the text searched for term, as translated by
+// queryTranslator if set, quoted as told by phraseMode and then
+// decorated by queryTemplate if set.
+func searchText(ctx context.Context, term string) (string, error) {
+	if queryTranslator != nil {
+		var err error
+		if term, err = queryTranslator.translate(ctx, term); err != nil {
+			return "", err
+		}
+	}
+	term = quoteTerm(term)
+	if queryTemplate == nil {
+		return term, nil
+	}
+	var b strings.Builder
+	if err := queryTemplate.Execute(&b, queryData{Term: term}); err != nil {
+		return "", fmt.Errorf("unable to render query template: %w", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestQuoteTerm(t *testing.T) {
+	defer func(mode string) { phraseMode = mode }(phraseMode)
+	for _, tt := range []struct {
+		mode, term, want string
+	}{
+		{phraseAsIs, "red apple", "red apple"},
+		{phraseAsIs, `"red apple"`, `"red apple"`},
+		{phraseExact, "red apple", `"red apple"`},
+		{phraseExact, ` red  "big" apple `, `"red big apple"`},
+		{phraseExact, "apple", "apple"},
+		{phraseExact, `"apple"`, `"apple"`},
+		{phraseAll, "red apple", `"red" "apple"`},
+		{phraseAll, `"red apple"`, `"red" "apple"`},
+		{phraseAll, "apple", "apple"},
+		{phraseAll, "", ""},
+	} {
+		phraseMode = tt.mode
+		if got := quoteTerm(tt.term); got != tt.want {
+			t.Errorf("%s %q: got %q, want %q", tt.mode, tt.term, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/discursive-image/dic/google"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Weights of the criteria of the scores. Each doubling of the pixels
+// of an image counts as 1.
+const (
+	rankTypeWeight     = 4
+	rankPenalizeWeight = 8
+)
+
+// Picks of the first result tried.
+const (
+	pickFirst  = "first"
+	pickRandom = "random"
+	pickNth    = "nth="
+)
+
+// parsePick parses a pick of the first result: first, random or nth=K,
+// and returns its mode and K.
+func parsePick(s string) (string, int, error) {
+	switch s {
+	case pickFirst, pickRandom:
+		return s, 0, nil
+	}
+	if k, ok := strings.CutPrefix(s, pickNth); ok {
+		if n, err := strconv.Atoi(k); err == nil && n > 0 {
+			return pickNth, n, nil
+		}
+	}
+	return "", 0, fmt.Errorf("invalid pick %q, expected first, random or nth=<k> such as nth=2", s)
+}
+
+// start returns the index of the first of n results tried, as told by
+// the pick of s, the last one for nth picks beyond them.
+func (s *selector) start(n int) int {
+	switch {
+	case n == 0:
+		return 0
+	case s.pick == pickRandom:
+		return rand.IntN(n)
+	case s.pick == pickNth:
+		return min(s.pickIndex, n) - 1
+	}
+	return 0
+}
+
+// score returns the score of the image of isr, higher being better.
+func (s *selector) score(isr *google.ISR) float64 {
+	var v float64
+	if isr.Image != nil && isr.Image.Width > 0 && isr.Image.Height > 0 {
+		v += math.Log2(float64(isr.Image.Width) * float64(isr.Image.Height))
+	}
+	if _, sub, _ := strings.Cut(isr.Mime, "/"); sub != "" && slices.Contains(s.rankTypes, sub) {
+		v += rankTypeWeight
+	}
+	if s.rankPenalized.has(isr) {
+		v -= rankPenalizeWeight
+	}
+	return v
+}
+
+// rankResults returns results sorted by decreasing score, ties kept in
+// order.
+func (s *selector) rankResults(results []*google.ISR) []*google.ISR {
+	scores := make(map[*google.ISR]float64, len(results))
+	for _, isr := range results {
+		scores[isr] = s.score(isr)
+	}
+	ranked := slices.Clone(results)
+	slices.SortStableFunc(ranked, func(a, b *google.ISR) int {
+		return cmp.Compare(scores[b], scores[a])
+	})
+	return ranked
+}
+
+// resultDomain returns the registered domain of the site the image of
+// isr was found on, such as example.co.uk for images.example.co.uk.
+func resultDomain(isr *google.ISR) string {
+	host := strings.ToLower(isr.DisplayLink)
+	if host == "" {
+		host = linkHost(isr.Link)
+	}
+	if d, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return d
+	}
+	return host
+}
+
+// diversify returns results reordered so that consecutive ones are from
+// different domains as much as possible: the first result of each
+// domain, in order, then their second ones, and so on.
+func diversify(results []*google.ISR) []*google.ISR {
+	var domains []string
+	byDomain := make(map[string][]*google.ISR)
+	for _, isr := range results {
+		d := resultDomain(isr)
+		if _, ok := byDomain[d]; !ok {
+			domains = append(domains, d)
+		}
+		byDomain[d] = append(byDomain[d], isr)
+	}
+	diversified := make([]*google.ISR, 0, len(results))
+	for i := 0; len(diversified) < len(results); i++ {
+		for _, d := range domains {
+			if i < len(byDomain[d]) {
+				diversified = append(diversified, byDomain[d][i])
+			}
+		}
+	}
+	return diversified
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestParsePick(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		mode string
+		k    int
+	}{
+		{"first", pickFirst, 0},
+		{"random", pickRandom, 0},
+		{"nth=1", pickNth, 1},
+		{"nth=12", pickNth, 12},
+	} {
+		mode, k, err := parsePick(tt.s)
+		if err != nil || mode != tt.mode || k != tt.k {
+			t.Errorf("%q: got %q, %d, %v, want %q, %d", tt.s, mode, k, err, tt.mode, tt.k)
+		}
+	}
+}
+
+func TestParsePickInvalid(t *testing.T) {
+	for _, s := range []string{"", "last", "First", "nth", "nth=", "nth=0", "nth=-1", "nth=two", "nth=1.5"} {
+		if _, _, err := parsePick(s); err == nil {
+			t.Errorf("%q: got no error", s)
+		}
+	}
+}
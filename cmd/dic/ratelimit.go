@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+var metricRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "dic_rate_limited_total",
+	Help: "Server requests rejected because the client exceeded its rate limit, by api key name.",
+}, []string{"client"})
+
+// limit is a request rate, in requests per second, with the burst of
+// requests allowed above it. A zero rate means unlimited.
+type limit struct {
+	rate  float64
+	burst int
+}
+
+// rateLimiter enforces a request rate per client. Clients are
+// identified by the name of their api key or, if authentication is
+// disabled, by their address.
+type rateLimiter struct {
+	def  limit
+	keys map[string]limit // overrides, by key name.
+
+	mu      sync.Mutex
+	clients map[string]*rate.Limiter
+}
+
+func newRateLimiter(def limit, keys []apiKey) *rateLimiter {
+	l := &rateLimiter{
+		def:     def,
+		keys:    make(map[string]limit),
+		clients: make(map[string]*rate.Limiter),
+	}
+	for _, k := range keys {
+		if k.RateLimit != 0 || k.RateBurst != 0 {
+			l.keys[k.Name] = limit{rate: k.RateLimit, burst: k.RateBurst}
+		}
+	}
+	return l
+}
+
+func (l *rateLimiter) limitOf(client string) limit {
+	lim, ok := l.keys[client]
+	if !ok {
+		return l.def
+	}
+	if lim.rate == 0 {
+		lim.rate = l.def.rate
+	}
+	if lim.burst == 0 {
+		lim.burst = l.def.burst
+	}
+	return lim
+}
+
+// clientOf identifies the client issuing a request from addr.
+// Anonymous clients share the same metrics label.
+func clientOf(ctx context.Context, addr string) (id, label string) {
+	if name := clientName(ctx); name != "" {
+		return name, name
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return host, "anonymous"
+}
+
+// allow reports whether client may issue a request now. If not, it
+// returns the time after which it may retry.
+func (l *rateLimiter) allow(client, label string) (time.Duration, bool) {
+	lim := l.limitOf(client)
+	if lim.rate <= 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	c, ok := l.clients[client]
+	if !ok {
+		c = rate.NewLimiter(rate.Limit(lim.rate), max(lim.burst, 1))
+		l.clients[client] = c
+	}
+	l.mu.Unlock()
+
+	r := c.Reserve()
+	if d := r.Delay(); d > 0 {
+		r.Cancel()
+		metricRateLimited.WithLabelValues(label).Inc()
+		return d, false
+	}
+	return 0, true
+}
+
+// expire drops the limiters of the clients that have their burst
+// refilled, as a new limiter is equivalent, until ctx is done.
+func (l *rateLimiter) expire(ctx context.Context) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		l.sweep()
+	}
+}
+
+// sweep drops the limiters of the clients that have their burst
+// refilled.
+func (l *rateLimiter) sweep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, c := range l.clients {
+		if c.Tokens() >= float64(c.Burst()) {
+			delete(l.clients, k)
+		}
+	}
+}
+
+// retryAfter formats d as a Retry-After value, in whole seconds.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// middleware rejects the requests of the clients exceeding their
+// rate with 429 Too Many Requests. It must be installed after the
+// authentication one.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d, ok := l.allow(clientOf(r.Context(), r.RemoteAddr)); !ok {
+			w.Header().Set("Retry-After", retryAfter(d))
+			writeError(w, http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *rateLimiter) check(ctx context.Context) error {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	d, ok := l.allow(clientOf(ctx, addr))
+	if ok {
+		return nil
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter(d)))
+	return grpcstatus.Error(codes.ResourceExhausted, "rate limit exceeded")
+}
+
+func (l *rateLimiter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := l.check(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (l *rateLimiter) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := l.check(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
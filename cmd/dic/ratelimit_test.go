@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	l := newRateLimiter(limit{rate: 0.001, burst: 2}, []apiKey{
+		{Name: "alice", RateBurst: 1},
+		{Name: "bob", RateLimit: -1},
+	})
+	h := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(client, addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/search", nil)
+		r.RemoteAddr = addr
+		if client != "" {
+			r = r.WithContext(context.WithValue(r.Context(), clientKey{}, client))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	for i, tt := range []struct {
+		client, addr string
+		want         int
+	}{
+		{"alice", "10.0.0.1:1000", http.StatusOK},
+		{"alice", "10.0.0.2:1000", http.StatusTooManyRequests},
+		{"bob", "10.0.0.1:1000", http.StatusOK},
+		{"bob", "10.0.0.1:1000", http.StatusOK},
+		{"bob", "10.0.0.1:1000", http.StatusOK},
+		// Anonymous clients are limited by host.
+		{"", "10.0.0.1:1000", http.StatusOK},
+		{"", "10.0.0.1:2000", http.StatusOK},
+		{"", "10.0.0.1:3000", http.StatusTooManyRequests},
+		{"", "10.0.0.2:1000", http.StatusOK},
+	} {
+		w := request(tt.client, tt.addr)
+		if w.Code != tt.want {
+			t.Errorf("%d: %q from %s: got status %d, want %d", i, tt.client, tt.addr, w.Code, tt.want)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%d: no Retry-After header", i)
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := newRateLimiter(limit{rate: 0.001, burst: 2}, []apiKey{{Name: "fast", RateLimit: 1000}})
+	for _, client := range []string{"slow", "fast"} {
+		if _, ok := l.allow(client, client); !ok {
+			t.Fatalf("%s: first request rejected", client)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	l.sweep()
+	if _, ok := l.clients["slow"]; !ok {
+		t.Errorf("limiter of the client without its burst refilled dropped")
+	}
+	if _, ok := l.clients["fast"]; ok {
+		t.Errorf("limiter of the client with its burst refilled kept")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, tt := range []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0"},
+		{time.Millisecond, "1"},
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+	} {
+		if got := retryAfter(tt.d); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
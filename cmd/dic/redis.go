@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisList is a queue backed by a pair of Redis lists. Messages are
+// removed from the list as they are received: those being processed
+// when the worker dies are lost.
+type redisList struct {
+	rdb *redis.Client
+	in  string
+	out string
+}
+
+func newRedisClient(url string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	rdb := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		return nil, fmt.Errorf("unable to connect to redis: %w", err)
+	}
+	return rdb, nil
+}
+
+func newRedisList(url, in, out string) (*redisList, error) {
+	rdb, err := newRedisClient(url)
+	if err != nil {
+		return nil, err
+	}
+	return &redisList{rdb: rdb, in: in, out: out}, nil
+}
+
+func (l *redisList) receive(ctx context.Context) (*delivery, error) {
+	for {
+		// Block for a bounded time, so that connection problems
+		// surface as errors instead of hanging.
+		res, err := l.rdb.BRPop(ctx, 30*time.Second, l.in).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// res holds the list name and the value.
+		return &delivery{body: []byte(res[1]), reply: l.reply}, nil
+	}
+}
+
+func (l *redisList) reply(ctx context.Context, m *queryMessage, res []byte) error {
+	out := l.out
+	if m.ReplyTo != "" {
+		out = m.ReplyTo
+	}
+	return l.rdb.LPush(ctx, out, res).Err()
+}
+
+func (l *redisList) close() error {
+	return l.rdb.Close()
+}
+
+// redisStream is a queue backed by a Redis stream, read by a consumer
+// group. Messages are acknowledged once their result is published:
+// the pending messages of workers that died are claimed by the others
+// once idle for claimIdle, so that each message is processed at least
+// once. Messages whose search may succeed if tried again are left
+// pending as well, once.
+type redisStream struct {
+	rdb       *redis.Client
+	in        string
+	out       string
+	group     string
+	consumer  string
+	claimIdle time.Duration
+
+	lastClaim time.Time
+	claimed   []redis.XMessage // claimed and not yet delivered.
+	cursor    string           // of the pending entries scan.
+	// inflight holds the IDs of the messages being processed, which
+	// are not delivered again when claimed: claiming them only resets
+	// their idle time.
+	inflight sync.Map
+}
+
+func newRedisStream(url, in, out, group, consumer string, claimIdle time.Duration) (*redisStream, error) {
+	rdb, err := newRedisClient(url)
+	if err != nil {
+		return nil, err
+	}
+	// Start from the beginning of the stream, so that the messages
+	// sent before the group existed are not skipped.
+	err = rdb.XGroupCreateMkStream(context.Background(), in, group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		rdb.Close()
+		return nil, fmt.Errorf("unable to create consumer group: %w", err)
+	}
+	return &redisStream{
+		rdb:       rdb,
+		in:        in,
+		out:       out,
+		group:     group,
+		consumer:  consumer,
+		claimIdle: claimIdle,
+		cursor:    "0-0",
+	}, nil
+}
+
+// claim takes over the messages pending for longer than claimIdle,
+// scanning the pending entries at most every claimIdle.
+func (s *redisStream) claim(ctx context.Context) error {
+	if time.Since(s.lastClaim) < s.claimIdle {
+		return nil
+	}
+	msgs, next, err := s.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   s.in,
+		Group:    s.group,
+		Consumer: s.consumer,
+		MinIdle:  s.claimIdle,
+		Start:    s.cursor,
+		Count:    100,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("unable to claim pending messages: %w", err)
+	}
+	if len(msgs) > 0 {
+		notice("claimed pending messages", "count", len(msgs))
+	}
+	s.claimed = append(s.claimed, msgs...)
+	s.cursor = next
+	if next == "0-0" {
+		// The scan is complete.
+		s.lastClaim = time.Now()
+	}
+	return nil
+}
+
+func (s *redisStream) receive(ctx context.Context) (*delivery, error) {
+	for {
+		if err := s.claim(ctx); err != nil {
+			return nil, err
+		}
+		if len(s.claimed) > 0 {
+			m := s.claimed[0]
+			s.claimed = s.claimed[1:]
+			if _, ok := s.inflight.Load(m.ID); ok {
+				continue
+			}
+			return s.delivery(m, true), nil
+		}
+
+		res, err := s.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.consumer,
+			Streams:  []string{s.in, ">"},
+			Count:    1,
+			Block:    min(s.claimIdle, 30*time.Second),
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(res) > 0 && len(res[0].Messages) > 0 {
+			return s.delivery(res[0].Messages[0], false), nil
+		}
+	}
+}
+
+// delivery returns the delivery of m, claimed if redelivered. The
+// payload is either in its "message" field or, if missing, the query
+// message fields are the entry fields.
+func (s *redisStream) delivery(m redis.XMessage, redelivered bool) *delivery {
+	s.inflight.Store(m.ID, struct{}{})
+	var body []byte
+	if v, ok := m.Values["message"].(string); ok {
+		body = []byte(v)
+	} else {
+		body, _ = json.Marshal(m.Values)
+	}
+	reply := func(ctx context.Context, qm *queryMessage, res []byte) error {
+		defer s.inflight.Delete(m.ID)
+		out := s.out
+		if qm.ReplyTo != "" {
+			out = qm.ReplyTo
+		}
+		if err := s.rdb.XAdd(ctx, &redis.XAddArgs{
+			Stream: out,
+			Values: map[string]interface{}{"message": res},
+		}).Err(); err != nil {
+			return err
+		}
+		return s.rdb.XAck(ctx, s.in, s.group, m.ID).Err()
+	}
+	return &delivery{
+		body:  body,
+		reply: reply,
+		fail: func(ctx context.Context, qm *queryMessage, res []byte, err error) error {
+			if !permanent(err) && !redelivered {
+				// Left pending, to be claimed again after claimIdle,
+				// possibly by another worker.
+				s.inflight.Delete(m.ID)
+				return nil
+			}
+			return reply(ctx, qm, res)
+		},
+	}
+}
+
+func (s *redisStream) close() error {
+	return s.rdb.Close()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestStream(t *testing.T) (*miniredis.Miniredis, *redisStream) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	s, err := newRedisStream("redis://"+mr.Addr(), "queries", "results", "dic", "w1", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.close() })
+	return mr, s
+}
+
+func addQuery(t *testing.T, s *redisStream, q string) string {
+	t.Helper()
+	id, err := s.rdb.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: s.in,
+		Values: map[string]interface{}{"query": q},
+	}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func pending(t *testing.T, s *redisStream) int64 {
+	t.Helper()
+	p, err := s.rdb.XPending(context.Background(), s.in, s.group).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.Count
+}
+
+func TestRedisStreamClaimInflight(t *testing.T) {
+	ctx := context.Background()
+	mr, s := newTestStream(t)
+	addQuery(t, s, "cats")
+	d, err := s.receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d.body) != `{"query":"cats"}` {
+		t.Errorf("got body %s", d.body)
+	}
+
+	// The message being processed is claimed once idle, but not
+	// delivered again.
+	mr.SetTime(time.Now().Add(time.Minute))
+	addQuery(t, s, "dogs")
+	s.lastClaim = time.Time{}
+	d, err = s.receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d.body) != `{"query":"dogs"}` {
+		t.Errorf("got body %s, want the message not processed yet", d.body)
+	}
+	if len(s.claimed) != 0 {
+		t.Errorf("%d claimed messages left", len(s.claimed))
+	}
+}
+
+func TestRedisStreamFail(t *testing.T) {
+	ctx := context.Background()
+	mr, s := newTestStream(t)
+	id := addQuery(t, s, "cats")
+	m := &queryMessage{Query: "cats"}
+	d, err := s.receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Failing transiently leaves the message pending, to be claimed.
+	if err := d.fail(ctx, m, []byte("{}"), errors.New("timeout")); err != nil {
+		t.Fatal(err)
+	}
+	if n := pending(t, s); n != 1 {
+		t.Fatalf("got %d pending messages, want 1", n)
+	}
+	if _, ok := s.inflight.Load(id); ok {
+		t.Errorf("failed message still in flight")
+	}
+
+	mr.SetTime(time.Now().Add(time.Minute))
+	s.lastClaim = time.Time{}
+	d, err = s.receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d.body) != `{"query":"cats"}` {
+		t.Fatalf("got body %s, want the failed message claimed", d.body)
+	}
+
+	// Failing again once redelivered publishes the failure.
+	if err := d.fail(ctx, m, []byte("{}"), errors.New("timeout")); err != nil {
+		t.Fatal(err)
+	}
+	if n := pending(t, s); n != 0 {
+		t.Errorf("got %d pending messages, want none", n)
+	}
+	if n, err := s.rdb.XLen(ctx, s.out).Result(); err != nil || n != 1 {
+		t.Errorf("got %d results, %v, want 1", n, err)
+	}
+}
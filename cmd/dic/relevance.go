@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+)
+
+// relevanceTimeout is the time allowed to score the results of a query.
+const relevanceTimeout = time.Minute
+
+// relevanceModel scores the relevance of images to a text, such as the
+// similarity of their CLIP embeddings.
+type relevanceModel interface {
+	scores(ctx context.Context, text string, links []string) ([]float64, error)
+}
+
+// relevanceRequest is the request of the relevance models, answered
+// with a relevanceResponse.
+type relevanceRequest struct {
+	Text   string   `json:"text"`
+	Images []string `json:"images"`
+}
+
+// relevanceResponse holds the scores of the images of a request, in
+// order, higher being more relevant.
+type relevanceResponse struct {
+	Scores []float64 `json:"scores"`
+}
+
+// newRelevanceModel returns the model of spec: the URL of a service, or
+// else the command line of a local one, such as running a CLIP model
+// with ONNX Runtime, both given a relevanceRequest in JSON and answering
+// a relevanceResponse.
+func newRelevanceModel(spec, token string) relevanceModel {
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return &relevanceAPI{endpoint: spec, token: token, client: &http.Client{Timeout: relevanceTimeout}}
+	}
+	return relevanceCommand(strings.Fields(spec))
+}
+
+type relevanceAPI struct {
+	endpoint string
+	token    string // optional bearer token.
+	client   *http.Client
+}
+
+func (a *relevanceAPI) scores(ctx context.Context, text string, links []string) ([]float64, error) {
+	body, _ := json.Marshal(relevanceRequest{Text: text, Images: links})
+	req, err := http.NewRequestWithContext(ctx, "POST", a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return decodeRelevance(resp.Body, len(links))
+}
+
+type relevanceCommand []string
+
+func (c relevanceCommand) scores(ctx context.Context, text string, links []string) ([]float64, error) {
+	body, _ := json.Marshal(relevanceRequest{Text: text, Images: links})
+	cmd := exec.CommandContext(ctx, c[0], c[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return decodeRelevance(bytes.NewReader(out), len(links))
+}
+
+func decodeRelevance(r io.Reader, n int) ([]float64, error) {
+	var res relevanceResponse
+	if err := json.NewDecoder(r).Decode(&res); err != nil {
+		return nil, fmt.Errorf("unable to decode response: %w", err)
+	}
+	if len(res.Scores) != n {
+		return nil, fmt.Errorf("got %d scores for %d images", len(res.Scores), n)
+	}
+	return res.Scores, nil
+}
+
+// rankRelevance returns results sorted by decreasing relevance to query,
+// as scored by m, ties kept in order. Results are kept as they are if
+// they cannot be scored.
+func rankRelevance(m relevanceModel, query string, results []*google.ISR) []*google.ISR {
+	links := make([]string, len(results))
+	for i, isr := range results {
+		links[i] = isr.Link
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), relevanceTimeout)
+	defer cancel()
+	s, err := m.scores(ctx, query, links)
+	if err != nil {
+		logger.Warn("unable to score results relevance", "query", query, "error", err)
+		return results
+	}
+	scores := make(map[*google.ISR]float64, len(results))
+	for i, isr := range results {
+		scores[isr] = s[i]
+	}
+	ranked := slices.Clone(results)
+	slices.SortStableFunc(ranked, func(a, b *google.ISR) int {
+		return cmp.Compare(scores[b], scores[a])
+	})
+	return ranked
+}
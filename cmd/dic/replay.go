@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/discursive-image/dic/cache"
+	"github.com/discursive-image/dic/google"
+	"github.com/discursive-image/dic/journal"
+)
+
+const replayUsage = `Reconstructs the output of a batch from the audit journal, without
+searching again. The cache, if enabled, is repopulated with the
+journaled results.
+
+If "i" is set, each input record is enriched with the link journaled
+for its word. Otherwise, the query and link of every successful call
+are printed.`
+
+// replayRing returns the journaled results of a query, starting from
+// the link selected when the call was made.
+type replayRing struct {
+	items []*google.ISR
+	index int
+}
+
+func newReplayRing(e *journal.Entry) *replayRing {
+	r := &replayRing{items: e.Items}
+	for i, v := range e.Items {
+		if v.Link == e.Link {
+			r.index = i
+			break
+		}
+	}
+	return r
+}
+
+func (r *replayRing) next() string {
+	link := r.items[r.index].Link
+	r.index = (r.index + 1) % len(r.items)
+	return link
+}
+
+func readJournal(path string, f func(*journal.Entry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open journal: %w", err)
+	}
+	defer file.Close()
+	return journal.Read(file, f)
+}
+
+// readReplayRings reads the journals at paths, returning the rings of
+// the successful calls by the term they were searched for, as read, and
+// calling f for each. Later calls of the same term take precedence.
+func readReplayRings(paths []string, f func(*journal.Entry) error) (map[string]*replayRing, error) {
+	rings := make(map[string]*replayRing)
+	for _, path := range paths {
+		if err := readJournal(path, func(e *journal.Entry) error {
+			if e.Status != journal.StatusOK || len(e.Items) == 0 {
+				return nil
+			}
+			term := e.Term
+			if term == "" {
+				term = e.Query
+			}
+			rings[term] = newReplayRing(e)
+			return f(e)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return rings, nil
+}
+
+func runReplay(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	o := registerOptions(fs)
+	i := fs.String("i", "", "Optional input file to enrich, csv encoded. Use - for stdin.")
+	c := fs.Int("c", 3, "If \"i\" is used, selects the column which will be used as word input.")
+	fs.Usage = usageFor(fs, "replay [flags] <journal>...", replayUsage)
+	fs.Parse(args)
+	o.load(fs)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		exit(exitUsage)
+	}
+	dir := o.cacheDirStore()
+
+	w := csv.NewWriter(os.Stdout)
+	rings, err := readReplayRings(fs.Args(), func(e *journal.Entry) error {
+		if dir != nil {
+			// Journaled as searched with the filters of the flags.
+			k := signedKey(e.Query, searchSignature(o.filters()))
+			if err := dir.Put(&cache.Entry{Key: k, Created: e.Time, Items: e.Items}); err != nil {
+				return err
+			}
+		}
+		if *i == "" {
+			return w.Write([]string{e.Query, e.Link})
+		}
+		return nil
+	})
+	if err != nil {
+		exitf(err.Error())
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		exitf("unable to write output: %v", err)
+	}
+	if *i == "" {
+		return
+	}
+
+	r, err := openInputFile(*i)
+	if err != nil {
+		exitf(err.Error())
+	}
+	defer r.Close()
+
+	sum := newSummary()
+	csvr := csv.NewReader(r)
+	for {
+		rec, err := csvr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			sum.Error = fmt.Sprintf("unable to read input: %v", err)
+			logger.Error("unable to read input", "error", err)
+			break
+		}
+		req := &ImageRequest{c: *c, rec: rec}
+		ring, ok := rings[req.query()]
+		switch {
+		case *c >= len(rec):
+			req.err = fmt.Errorf("tried to access column %d out of %d", *c, len(rec))
+		case !ok:
+			req.err = fmt.Errorf("not journaled")
+		default:
+			req.hit = true
+			req.rec = append(req.rec, ring.next())
+		}
+		sum.record(req)
+		if req.err != nil {
+			logger.Error("unable to replay link", "query", req.query(), "error", req.err)
+			continue
+		}
+		if err := w.Write(req.rec); err != nil {
+			exitf("unable to write record to stdout: %v", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		exitf("unable to write record to stdout: %v", err)
+	}
+	sum.close()
+	if verbosity >= levelDefault {
+		sum.print(os.Stderr)
+	}
+	exit(sum.exitCode())
+}
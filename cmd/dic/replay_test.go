@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/discursive-image/dic/google"
+	"github.com/discursive-image/dic/journal"
+)
+
+func TestReplayNormalized(t *testing.T) {
+	steps, err := parseNormalize("trim,lower")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "journal.log")
+	j, err := journal.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []*google.ISR{{Link: "a"}, {Link: "b"}}
+	for _, term := range []string{" Cats", "dogs"} {
+		k := term
+		for _, step := range steps {
+			k = step(k)
+		}
+		journalCall(j, term, k, items, "b", nil)
+	}
+	j.Close()
+
+	var queries []string
+	rings, err := readReplayRings([]string{path}, func(e *journal.Entry) error {
+		queries = append(queries, e.Query)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 || queries[0] != "cats" || queries[1] != "dogs" {
+		t.Errorf("got queries %q, want the normalized ones", queries)
+	}
+	for _, term := range []string{" Cats", "dogs"} {
+		ring, ok := rings[term]
+		if !ok {
+			t.Errorf("%q: not journaled", term)
+			continue
+		}
+		if got := ring.next(); got != "b" {
+			t.Errorf("%q: got %q, want the link selected", term, got)
+		}
+	}
+	if _, ok := rings["cats"]; ok {
+		t.Errorf("replayed by the normalized query")
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discursive-image/dic"
+	"github.com/discursive-image/dic/google"
+)
+
+var fastClient = &http.Client{
+	Timeout: 2 * time.Second,
+}
+
+// selector chooses the images returned among the results of the keys,
+// checking and ordering them as told by the options. The pipelines get
+// it from their caches, so that they may choose differently, such as
+// the ones of the tenants of the server.
+type selector struct {
+	// checkLinks is set to discard the links that are not alive, and
+	// httpsOnly the ones that are not HTTPS ones, once upgraded if
+	// possible.
+	checkLinks, httpsOnly bool
+	// unique is set to return each link once, whatever the key, and
+	// dedup to skip the images nearly identical to the ones already
+	// used for other keys.
+	unique, dedup bool
+	// minWidth and minHeight are the minimum size of the images, in
+	// pixels, smaller ones being discarded, and minAspect and maxAspect
+	// bound their aspect ratio, width to height, if set.
+	minWidth, minHeight  int
+	minAspect, maxAspect float64
+	// blocklist holds the domains whose images are skipped, if set, and
+	// allowlist the only domains whose images are kept, if set.
+	blocklist, allowlist domainList
+	// maxPageAge is set to skip the images of the pages published
+	// longer ago, as told by their metadata.
+	maxPageAge time.Duration
+	// textMax is set to skip the images whose words recognized by
+	// tesseract, the path of its executable, cover at least this
+	// fraction of their area, such as memes and screenshots.
+	textMax   float64
+	tesseract string
+	// faces is set to skip the images without faces, or with faces.
+	faces string
+	// nsfw is set to skip the images it flags, if their score is at
+	// least nsfwThreshold.
+	nsfw          nsfwClassifier
+	nsfwThreshold float64
+	// rank is set to try the results best scored first, rather than in
+	// the order of the provider, preferring the rankTypes image types,
+	// such as jpeg, and trying the rankPenalized domains last, such as
+	// stock photo sites.
+	rank          bool
+	rankTypes     []string
+	rankPenalized domainList
+	// relevance is set to try the results most relevant to their key
+	// first, and llm to try the result chosen by an LLM first.
+	relevance relevanceModel
+	llm       *llmRanker
+	// diversify is set to alternate the domains of the results tried.
+	diversify bool
+	// pick is how the first result tried is picked, the nth one, from
+	// 1, being pickIndex.
+	pick      string
+	pickIndex int
+}
+
+// aspectTolerance is the relative difference allowed from an aspect
+// ratio required alone.
+const aspectTolerance = 0.1
+
+// parseAspect parses an aspect ratio, such as 16:9 or 1.5, matched
+// within aspectTolerance, or a range of them, such as 1:1-4:3, and
+// returns its bounds. Empty ratios are not bounded, as 0.
+func parseAspect(s string) (float64, float64, error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	ratio := func(s string) (float64, bool) {
+		ws, hs, ok := strings.Cut(s, ":")
+		w, err := strconv.ParseFloat(ws, 64)
+		if err != nil || w <= 0 {
+			return 0, false
+		}
+		if !ok {
+			return w, true
+		}
+		h, err := strconv.ParseFloat(hs, 64)
+		return w / h, err == nil && h > 0
+	}
+	los, his, isRange := strings.Cut(s, "-")
+	lo, ok := ratio(los)
+	hi := lo
+	if ok && isRange {
+		hi, ok = ratio(his)
+	}
+	if !ok || lo > hi {
+		return 0, 0, fmt.Errorf("invalid aspect ratio %q, expected <width>:<height> such as 16:9, or a range such as 1:1-4:3", s)
+	}
+	if !isRange {
+		lo, hi = lo*(1-aspectTolerance), hi*(1+aspectTolerance)
+	}
+	return lo, hi, nil
+}
+
+func discard(link string) bool {
+	resp, err := fastClient.Head(link)
+	if err != nil {
+		return true
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusMethodNotAllowed, http.StatusForbidden, http.StatusNotImplemented:
+		// Some hosts refuse HEAD requests, but serve ranges.
+		return discardRange(link)
+	}
+
+	// Discard we do not get a positive HTTP response.
+	if resp.StatusCode >= 400 {
+		return true
+	}
+	// If content type is not image, discard, unless the link is of
+	// generic content, whose first bytes tell.
+	t := resp.Header.Get("content-type")
+	if genericType(t) {
+		return discardRange(link)
+	}
+	if !imageType(t) {
+		return true
+	}
+	// If content-length is not greater than 0, discard.
+	l, err := strconv.Atoi(resp.Header.Get("content-length"))
+	if err != nil || l <= 0 {
+		return true
+	}
+	return false
+}
+
+// discardRange checks link with a GET request of its first bytes, which
+// tell its content type if it is generic.
+func discardRange(link string) bool {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return true
+	}
+	req.Header.Set("Range", "bytes=0-511")
+	resp, err := fastClient.Do(req)
+	if err != nil {
+		return true
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return true
+	}
+	head, err := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if err != nil || len(head) == 0 {
+		return true
+	}
+	t := resp.Header.Get("content-type")
+	if genericType(t) {
+		t = http.DetectContentType(head)
+	}
+	return !imageType(t)
+}
+
+// unfit reports whether the image of isr is smaller than the minimum
+// size, or of an aspect ratio out of the bounds, of s, as told by its
+// metadata, or else by its header. Images whose size cannot be told
+// are.
+func (s *selector) unfit(isr *google.ISR) bool {
+	if s.minWidth <= 0 && s.minHeight <= 0 && s.maxAspect == 0 {
+		return false
+	}
+	var w, h int
+	if isr.Image != nil {
+		w, h = isr.Image.Width, isr.Image.Height
+	}
+	if w <= 0 || h <= 0 {
+		var err error
+		if w, h, err = imageSize(isr.Link); err != nil {
+			logger.Debug("unable to tell image size", "link", isr.Link, "error", err)
+			return true
+		}
+	}
+	if w < s.minWidth || h < s.minHeight {
+		return true
+	}
+	a := float64(w) / float64(h)
+	return s.maxAspect > 0 && (a < s.minAspect || a > s.maxAspect)
+}
+
+// imageHeaderSize is the number of bytes fetched to decode the header
+// of an image, enough for the metadata preceding it in JPEG files.
+const imageHeaderSize = 64 << 10
+
+// imageSize returns the size of the image at link, decoded from its
+// header with a ranged GET request.
+func imageSize(link string) (int, int, error) {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", imageHeaderSize-1))
+	resp, err := fastClient.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	cfg, _, err := image.DecodeConfig(io.LimitReader(resp.Body, imageHeaderSize))
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// imageType reports whether the content type t is that of an image, and
+// not of a page or document.
+func imageType(t string) bool {
+	mt, _, err := mime.ParseMediaType(t)
+	return err == nil && strings.HasPrefix(mt, "image/")
+}
+
+// genericType reports whether the content type t does not tell the type
+// of the content, as served by some storages.
+func genericType(t string) bool {
+	mt, _, _ := mime.ParseMediaType(t)
+	switch mt {
+	case "", "application/octet-stream", "binary/octet-stream":
+		return true
+	}
+	return false
+}
+
+// accept reports whether the image of k is to be returned, along with
+// the image returned, whose link is upgraded to HTTPS if required.
+// Images nearly identical to the ones of other keys are not, if hashes
+// is set.
+func (s *selector) accept(k string, isr *google.ISR, hashes *imageHashes) (*google.ISR, bool) {
+	if s.blocklist.has(isr) || (s.allowlist != nil && !s.allowlist.has(isr)) || s.unfit(isr) {
+		return isr, false
+	}
+	switch {
+	case s.httpsOnly && strings.HasPrefix(isr.Link, "http://"):
+		// Checked whatever checkLinks, to tell whether the host serves
+		// the image over HTTPS.
+		link := upgradeLink(isr.Link)
+		if discard(link) {
+			return isr, false
+		}
+		upgraded := *isr
+		upgraded.Link = link
+		isr = &upgraded
+	case s.httpsOnly && !strings.HasPrefix(isr.Link, "https://"):
+		return isr, false
+	case s.checkLinks && discard(isr.Link):
+		return isr, false
+	}
+	if s.maxPageAge > 0 && s.stale(isr) {
+		return isr, false
+	}
+	// Images are downloaded last, once, by the slowest checks.
+	c := &candidate{link: isr.Link}
+	if s.textMax > 0 && s.mostlyText(c) {
+		return isr, false
+	}
+	if s.faces != "" && s.facesMismatch(c) {
+		return isr, false
+	}
+	if s.nsfw != nil && s.flagged(isr.Link) {
+		return isr, false
+	}
+	return isr, hashes == nil || hashes.claim(k, c)
+}
+
+// upgradeLink returns link with its HTTP scheme replaced by HTTPS.
+func upgradeLink(link string) string {
+	if rest, ok := strings.CutPrefix(link, "http://"); ok {
+		return "https://" + rest
+	}
+	return link
+}
+
+// candidateMaxBytes is the maximum size of the images downloaded to be
+// checked, such as by their hash.
+const candidateMaxBytes = 32 << 20
+
+var candidateClient = &http.Client{
+	Timeout: downloadTimeout,
+}
+
+// fetchCandidate downloads the image at link, to be checked.
+func fetchCandidate(link string) ([]byte, error) {
+	resp, err := candidateClient.Get(link)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, candidateMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > candidateMaxBytes {
+		return nil, fmt.Errorf("image larger than %d bytes", candidateMaxBytes)
+	}
+	return b, nil
+}
+
+// candidate is an image checked, downloaded by the first check needing
+// it, and decoded by the first one needing it decoded.
+type candidate struct {
+	link    string
+	fetched bool
+	b       []byte
+	err     error
+	decoded bool
+	img     image.Image
+	imgErr  error
+}
+
+// bytes returns the content of the image.
+func (c *candidate) bytes() ([]byte, error) {
+	if !c.fetched {
+		c.b, c.err = fetchCandidate(c.link)
+		c.fetched = true
+	}
+	return c.b, c.err
+}
+
+// image returns the image decoded.
+func (c *candidate) image() (image.Image, error) {
+	if !c.decoded {
+		b, err := c.bytes()
+		if err != nil {
+			return nil, err
+		}
+		c.img, _, c.imgErr = image.Decode(bytes.NewReader(b))
+		if c.imgErr != nil {
+			c.imgErr = fmt.Errorf("unable to decode image: %w", c.imgErr)
+		}
+		c.decoded = true
+	}
+	return c.img, c.imgErr
+}
+
+// resultStore persists search results across runs.
+type resultStore = dic.Store
+
+// searchSignature returns the parameters set by opts, such as
+// "imgSize=large&imgType=photo", along with the ones of the rendering of
+// the text searched, empty for the default ones. The results of searches
+// with different signatures must not be mixed.
+func searchSignature(opts []func(url.Values)) string {
+	v := make(url.Values)
+	for _, opt := range opts {
+		opt(v)
+	}
+	signRendering(v)
+	return v.Encode()
+}
+
+// signedKey returns the key under which the results of k searched with
+// the sig signature are stored, k itself for the default one.
+func signedKey(k, sig string) string {
+	if sig == "" {
+		return k
+	}
+	return k + " ?" + sig
+}
+
+// signedStore stores results under their signed key.
+type signedStore struct {
+	resultStore
+	sig string
+}
+
+func (s signedStore) Get(k string) ([]*google.ISR, bool, error) {
+	return s.resultStore.Get(signedKey(k, s.sig))
+}
+
+func (s signedStore) Set(k string, items []*google.ISR) error {
+	return s.resultStore.Set(signedKey(k, s.sig), items)
+}
+
+// signStore returns store storing the results of searches with the sig
+// signature apart from the others. Returns nil if store is.
+func signStore(store resultStore, sig string) resultStore {
+	if store == nil || sig == "" {
+		return store
+	}
+	return signedStore{resultStore: store, sig: sig}
+}
+
+// resultCaches are the caches of the results of a pipeline, one per
+// search signature, so that searches with different filters do not get
+// each other's images. They share their store, and choose their images
+// with sel.
+type resultCaches struct {
+	store resultStore // optional.
+	sel   *selector
+
+	mu sync.Mutex
+	m  map[string]*ringCache
+}
+
+func newResultCaches(store resultStore, sel *selector) *resultCaches {
+	return &resultCaches{
+		store: store,
+		sel:   sel,
+		m:     make(map[string]*ringCache),
+	}
+}
+
+// get returns the cache of the results of the searches with opts.
+func (c *resultCaches) get(opts []func(url.Values)) *ringCache {
+	sig := searchSignature(opts)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rc, ok := c.m[sig]
+	if !ok {
+		rc = newRingCache(signStore(c.store, sig), c.sel)
+		c.m[sig] = rc
+	}
+	return rc
+}
+
+// ringCache is a cache of search results, along with the check of the
+// images it returns.
+type ringCache struct {
+	*dic.Cache
+	check func(k string, isr *google.ISR) (*google.ISR, bool)
+}
+
+// newRingCache returns a cache of search results backed by store, if
+// not nil, whose images are checked and ordered by sel, from the one it
+// picks.
+func newRingCache(store resultStore, sel *selector) *ringCache {
+	c := &ringCache{
+		Cache: &dic.Cache{
+			Store:  store,
+			Order:  sel.order,
+			Start:  sel.start,
+			Unique: sel.unique,
+			OnError: func(k string, err error) {
+				logger.Error("cache store failed", "query", k, "error", err)
+			},
+		},
+	}
+	var hashes *imageHashes
+	if sel.dedup {
+		hashes = newImageHashes()
+	}
+	c.check = func(k string, isr *google.ISR) (*google.ISR, bool) {
+		return sel.accept(k, isr, hashes)
+	}
+	return c
+}
+
+// order returns the results of k ranked if s.rank is set, then by
+// relevance to k if s.relevance is, the one chosen by s.llm first if
+// set, alternating their domains if s.diversify is.
+func (s *selector) order(k string, results []*google.ISR) []*google.ISR {
+	if s.rank {
+		results = s.rankResults(results)
+	}
+	if s.relevance != nil && len(results) > 1 {
+		results = rankRelevance(s.relevance, k, results)
+	}
+	if s.llm != nil && len(results) > 1 {
+		results = s.llm.rerank(k, results)
+	}
+	if s.diversify {
+		results = diversify(results)
+	}
+	return results
+}
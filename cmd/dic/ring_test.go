@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseAspect(t *testing.T) {
+	for _, tt := range []struct {
+		s      string
+		lo, hi float64
+	}{
+		{"", 0, 0},
+		{"1", 0.9, 1.1},
+		{"1.5", 1.35, 1.65},
+		{"2:1", 1.8, 2.2},
+		{"16:9", 16.0 / 9 * 0.9, 16.0 / 9 * 1.1},
+		{"1:1-4:3", 1, 4.0 / 3},
+		{"1-2", 1, 2},
+		{"1:1-1:1", 1, 1},
+	} {
+		lo, hi, err := parseAspect(tt.s)
+		if err != nil {
+			t.Errorf("%q: %v", tt.s, err)
+			continue
+		}
+		if math.Abs(lo-tt.lo) > 1e-9 || math.Abs(hi-tt.hi) > 1e-9 {
+			t.Errorf("%q: got %g-%g, want %g-%g", tt.s, lo, hi, tt.lo, tt.hi)
+		}
+	}
+}
+
+func TestParseAspectInvalid(t *testing.T) {
+	for _, s := range []string{"wide", "0", "-1", "16:0", "16:", ":9", "4:3-1:1", "1:1-", "1:1-x"} {
+		if _, _, err := parseAspect(s); err == nil {
+			t.Errorf("%q: got no error", s)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/temoto/robotstxt"
+)
+
+// robotsChecker tells whether the robots.txt of their host allows links
+// to be fetched by agent. The robots.txt files are fetched once per
+// host, unless they cannot be.
+type robotsChecker struct {
+	agent  string
+	client *http.Client
+
+	mu     sync.Mutex
+	groups map[string]*robotstxt.Group // by scheme and host.
+}
+
+func newRobotsChecker(agent string, client *http.Client) *robotsChecker {
+	return &robotsChecker{agent: agent, client: client, groups: make(map[string]*robotstxt.Group)}
+}
+
+// allowed reports whether link may be fetched. As for the robots.txt
+// files missing, those that are not found allow everything, while
+// those failing with a server error disallow everything.
+func (rc *robotsChecker) allowed(ctx context.Context, link string) (bool, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false, err
+	}
+	origin := u.Scheme + "://" + u.Host
+	rc.mu.Lock()
+	g, ok := rc.groups[origin]
+	rc.mu.Unlock()
+	if !ok {
+		if g, err = rc.fetch(ctx, origin); err != nil {
+			return false, &transientError{fmt.Errorf("unable to fetch robots.txt: %w", err)}
+		}
+		rc.mu.Lock()
+		rc.groups[origin] = g
+		rc.mu.Unlock()
+	}
+	return g.Test(u.RequestURI()), nil
+}
+
+func (rc *robotsChecker) fetch(ctx context.Context, origin string) (*robotstxt.Group, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", rc.agent)
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := robotstxt.FromResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("robots.txt fetched", "origin", origin, "status", resp.StatusCode)
+	return data.FindGroup(rc.agent), nil
+}
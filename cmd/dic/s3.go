@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func isS3URL(s string) bool {
+	return strings.HasPrefix(s, "s3://")
+}
+
+// parseS3URL returns the bucket and key of an s3://<bucket>/<key> URL.
+func parseS3URL(s string) (bucket, key string, err error) {
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(s, "s3://"), "/")
+	if bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid s3 url %q, expected s3://<bucket>/<key>", s)
+	}
+	return bucket, key, nil
+}
+
+// newS3Client returns a client authenticated with the default AWS
+// credential chain: environment variables, shared files, then the
+// container or instance role.
+func newS3Client(ctx context.Context) (*s3.Client, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load aws config: %w", err)
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		// S3 compatible stores, set with AWS_ENDPOINT_URL, rarely
+		// support virtual hosted buckets.
+		o.UsePathStyle = os.Getenv("AWS_ENDPOINT_URL") != ""
+	}), nil
+}
+
+// openS3 returns the object at url, read as it is consumed.
+func openS3(ctx context.Context, url string) (io.ReadCloser, error) {
+	bucket, key, err := parseS3URL(url)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newS3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := c.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get s3 object: %w", err)
+	}
+	return obj.Body, nil
+}
+
+// s3Output uploads the records written to it to an object, in parts as
+// they are written. The object is only created once the output is
+// closed.
+type s3Output struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func openS3Output(ctx context.Context, url string) (*s3Output, error) {
+	bucket, key, err := parseS3URL(url)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newS3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	o := &s3Output{pw: pw, done: make(chan error, 1)}
+	go func() {
+		// The records are uploaded even if the run is canceled, as
+		// their search is complete.
+		_, err := manager.NewUploader(c).Upload(context.Background(), &s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(key),
+			Body:        pr,
+			ContentType: aws.String("text/csv"),
+		})
+		if err != nil {
+			err = fmt.Errorf("unable to upload s3 object: %w", err)
+		}
+		// Fail the writes following a failure.
+		pr.CloseWithError(err)
+		o.done <- err
+	}()
+	return o, nil
+}
+
+func (o *s3Output) Write(b []byte) (int, error) {
+	return o.pw.Write(b)
+}
+
+// Close completes the upload, and reports the first failure of the
+// output.
+func (o *s3Output) Close() error {
+	o.pw.Close()
+	return <-o.done
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/discursive-image/dic/google"
+	"github.com/discursive-image/dic/journal"
+)
+
+func handleQSearch(ctx context.Context, gsc *google.SC, j *journal.Writer, sel *selector, q string, opts ...func(url.Values)) {
+	text, err := searchText(ctx, q)
+	if err != nil {
+		exitf(err.Error())
+	}
+	if lang := termLanguage(ctx, q); lang != "" {
+		opts = append(opts, google.FilterLanguage(lang))
+	}
+	items, err := gsc.SearchImages(ctx, text, opts...)
+	var link string
+	if len(items) > 0 {
+		link = items[sel.start(len(items))].Link
+	}
+	journalCall(j, q, q, items, link, err)
+	if err != nil {
+		exitf(err.Error())
+	}
+	switch {
+	case len(items) == 0:
+		fmt.Printf("no results\n")
+	default:
+		fmt.Println(link)
+	}
+}
+
+func runSearch(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	o := registerOptions(fs)
+	fs.Usage = usageFor(fs, "search [flags] <query>", "Prints the link of the image found for query, the first one unless \"pick\" is set.")
+	fs.Parse(args)
+	o.load(fs)
+
+	q := queryKey(strings.Join(fs.Args(), " "))
+	if q == "" {
+		fs.Usage()
+		logger.Error("search query missing")
+		exit(exitUsage)
+	}
+	handleQSearch(ctx, o.searchClient(), o.journalWriter(), o.sel, q, o.filters()...)
+}
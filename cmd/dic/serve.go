@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+	"github.com/discursive-image/dic/openapi"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Job statuses.
+const (
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// job is an asynchronous batch submitted to the server.
+type job struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Summary  *summary   `json:"summary"`
+
+	out    bytes.Buffer // enriched csv, complete once not running.
+	client string       // name of the api key that submitted the job.
+
+	// Guarded by statusMu, like the summary.
+	rows    []rowResult   // outcome of the records processed so far.
+	updated chan struct{} // closed and replaced on each update.
+}
+
+// notify wakes up the clients waiting for updates of j. Must be
+// called with statusMu held.
+func (j *job) notify() {
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
+
+// server exposes the search pipeline over HTTP.
+type server struct {
+	ctx     context.Context // bounds the lifetime of the jobs.
+	p       *pipeline
+	tenants map[string]*pipeline // by api key name.
+	maxBody int64
+	jobTTL  time.Duration
+	auth    *authenticator
+	limiter *rateLimiter
+	events  *eventPublisher // optional.
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newServer(ctx context.Context, p *pipeline, maxBody int64, jobTTL time.Duration, auth *authenticator, limiter *rateLimiter) *server {
+	return &server{
+		ctx:     ctx,
+		p:       p,
+		maxBody: maxBody,
+		jobTTL:  jobTTL,
+		auth:    auth,
+		limiter: limiter,
+		jobs:    make(map[string]*job),
+		tenants: make(map[string]*pipeline),
+	}
+}
+
+// pipeline returns the pipeline of the client authenticated in ctx:
+// either the one of its tenant, or the server one.
+func (s *server) pipeline(ctx context.Context) *pipeline {
+	if p, ok := s.tenants[clientName(ctx)]; ok {
+		return p
+	}
+	return s.p
+}
+
+// routes returns the server handler. All the endpoints but the web
+// page, the OpenAPI document and the probes require authentication, if
+// enabled, and are rate limited.
+func (s *server) routes() (http.Handler, error) {
+	schema, err := newGraphQLSchema(s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build GraphQL schema: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /search", s.handleSearch)
+	mux.HandleFunc("POST /batch", s.handleBatch)
+	mux.HandleFunc("GET /batch/{id}", s.handleJob)
+	mux.HandleFunc("GET /batch/{id}/result", s.handleJobResult)
+	mux.HandleFunc("GET /batch/{id}/stream", s.handleJobStream)
+	mux.HandleFunc("POST /graphql", s.graphQLHandler(schema))
+	mux.Handle("GET /metrics", promhttp.Handler())
+
+	public := http.NewServeMux()
+	public.HandleFunc("GET /openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openapi.Spec)
+	})
+	public.HandleFunc("GET /{$}", handleUI)
+	public.HandleFunc("GET /healthz", handleHealthz)
+	public.HandleFunc("GET /readyz", s.handleReadyz)
+	public.Handle("/", s.auth.middleware(s.limiter.middleware(mux)))
+	return public, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Debug("unable to write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// searchErrorStatus maps a search failure to an HTTP status, whether
+// the search was issued, shared through the gate or its outcome cached:
+// the failures of the provider and the images found are reported as
+// such, the other ones as internal errors.
+func searchErrorStatus(err error) int {
+	var e *google.Error
+	var ue *url.Error
+	switch {
+	case errors.Is(err, errNoResults):
+		return http.StatusNotFound
+	case errors.As(err, &e) && e.Quota():
+		return http.StatusTooManyRequests
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, errDeadLinks), errors.As(err, &e), errors.As(err, &ue):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// filters returns the search options requested with the "type" and
+// "size" parameters, falling back on the server defaults.
+func (s *server) filters(typ, size string) []func(url.Values) {
+	return s.p.filters(typ, size)
+}
+
+// search looks for q through the pipeline, with the "type" and "size"
+// filters applied.
+func (s *server) search(ctx context.Context, q, typ, size string) *ImageRequest {
+	return s.pipeline(ctx).lookup(ctx, q, s.filters(typ, size))
+}
+
+type searchRequest struct {
+	Query string `json:"query"`
+	Type  string `json:"type"`
+	Size  string `json:"size"`
+}
+
+type searchResponse struct {
+	Query    string `json:"query"`
+	Link     string `json:"link"`
+	CacheHit bool   `json:"cache_hit"`
+}
+
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var req searchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBody)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unable to decode request: %w", err))
+		return
+	}
+	if req.Query == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("query missing"))
+		return
+	}
+
+	rw := s.search(r.Context(), req.Query, req.Type, req.Size)
+	if rw.err != nil {
+		writeError(w, searchErrorStatus(rw.err), rw.err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &searchResponse{
+		Query:    req.Query,
+		Link:     rw.rec[len(rw.rec)-1],
+		CacheHit: rw.hit,
+	})
+}
+
+type batchRequest struct {
+	// Column of the word in each record.
+	Column  int        `json:"column"`
+	Records [][]string `json:"records"`
+	// Queries is an alternative to Records, for single column inputs.
+	Queries []string `json:"queries"`
+	Type    string   `json:"type"`
+	Size    string   `json:"size"`
+}
+
+func encodeRecords(records [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(records); err != nil {
+		return nil, fmt.Errorf("unable to encode records: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBatch reads a batch either as csv, with the column and
+// filters passed in the query string, or as JSON.
+func (s *server) decodeBatch(r *http.Request, body io.Reader) (*batchRequest, []byte, error) {
+	req := &batchRequest{Column: s.p.c}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt == "application/json" {
+		req.Column = -1
+		if err := json.NewDecoder(body).Decode(req); err != nil {
+			return nil, nil, fmt.Errorf("unable to decode request: %w", err)
+		}
+		if len(req.Queries) > 0 {
+			req.Column = 0
+			for _, q := range req.Queries {
+				req.Records = append(req.Records, []string{q})
+			}
+		}
+		if req.Column < 0 {
+			req.Column = s.p.c
+		}
+		data, err := encodeRecords(req.Records)
+		if err != nil {
+			return nil, nil, err
+		}
+		return req, data, nil
+	}
+
+	q := r.URL.Query()
+	if v := q.Get("column"); v != "" {
+		c, err := strconv.Atoi(v)
+		if err != nil || c < 0 {
+			return nil, nil, fmt.Errorf("invalid column %q", v)
+		}
+		req.Column = c
+	}
+	req.Type, req.Size = q.Get("type"), q.Get("size")
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read request: %w", err)
+	}
+	return req, data, nil
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	req, data, err := s.decodeBatch(r, http.MaxBytesReader(w, r.Body, s.maxBody))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	j := s.start(r.Context(), req, data)
+	logger.Info("batch job submitted", "job", j.ID, "client", clientName(r.Context()))
+	w.Header().Set("Location", "/batch/"+j.ID)
+	s.writeJob(w, http.StatusAccepted, j)
+}
+
+// start runs the batch of csv records in data as an asynchronous job,
+// with the pipeline of the client authenticated in ctx.
+func (s *server) start(ctx context.Context, req *batchRequest, data []byte) *job {
+	j := &job{
+		ID:      newJobID(),
+		Status:  jobRunning,
+		Created: time.Now(),
+		Summary: newSummary(),
+		updated: make(chan struct{}),
+		client:  clientName(ctx),
+	}
+	s.mu.Lock()
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+
+	p := *s.pipeline(ctx)
+	p.c = req.Column
+	p.opts = s.filters(req.Type, req.Size)
+	p.onRecord = func(r *ImageRequest) {
+		j.rows = append(j.rows, newRowResult(r))
+		j.notify()
+		s.events.publish(newRowEvent(j.ID, r, j.Summary))
+	}
+	go s.run(&p, j, data)
+	return j
+}
+
+func (s *server) run(p *pipeline, j *job, data []byte) {
+	logger.Info("batch job started", "job", j.ID)
+	s.events.publish(&progressEvent{Type: "start", Job: j.ID, Time: time.Now()})
+	p.process(s.ctx, bytes.NewReader(data), flushWriter{csv.NewWriter(&j.out)}, j.Summary, nil)
+
+	statusMu.Lock()
+	j.Summary.close()
+	now := time.Now()
+	j.Finished = &now
+	j.Status = jobDone
+	if j.Summary.Error != "" || j.Summary.Canceled {
+		j.Status = jobFailed
+	}
+	j.notify()
+	statusMu.Unlock()
+	// The summary is no longer updated.
+	s.events.publish(&progressEvent{Type: "summary", Job: j.ID, Time: time.Now(), Summary: j.Summary})
+	logger.Info("batch job finished", "job", j.ID, "status", j.Status)
+}
+
+// writeJob encodes j. The summary is updated concurrently by the
+// running job, under statusMu.
+func (s *server) writeJob(w http.ResponseWriter, status int, j *job) {
+	statusMu.Lock()
+	b, err := json.Marshal(j)
+	statusMu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(b, '\n'))
+}
+
+// job returns the job called id, if it was submitted by the client
+// authenticated in ctx.
+func (s *server) job(ctx context.Context, id string) (*job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok || j.client != clientName(ctx) {
+		return nil, false
+	}
+	return j, true
+}
+
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.job(r.Context(), r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	s.writeJob(w, http.StatusOK, j)
+}
+
+func (s *server) handleJobResult(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.job(r.Context(), r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	statusMu.Lock()
+	running := j.Status == jobRunning
+	statusMu.Unlock()
+	if running {
+		writeError(w, http.StatusConflict, fmt.Errorf("job still running"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Write(j.out.Bytes())
+}
+
+// expireJobs drops the jobs finished more than jobTTL ago, until the
+// server context is done.
+func (s *server) expireJobs() {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-t.C:
+		}
+		statusMu.Lock()
+		s.mu.Lock()
+		for id, j := range s.jobs {
+			if j.Status != jobRunning && time.Since(*j.Finished) > s.jobTTL {
+				delete(s.jobs, id)
+			}
+		}
+		s.mu.Unlock()
+		statusMu.Unlock()
+	}
+}
+
+func runServe(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	o := registerOptions(fs)
+	addr := fs.String("addr", ":8080", "Address the HTTP server listens on.")
+	c := fs.Int("c", 3, "Default column of the word in the records of csv batches.")
+	j := fs.Int("j", 10, "Maximum number of concurrent searches per batch.")
+	maxBody := fs.Int64("max-body", 10, "Maximum request body size, in MB.")
+	jobTTL := fs.Duration("job-ttl", time.Hour, "Time after which the results of finished batch jobs are dropped.")
+	grpcAddr := fs.String("grpc-addr", "", "Optional address where the ImageSearch gRPC service is served.")
+	rateLimit := fs.Float64("rate-limit", 0, "Maximum requests per second per client, identified by api key or address. 0 means unlimited.")
+	rateBurst := fs.Int("rate-burst", 10, "Requests a client may issue at once above \"rate-limit\".")
+	debugAddr := fs.String("debug-addr", "", "Optional address where pprof profiles are served, at /debug/pprof/. Do not expose publicly.")
+	eo := registerEventOptions(fs)
+	fs.Usage = usageFor(fs, "serve [flags]", `Serves the search pipeline over HTTP.
+
+endpoints:
+  GET  /                   web page to enrich a csv file from a browser
+  POST /search             {"query": "cats", "type": "photo", "size": "large"}
+  POST /batch              csv body (with optional column, type and size query
+                           parameters) or {"column": 3, "records": [[...]]} or
+                           {"queries": ["cats", "dogs"]}; starts an asynchronous job
+  GET  /batch/{id}         job status and summary
+  GET  /batch/{id}/result  enriched csv, once the job is done
+  GET  /batch/{id}/stream  WebSocket streaming the outcome of each record as
+                           it completes, then the job status
+  POST /graphql            GraphQL API: searchImage(query, filters), job(id),
+                           jobs(first, after) and submitBatch(queries, filters)
+  GET  /metrics            Prometheus metrics
+  GET  /healthz            liveness probe
+  GET  /readyz             readiness probe, checking the cache directory and
+                           the provider credentials
+  GET  /openapi.yaml       OpenAPI document of the endpoints above, but the
+                           GraphQL and WebSocket ones
+
+If "grpc-addr" is set, the same searches and batches are also served
+by the ImageSearch gRPC service, see imagesearch/imagesearch.proto.
+
+If api keys are configured, either in the config file or in the
+`+envAPIKeys+` environment variable (as "name:key,name:key"), clients must
+pass one as a bearer token or in the X-API-Key header (gRPC metadata
+"authorization" or "x-api-key"). WebSocket clients may use the
+"access_token" query parameter instead.
+
+Api keys configured in the config file may also be tenants, with their
+own "google" credentials and "cache_namespace" (a subdirectory of
+"cache-dir", by default the key name): their requests neither share the
+server quota nor its cache.
+
+Clients exceeding "rate-limit" are answered with 429 Too Many Requests
+(gRPC RESOURCE_EXHAUSTED) and a Retry-After header. Api keys may have
+their own "rate_limit" and "rate_burst" in the config file.
+
+If "events-redis-url" or "events-webhook" is set, the progress of batch
+jobs is published as JSON events, as in batch mode.`)
+	fs.Parse(args)
+
+	cfg := o.load(fs)
+	if err := applyConfig(fs, map[string]string{
+		"addr":       cfg.Serve.Addr,
+		"grpc-addr":  cfg.Serve.GRPCAddr,
+		"rate-limit": strconv.FormatFloat(cfg.Serve.RateLimit, 'g', -1, 64),
+		"rate-burst": strconv.Itoa(cfg.Serve.RateBurst),
+		"c":          strconv.Itoa(cfg.Column),
+		"j":          strconv.Itoa(cfg.Concurrency),
+	}); err != nil {
+		exitf(err.Error())
+	}
+	if err := eo.applyConfig(fs, cfg); err != nil {
+		exitf(err.Error())
+	}
+	if *j <= 0 {
+		exitf("concurrency must be positive, got %d", *j)
+	}
+	if *debugAddr != "" {
+		go serveDebug(*debugAddr)
+	}
+
+	p := &pipeline{
+		gsc:     o.searchClient(),
+		c:       *c,
+		maxcc:   *j,
+		opts:    o.filters(),
+		caches:  newResultCaches(o.dirStore(), o.sel),
+		journal: o.journalWriter(),
+	}
+	keys := cfg.Serve.APIKeys
+	if v := os.Getenv(envAPIKeys); v != "" {
+		keys = parseAPIKeys(v)
+	}
+	auth, err := newAuthenticator(keys)
+	if err != nil {
+		exitf(err.Error())
+	}
+	if !auth.enabled() {
+		notice("no api keys configured, authentication is disabled")
+	}
+	limiter := newRateLimiter(limit{rate: *rateLimit, burst: *rateBurst}, auth.keys)
+	go limiter.expire(ctx)
+	s := newServer(ctx, p, *maxBody<<20, *jobTTL, auth, limiter)
+	s.events = eo.publisher()
+	defer s.events.close()
+	for _, k := range auth.keys {
+		if !k.tenant() {
+			continue
+		}
+		tp := *p
+		if k.Google.Key != "" {
+			tp.gsc = o.searchClientFor(k.Google.Key, k.Google.Cx)
+		}
+		sel, err := k.selector(o.sel)
+		if err != nil {
+			exitf("api key %q: %v", k.Name, err)
+		}
+		tp.caches = newResultCaches(o.namespaceStore(k.namespace()), sel)
+		s.tenants[k.Name] = &tp
+		notice("tenant configured", "client", k.Name, "cache_namespace", k.namespace())
+	}
+	go s.expireJobs()
+	if *grpcAddr != "" {
+		go serveGRPC(ctx, *grpcAddr, s)
+	}
+
+	h, err := s.routes()
+	if err != nil {
+		exitf(err.Error())
+	}
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+
+	notice("serving", "addr", *addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		exitf(err.Error())
+	}
+}
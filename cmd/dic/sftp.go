@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshAuth returns the methods to authenticate with: the password, if
+// set, the keys of the SSH agent, if running, and the unencrypted
+// default keys of ~/.ssh.
+func sshAuth(password string) []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+	if password != "" {
+		methods = append(methods, ssh.Password(password))
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	home, _ := os.UserHomeDir()
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		b, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		s, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			logger.Debug("ssh key skipped", "name", name, "error", err)
+			continue
+		}
+		signers = append(signers, s)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	return methods
+}
+
+// sftpUploader uploads images to a directory of an SFTP server.
+type sftpUploader struct {
+	c    *sftp.Client
+	link url.URL // of the server, without password.
+	bucketPrefix
+}
+
+// newSFTPUploader connects to the server of u as its user or else the
+// current one, authenticated with its password, or SFTP_PASSWORD, and
+// the keys found. The host key of the server must be in
+// ~/.ssh/known_hosts.
+func newSFTPUploader(u *url.URL, bp bucketPrefix) (*sftpUploader, error) {
+	name := u.User.Username()
+	if name == "" {
+		cur, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		name = cur.Username
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		password = os.Getenv("SFTP_PASSWORD")
+	}
+	home, _ := os.UserHomeDir()
+	hostKey, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read known hosts: %w", err)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            name,
+		Auth:            sshAuth(password),
+		HostKeyCallback: hostKey,
+		Timeout:         downloadTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to sftp server: %w", err)
+	}
+	c, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to start sftp session: %w", err)
+	}
+	// Paths are absolute, as in scp URLs.
+	bp.prefix = "/" + bp.prefix
+	return &sftpUploader{c: c, link: url.URL{Scheme: "sftp", User: url.User(name), Host: u.Host}, bucketPrefix: bp}, nil
+}
+
+// upload writes the image to a hidden partial file first, so that only
+// complete images are found in the directory.
+func (u *sftpUploader) upload(ctx context.Context, p, query string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	key := u.key(p, query)
+	if err := u.c.MkdirAll(path.Dir(key)); err != nil {
+		return "", fmt.Errorf("unable to create directory: %w", err)
+	}
+	part := path.Join(path.Dir(key), "."+path.Base(key)+".part")
+	w, err := u.c.Create(part)
+	if err != nil {
+		return "", fmt.Errorf("unable to upload image: %w", err)
+	}
+	_, err = w.ReadFrom(f)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = u.c.PosixRename(part, key)
+	}
+	if err != nil {
+		u.c.Remove(part)
+		return "", fmt.Errorf("unable to upload image: %w", err)
+	}
+	link := u.link
+	link.Path = key
+	return link.String(), nil
+}
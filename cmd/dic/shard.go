@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// shardPath returns the path of the nth shard of the output file at
+// path, such as out-00001.csv for out.csv.
+func shardPath(path string, n int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%05d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// shardOutput writes the records written to it to numbered output
+// files of up to rows records each. Like atomicFile, the shards are
+// written to temporary files, only renamed once committed.
+type shardOutput struct {
+	*recordSink
+	path string
+	rows int
+
+	mu     sync.Mutex
+	shards []*atomicFile
+}
+
+func openShards(path string, rows int) (*shardOutput, error) {
+	if rows <= 0 {
+		return nil, fmt.Errorf("rows per shard must be positive, got %d", rows)
+	}
+	o := &shardOutput{path: path, rows: rows}
+	o.recordSink = newRecordSink(o.write)
+	return o, nil
+}
+
+// next closes the current shard, if any, and creates the next one.
+func (o *shardOutput) next() (*atomicFile, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if n := len(o.shards); n > 0 {
+		if err := o.shards[n-1].Close(); err != nil {
+			return nil, err
+		}
+	}
+	f, err := createAtomic(shardPath(o.path, len(o.shards)+1))
+	if err != nil {
+		return nil, err
+	}
+	o.shards = append(o.shards, f)
+	return f, nil
+}
+
+func (o *shardOutput) write(recs <-chan []string) error {
+	var w *csv.Writer
+	n := 0
+	for rec := range recs {
+		if w == nil || n == o.rows {
+			if w != nil {
+				if w.Flush(); w.Error() != nil {
+					return w.Error()
+				}
+			}
+			f, err := o.next()
+			if err != nil {
+				return err
+			}
+			w, n = csv.NewWriter(f), 0
+		}
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+		n++
+	}
+	if w == nil {
+		// Empty runs still produce an output.
+		_, err := o.next()
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Close writes the pending records, and reports the first failure of
+// the output.
+func (o *shardOutput) Close() error {
+	if err := o.recordSink.Close(); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.shards[len(o.shards)-1].Close()
+}
+
+// commit renames the shards, which must be closed.
+func (o *shardOutput) commit() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, f := range o.shards {
+		if err := f.commit(); err != nil {
+			return err
+		}
+	}
+	logger.Debug("output shards written", "count", len(o.shards))
+	return nil
+}
+
+// discard removes the shards not committed.
+func (o *shardOutput) discard() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, f := range o.shards {
+		f.discard()
+	}
+}
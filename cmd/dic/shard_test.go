@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestShardPath(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		n    int
+		want string
+	}{
+		{"out.csv", 1, "out-00001.csv"},
+		{"out.csv", 42, "out-00042.csv"},
+		{"out.csv", 123456, "out-123456.csv"},
+		{"out", 1, "out-00001"},
+		{"dir/out.tsv", 3, "dir/out-00003.tsv"},
+		{"my.dir/out", 2, "my.dir/out-00002"},
+		{"out.tar.gz", 1, "out.tar-00001.gz"},
+	} {
+		if got := shardPath(tt.path, tt.n); got != tt.want {
+			t.Errorf("%q, %d: got %q, want %q", tt.path, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/draw"
+)
+
+// embedImageSize is the maximum size, in pixels, of the images embedded
+// in contact sheets and slideshows, enough to print or show a page.
+const embedImageSize = 1600
+
+// embedImage decodes the image at path, scaled down to embedImageSize,
+// and returns it encoded as JPEG, with its size.
+func embedImage(path string) ([]byte, int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("unable to decode image: %w", err)
+	}
+	b := src.Bounds()
+	w, h := fit(b.Dx(), b.Dy(), embedImageSize, embedImageSize)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	// JPEG has no transparency, drawn over white instead of black.
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, 0, 0, err
+	}
+	return buf.Bytes(), w, h, nil
+}
+
+// contactSheet lays out images with their caption in a grid of cols x
+// rows per page.
+type contactSheet struct {
+	pdf        *fpdf.Fpdf
+	tr         func(string) string
+	cols, rows int
+	n          int // images laid out.
+	// images are the images embedded, by path, each once however many
+	// times it is laid out.
+	images map[string]sheetImageInfo
+}
+
+type sheetImageInfo struct {
+	name string
+	w, h int
+}
+
+func newContactSheet(pageSize string, cols, rows int) *contactSheet {
+	orientation := "P"
+	if cols > rows {
+		orientation = "L"
+	}
+	pdf := fpdf.New(orientation, "mm", pageSize, "")
+	pdf.SetMargins(10, 10, 10)
+	pdf.SetAutoPageBreak(false, 0)
+	return &contactSheet{
+		pdf:    pdf,
+		tr:     pdf.UnicodeTranslatorFromDescriptor(""),
+		cols:   cols,
+		rows:   rows,
+		images: make(map[string]sheetImageInfo),
+	}
+}
+
+// add lays out the image at path, captioned with caption, in the next
+// cell of the grid.
+func (cs *contactSheet) add(path, caption string) error {
+	img, ok := cs.images[path]
+	if !ok {
+		data, w, h, err := embedImage(path)
+		if err != nil {
+			return err
+		}
+		img = sheetImageInfo{name: fmt.Sprintf("img%d", len(cs.images)), w: w, h: h}
+		cs.pdf.RegisterImageOptionsReader(img.name, fpdf.ImageOptions{ImageType: "JPG"}, bytes.NewReader(data))
+		cs.images[path] = img
+	}
+	i := cs.n % (cs.cols * cs.rows)
+	if i == 0 {
+		cs.pdf.AddPage()
+	}
+	cs.n++
+
+	pw, ph := cs.pdf.GetPageSize()
+	left, top, right, bottom := cs.pdf.GetMargins()
+	cw, ch := (pw-left-right)/float64(cs.cols), (ph-top-bottom)/float64(cs.rows)
+	x, y := left+float64(i%cs.cols)*cw, top+float64(i/cs.cols)*ch
+	// Captions are large enough to be read from the back of a class on
+	// a single image per page.
+	fontSize := max(10, 36/float64(cs.rows))
+	captionH := fontSize * 0.6
+	pad := 3.0
+
+	// Images are fitted in their cell above their caption, centered.
+	maxw, maxh := cw-2*pad, ch-2*pad-captionH
+	iw, ih := maxw, maxw*float64(img.h)/float64(img.w)
+	if ih > maxh {
+		iw, ih = maxh*float64(img.w)/float64(img.h), maxh
+	}
+	cs.pdf.ImageOptions(img.name, x+(cw-iw)/2, y+pad+(maxh-ih)/2, iw, ih, false, fpdf.ImageOptions{ImageType: "JPG"}, 0, "")
+
+	cs.pdf.SetFont("Helvetica", "B", fontSize)
+	cs.pdf.SetXY(x, y+ch-pad-captionH)
+	cs.pdf.CellFormat(cw, captionH, cs.tr(caption), "", 0, "C", false, 0, "")
+	return cs.pdf.Error()
+}
+
+// write writes the sheet to w.
+func (cs *contactSheet) write(w io.Writer) error {
+	if cs.n == 0 {
+		// Blank documents are still valid ones.
+		cs.pdf.AddPage()
+	}
+	return cs.pdf.Output(w)
+}
+
+func runSheet(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("sheet", flag.ExitOnError)
+	o := registerOptions(fs)
+	i := fs.String("i", "-", "Input file containing the records of the images, such as a download output. csv encoded, use - for stdin.")
+	out := fs.String("o", "sheet.pdf", "PDF file where the contact sheet is written.")
+	c := fs.Int("c", 3, "Selects the column holding the caption of the images, such as their query.")
+	p := fs.Int("p", -1, "Selects the column holding the path of the images. Negative values count from the last column, -1.")
+	grid := fs.String("grid", "1x1", "Number of images per page, as <columns>x<rows>, such as 3x4.")
+	pageSize := fs.String("page", "A4", "Page size: A4, A3, A5, Letter or Legal.")
+	fs.Usage = usageFor(fs, "sheet [flags]", `Writes a PDF contact sheet of the images of a csv input, such as the output
+of download, each captioned with its query, one per page or in a grid, to be
+printed as handouts. Captions are limited to Western European characters.`)
+	fs.Parse(args)
+	o.load(fs)
+
+	cols, rows, err := parseSize(*grid)
+	if err != nil {
+		exitf("invalid grid: %v", err)
+	}
+	switch *pageSize {
+	case "A3", "A4", "A5", "Letter", "Legal":
+	default:
+		exitf("invalid page size %q, expected A4, A3, A5, Letter or Legal", *pageSize)
+	}
+	r, err := openInputFile(*i)
+	if err != nil {
+		exitf(err.Error())
+	}
+	defer r.Close()
+
+	cs := newContactSheet(*pageSize, cols, rows)
+	csvr := csv.NewReader(r)
+	csvr.FieldsPerRecord = -1
+	failed := 0
+	for row := 1; ctx.Err() == nil; row++ {
+		rec, err := csvr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			exitf("unable to read input: %v", err)
+		}
+		pi := *p
+		if pi < 0 {
+			pi += len(rec)
+		}
+		if pi < 0 || pi >= len(rec) || *c >= len(rec) {
+			logger.Error("unable to add image", "row", row, "error", fmt.Errorf("tried to access column %d or %d out of %d", *p, *c, len(rec)))
+			failed++
+			continue
+		}
+		if err := cs.add(rec[pi], rec[*c]); err != nil {
+			logger.Error("unable to add image", "row", row, "path", rec[pi], "error", err)
+			failed++
+		}
+	}
+	if ctx.Err() != nil {
+		exit(exitCanceled)
+	}
+
+	f, err := createAtomic(*out)
+	if err != nil {
+		exitf(err.Error())
+	}
+	atExit(f.discard)
+	err = cs.write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = f.commit()
+	}
+	if err != nil {
+		exitf("unable to write contact sheet: %v", err)
+	}
+	notice("contact sheet written", "images", cs.n, "failed", failed)
+	if failed > 0 {
+		exit(exitRowErrors)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/discursive-image/dic/google"
+	"golang.org/x/oauth2"
+	googleauth "golang.org/x/oauth2/google"
+)
+
+// sheetRange is a range of a Google spreadsheet, given as
+// sheets://<spreadsheet id>/<range>, the range being in A1 notation
+// such as "Words!A2:C", or a sheet name. The first sheet is used if the
+// range is missing.
+type sheetRange struct {
+	id  string
+	rng string
+	// sheet is the sheet part of rng, ending with "!", if any, and row
+	// the first row of rng, from 1.
+	sheet string
+	row   int
+}
+
+func isSheetURL(s string) bool {
+	return strings.HasPrefix(s, "sheets://")
+}
+
+// a1Cells matches the cells part of a range in A1 notation.
+var a1Cells = regexp.MustCompile(`^([A-Za-z]*)([0-9]*)(:[A-Za-z]*[0-9]*)?$`)
+
+func parseSheetURL(s string) (*sheetRange, error) {
+	id, rng, _ := strings.Cut(strings.TrimPrefix(s, "sheets://"), "/")
+	rng, err := url.PathUnescape(rng)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sheets url %q: %w", s, err)
+	}
+	if id == "" {
+		return nil, fmt.Errorf("invalid sheets url %q: spreadsheet id missing", s)
+	}
+	r := &sheetRange{id: id, rng: rng, row: 1}
+	cells := rng
+	if i := strings.LastIndex(rng, "!"); i >= 0 {
+		r.sheet, cells = rng[:i+1], rng[i+1:]
+	}
+	m := a1Cells.FindStringSubmatch(cells)
+	switch {
+	case rng == "":
+		r.rng = "A:ZZ"
+		return r, nil
+	case cells == "":
+		r.rng = r.sheet + "A:ZZ"
+		return r, nil
+	case m == nil && r.sheet != "":
+		return nil, fmt.Errorf("invalid sheets url %q: invalid range", s)
+	case m == nil:
+		// A sheet name alone.
+		r.sheet = "'" + strings.ReplaceAll(cells, "'", "''") + "'!"
+		r.rng = r.sheet + "A:ZZ"
+		return r, nil
+	}
+	if m[2] != "" {
+		r.row, _ = strconv.Atoi(m[2])
+	}
+	return r, nil
+}
+
+// sheetsClient returns a Google Sheets client authenticated with the
+// application default credentials, if any, which can write to the
+// spreadsheets shared with them, or else with the API key, which can
+// only read public ones.
+func (o *options) sheetsClient(ctx context.Context) *google.Sheets {
+	creds, err := googleauth.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/spreadsheets")
+	if err != nil {
+		logger.Debug("no google application default credentials, using the api key", "error", err)
+		return &google.Sheets{Key: *o.key}
+	}
+	return &google.Sheets{Client: oauth2.NewClient(context.Background(), creds.TokenSource)}
+}
+
+// openSheet returns the rows of the in range as csv records. Rows are
+// padded to the same length, and empty rows kept, so that the records
+// match the rows of the range.
+func openSheet(ctx context.Context, sc *google.Sheets, in string) (io.ReadCloser, error) {
+	r, err := parseSheetURL(in)
+	if err != nil {
+		return nil, err
+	}
+	vr, err := sc.Values(ctx, r.id, r.rng)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read spreadsheet: %w", err)
+	}
+	width := 1
+	for _, row := range vr.Values {
+		width = max(width, len(row))
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	for _, row := range vr.Values {
+		if strings.Join(row, "") == "" {
+			// Written by hand, as csv writers and readers skip empty
+			// lines.
+			w.Flush()
+			buf.WriteString(`""` + strings.Repeat(",", width-1) + "\n")
+			continue
+		}
+		rec := make([]string, width)
+		copy(rec, row)
+		w.Write(rec)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(&buf), nil
+}
+
+// sheetOutput writes the links of the records read from a range in
+// place, in the column of the range rows. Writes are discarded: the
+// links are taken from the requests passed to record, and written once
+// the output is closed.
+type sheetOutput struct {
+	sc     *google.Sheets
+	r      *sheetRange
+	column string
+	data   []*google.ValueRange
+}
+
+// sheetUpdateSize is the maximum number of cells written per request.
+const sheetUpdateSize = 1000
+
+func newSheetOutput(sc *google.Sheets, out, column string) (*sheetOutput, error) {
+	r, err := parseSheetURL(out)
+	if err != nil {
+		return nil, err
+	}
+	if !regexp.MustCompile(`^[A-Za-z]+$`).MatchString(column) {
+		return nil, fmt.Errorf("invalid sheet column %q, expected a column name such as D", column)
+	}
+	return &sheetOutput{sc: sc, r: r, column: strings.ToUpper(column)}, nil
+}
+
+// record accounts for the link of r, if it was found. Must be called
+// with the requests of the records read from the range.
+func (o *sheetOutput) record(r *ImageRequest) {
+	if r.err != nil {
+		return
+	}
+	cell := o.r.sheet + o.column + strconv.Itoa(o.r.row+r.row-1)
+	o.data = append(o.data, &google.ValueRange{
+		Range:  cell,
+		Values: [][]string{{r.rec[len(r.rec)-1]}},
+	})
+}
+
+func (o *sheetOutput) Write(rec []string) error {
+	return nil
+}
+
+// Close writes the links recorded.
+func (o *sheetOutput) Close() error {
+	// The records processed are written even if the run is canceled.
+	ctx := context.Background()
+	for len(o.data) > 0 {
+		n := min(len(o.data), sheetUpdateSize)
+		if _, err := o.sc.Update(ctx, o.r.id, o.data[:n]); err != nil {
+			return fmt.Errorf("unable to update spreadsheet: %w", err)
+		}
+		logger.Debug("spreadsheet updated", "cells", n)
+		o.data = o.data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// defaultHistoryFile returns the file where the shell history is kept by
+// default, in the home directory.
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".dic_history")
+}
+
+func runShell(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("shell", flag.ExitOnError)
+	o := registerOptions(fs)
+	history := fs.String("history", defaultHistoryFile(), "File where the queries typed are kept across sessions. Disabled if empty.")
+	fs.Usage = usageFor(fs, "shell [flags]", `Searches each line typed on stdin as soon as it is entered, and prints the
+link of the first image found. Use the arrow keys to browse the previous
+queries, Ctrl-D to quit.`)
+	fs.Parse(args)
+	o.load(fs)
+
+	p := &pipeline{
+		gsc:     o.searchClient(),
+		opts:    o.filters(),
+		caches:  newResultCaches(o.dirStore(), o.sel),
+		journal: o.journalWriter(),
+	}
+	prompt := ""
+	if readline.DefaultIsTerminal() {
+		prompt = "dic> "
+	}
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          prompt,
+		HistoryFile:     *history,
+		InterruptPrompt: "^C",
+		EOFPrompt:       "exit",
+	})
+	if err != nil {
+		exitf("unable to read stdin: %v", err)
+	}
+	defer rl.Close()
+	// Interrupts are read as ^C rather than signals while a line is
+	// edited; searches are canceled by closing the session.
+	go func() {
+		<-ctx.Done()
+		rl.Close()
+	}()
+
+	for {
+		line, err := rl.Readline()
+		switch {
+		case errors.Is(err, readline.ErrInterrupt) && line != "":
+			continue
+		case errors.Is(err, readline.ErrInterrupt), errors.Is(err, io.EOF):
+			return
+		case err != nil:
+			exitf("unable to read stdin: %v", err)
+		}
+		q := strings.TrimSpace(line)
+		if q == "" {
+			continue
+		}
+		rw := p.lookup(ctx, q, p.opts)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(rw.err, errNoResults):
+			fmt.Fprintln(rl.Stdout(), "no results")
+		case rw.err != nil:
+			fmt.Fprintf(rl.Stderr(), "error: %v\n", rw.err)
+		default:
+			fmt.Fprintln(rl.Stdout(), rw.rec[len(rw.rec)-1])
+		}
+	}
+}
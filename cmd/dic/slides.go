@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	_ "embed"
+)
+
+// slidesPage is the template of the slideshows, showing each image with
+// its term in turn, or all of them as a collage.
+//
+//go:embed ui/slides.html
+var slidesPage string
+
+var slidesTemplate = template.Must(template.New("slides").Parse(slidesPage))
+
+type slide struct {
+	Term string
+	Src  template.URL
+}
+
+// slideSource returns the source of the image at p: the link itself if
+// it is one, or else the image file embedded as a data URL, looked up
+// first in embedded.
+func slideSource(p string, embedded map[string]template.URL) (template.URL, error) {
+	if strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://") {
+		return template.URL(p), nil
+	}
+	if src, ok := embedded[p]; ok {
+		return src, nil
+	}
+	data, _, _, err := embedImage(p)
+	if err != nil {
+		return "", err
+	}
+	src := template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(data))
+	embedded[p] = src
+	return src, nil
+}
+
+func runSlides(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("slides", flag.ExitOnError)
+	o := registerOptions(fs)
+	i := fs.String("i", "-", "Input file containing the records of the images, such as a download or batch output. csv encoded, use - for stdin.")
+	out := fs.String("o", "slides.html", "HTML file where the slideshow is written.")
+	c := fs.Int("c", 3, "Selects the column holding the term shown with the images, such as their query.")
+	p := fs.Int("p", -1, "Selects the column holding the path or link of the images. Negative values count from the last column, -1.")
+	title := fs.String("title", "dic", "Title of the slideshow.")
+	interval := fs.Duration("interval", 5*time.Second, "Time each image is shown before the next one. 0 means until a key is pressed.")
+	fs.Usage = usageFor(fs, "slides [flags]", `Writes an HTML slideshow cycling through the terms and images of a csv input,
+such as the output of download. Downloaded images are embedded in the page,
+which is then self-contained, while links are shown as they are. Use the
+arrow keys to navigate, space to pause and g to show all the images as a
+collage.`)
+	fs.Parse(args)
+	o.load(fs)
+
+	if *interval < 0 {
+		exitf("interval must be positive, got %v", *interval)
+	}
+	r, err := openInputFile(*i)
+	if err != nil {
+		exitf(err.Error())
+	}
+	defer r.Close()
+
+	var slides []slide
+	embedded := make(map[string]template.URL)
+	csvr := csv.NewReader(r)
+	csvr.FieldsPerRecord = -1
+	failed := 0
+	for row := 1; ctx.Err() == nil; row++ {
+		rec, err := csvr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			exitf("unable to read input: %v", err)
+		}
+		pi := *p
+		if pi < 0 {
+			pi += len(rec)
+		}
+		if pi < 0 || pi >= len(rec) || *c >= len(rec) {
+			logger.Error("unable to add image", "row", row, "error", fmt.Errorf("tried to access column %d or %d out of %d", *p, *c, len(rec)))
+			failed++
+			continue
+		}
+		src, err := slideSource(rec[pi], embedded)
+		if err != nil {
+			logger.Error("unable to add image", "row", row, "path", rec[pi], "error", err)
+			failed++
+			continue
+		}
+		slides = append(slides, slide{Term: rec[*c], Src: src})
+	}
+	if ctx.Err() != nil {
+		exit(exitCanceled)
+	}
+
+	f, err := createAtomic(*out)
+	if err != nil {
+		exitf(err.Error())
+	}
+	atExit(f.discard)
+	err = slidesTemplate.Execute(f, struct {
+		Title    string
+		Interval int64
+		Slides   []slide
+	}{*title, interval.Milliseconds(), slides})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = f.commit()
+	}
+	if err != nil {
+		exitf("unable to write slideshow: %v", err)
+	}
+	notice("slideshow written", "images", len(slides), "failed", failed)
+	if failed > 0 {
+		exit(exitRowErrors)
+	}
+}
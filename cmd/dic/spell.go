@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// spellChecker, if set, corrects the typos of the terms before they are
+// looked up and searched.
+var spellChecker *spellDict
+
+// spellMinLength is the length of the shortest words corrected, shorter
+// ones being too ambiguous.
+const spellMinLength = 4
+
+// spellDict corrects the words missing from a dictionary to the closest
+// ones in it, remembering the corrections of the run.
+type spellDict struct {
+	// freqs are the frequencies of the words, by word.
+	freqs map[string]int
+	// byLen are the words, by length in runes.
+	byLen map[int][]string
+
+	mu   sync.Mutex
+	done map[string]string
+}
+
+// correctQuery returns q with its typos corrected by spellChecker, if
+// set.
+func correctQuery(q string) string {
+	if spellChecker == nil {
+		return q
+	}
+	return spellChecker.correct(q)
+}
+
+// loadSpellDict reads the dictionary at path, one word per line,
+// optionally followed by its frequency, such as /usr/share/dict/words or
+// a frequency list. Hunspell affix flags, after a slash, are ignored.
+// Without frequencies, earlier words are preferred.
+func loadSpellDict(path string) (*spellDict, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := &spellDict{
+		freqs: make(map[string]int),
+		byLen: make(map[int][]string),
+		done:  make(map[string]string),
+	}
+	s := bufio.NewScanner(f)
+	for n := 0; s.Scan(); n++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		w, _, _ := strings.Cut(fields[0], "/")
+		if _, err := strconv.Atoi(w); err == nil {
+			// Word counts heading Hunspell dictionaries.
+			continue
+		}
+		w = strings.ToLower(w)
+		if _, ok := d.freqs[w]; ok {
+			continue
+		}
+		freq := -n
+		if len(fields) > 1 {
+			if c, err := strconv.Atoi(fields[1]); err == nil {
+				freq = c
+			}
+		}
+		d.freqs[w] = freq
+		l := utf8.RuneCountInString(w)
+		d.byLen[l] = append(d.byLen[l], w)
+	}
+	return d, s.Err()
+}
+
+// correct returns q with its misspelled words corrected.
+func (d *spellDict) correct(q string) string {
+	words := strings.Fields(q)
+	for i, w := range words {
+		core := strings.TrimFunc(w, unicode.IsPunct)
+		if c := d.correctWord(core); c != core {
+			start := len(w) - len(strings.TrimLeftFunc(w, unicode.IsPunct))
+			words[i] = w[:start] + c + w[start+len(core):]
+		}
+	}
+	if c := strings.Join(words, " "); c != strings.Join(strings.Fields(q), " ") {
+		return c
+	}
+	return q
+}
+
+// correctWord returns the word of the dictionary closest to w, the most
+// frequent one among the closest, or w itself if it is in the
+// dictionary, is not a plain word or has no close one. Up to one edit is
+// corrected, two for words of 8 letters or more.
+func (d *spellDict) correctWord(w string) string {
+	lw := strings.ToLower(w)
+	n := utf8.RuneCountInString(lw)
+	if _, ok := d.freqs[lw]; ok || n < spellMinLength || strings.IndexFunc(lw, func(r rune) bool { return !unicode.IsLetter(r) }) >= 0 {
+		return w
+	}
+	d.mu.Lock()
+	c, ok := d.done[lw]
+	d.mu.Unlock()
+	if !ok {
+		maxDist := 1
+		if n >= 8 {
+			maxDist = 2
+		}
+		c = lw
+		best := maxDist + 1
+		for l := n - maxDist; l <= n+maxDist; l++ {
+			for _, cand := range d.byLen[l] {
+				dist := editDistance(lw, cand, maxDist)
+				if dist > maxDist {
+					continue
+				}
+				if dist < best || dist == best && d.freqs[cand] > d.freqs[c] {
+					c, best = cand, dist
+				}
+			}
+		}
+		d.mu.Lock()
+		d.done[lw] = c
+		d.mu.Unlock()
+	}
+	if c == lw {
+		return w
+	}
+	if r, _ := utf8.DecodeRuneInString(w); unicode.IsUpper(r) {
+		first, size := utf8.DecodeRuneInString(c)
+		c = string(unicode.ToUpper(first)) + c[size:]
+	}
+	return c
+}
+
+// editDistance returns the Damerau-Levenshtein distance between a and b,
+// counting transpositions as one edit, or limit+1 if it exceeds limit.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	// Three rows of the distance matrix, for transpositions.
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return min(prev[len(rb)], limit+1)
+}
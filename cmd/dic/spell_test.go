@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCorrectQuery(t *testing.T) {
+	dict := "# words\n3\ncat/S 50\nhouse 100\nhorse 200\nelephant\nelegant 10\ncity\n"
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte(dict), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := loadSpellDict(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { spellChecker = nil })
+
+	if got := correctQuery("hosue"); got != "hosue" {
+		t.Errorf("without dictionary: got %q", got)
+	}
+	spellChecker = d
+	for _, tt := range []struct{ in, want string }{
+		{"house", "house"},
+		{"hosue", "house"},
+		{"Hosue", "House"},
+		{"hous", "house"},
+		{"hourse", "horse"},
+		{"elephnat", "elephant"},
+		{"elehpnat", "elephant"},
+		{"cit", "cit"},
+		{"citty", "city"},
+		{"hosue, citty!", "house, city!"},
+		{"hosue  citty", "house city"},
+		{"house  city", "house  city"},
+		{"h0use", "h0use"},
+		{"zzzzz", "zzzzz"},
+		{"", ""},
+	} {
+		if got := correctQuery(tt.in); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tt := range []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"cat", "cat", 1, 0},
+		{"cat", "cut", 1, 1},
+		{"cat", "act", 1, 1},
+		{"cat", "cats", 1, 1},
+		{"cat", "dog", 1, 2},
+		{"cat", "dog", 3, 3},
+		{"café", "cafe", 1, 1},
+		{"", "cat", 5, 3},
+	} {
+		if got := editDistance(tt.a, tt.b, tt.limit); got != tt.want {
+			t.Errorf("%q, %q, %d: got %d, want %d", tt.a, tt.b, tt.limit, got, tt.want)
+		}
+	}
+}
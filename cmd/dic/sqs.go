@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// sqsQueue is a queue backed by an SQS queue, whose results are sent to
+// another SQS queue or to an SNS topic. Messages are deleted once their
+// result is sent; until then, their visibility timeout is extended so
+// that they are not delivered to other workers, and those being
+// processed when the worker dies are delivered again once it expires.
+type sqsQueue struct {
+	sqs *sqs.Client
+	sns *sns.Client
+	in  string
+	out string
+	// visibility is the visibility timeout of the messages being
+	// processed, extended every half of it.
+	visibility time.Duration
+
+	// urls caches the URLs of the queues known by name.
+	urls sync.Map
+}
+
+// isTopic reports whether dest is an SNS topic ARN rather than an SQS
+// queue.
+func isTopic(dest string) bool {
+	return strings.HasPrefix(dest, "arn:") && strings.Contains(dest, ":sns:")
+}
+
+func newSQSQueue(in, out string, visibility time.Duration) (*sqsQueue, error) {
+	// Region, credentials and endpoints come from the standard AWS
+	// environment variables and files.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load aws configuration: %w", err)
+	}
+	q := &sqsQueue{
+		sqs:        sqs.NewFromConfig(cfg),
+		sns:        sns.NewFromConfig(cfg),
+		out:        out,
+		visibility: visibility,
+	}
+	if q.in, err = q.queueURL(ctx, in); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// queueURL returns the URL of the queue named name, which can be a URL
+// already.
+func (q *sqsQueue) queueURL(ctx context.Context, name string) (string, error) {
+	if strings.HasPrefix(name, "https://") || strings.HasPrefix(name, "http://") {
+		return name, nil
+	}
+	if u, ok := q.urls.Load(name); ok {
+		return u.(string), nil
+	}
+	res, err := q.sqs.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(name)})
+	if err != nil {
+		return "", fmt.Errorf("unable to find queue %q: %w", name, err)
+	}
+	q.urls.Store(name, *res.QueueUrl)
+	return *res.QueueUrl, nil
+}
+
+func (q *sqsQueue) receive(ctx context.Context) (*delivery, error) {
+	for {
+		res, err := q.sqs.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(q.in),
+			MaxNumberOfMessages: 1,
+			// Long polling, with the longest wait allowed.
+			WaitTimeSeconds:   20,
+			VisibilityTimeout: int32(q.visibility.Seconds()),
+			MessageSystemAttributeNames: []sqstypes.MessageSystemAttributeName{
+				sqstypes.MessageSystemAttributeNameApproximateReceiveCount,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Messages) == 0 {
+			continue
+		}
+		return q.delivery(&res.Messages[0]), nil
+	}
+}
+
+func (q *sqsQueue) delivery(msg *sqstypes.Message) *delivery {
+	// Extend the visibility of msg until it is settled. SQS refuses to
+	// extend it beyond 12 hours, which ends the loop otherwise.
+	ctx, settle := context.WithCancel(context.Background())
+	go func() {
+		t := time.NewTicker(q.visibility / 2)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			if err := q.setVisibility(ctx, msg, q.visibility); err != nil {
+				if ctx.Err() == nil {
+					logger.Warn("unable to extend message visibility", "message", aws.ToString(msg.MessageId), "error", err)
+				}
+				return
+			}
+		}
+	}()
+
+	reply := func(ctx context.Context, m *queryMessage, res []byte) error {
+		settle()
+		if err := q.send(ctx, m, res); err != nil {
+			// Deliver the message again without waiting for the
+			// visibility timeout.
+			q.setVisibility(ctx, msg, 0)
+			return err
+		}
+		_, err := q.sqs.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(q.in),
+			ReceiptHandle: msg.ReceiptHandle,
+		})
+		return err
+	}
+	return &delivery{
+		body:  []byte(aws.ToString(msg.Body)),
+		reply: reply,
+		fail: func(ctx context.Context, m *queryMessage, res []byte, err error) error {
+			n, _ := strconv.Atoi(msg.Attributes[string(sqstypes.MessageSystemAttributeNameApproximateReceiveCount)])
+			if !permanent(err) && n <= 1 {
+				// Try again once the visibility timeout expires, or
+				// move to the dead-letter queue of the redrive policy
+				// of the queue, if any.
+				settle()
+				return nil
+			}
+			return reply(ctx, m, res)
+		},
+	}
+}
+
+func (q *sqsQueue) setVisibility(ctx context.Context, msg *sqstypes.Message, d time.Duration) error {
+	_, err := q.sqs.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.in),
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: int32(d.Seconds()),
+	})
+	return err
+}
+
+// send publishes res to the destination of m if any, or else to the
+// output queue or topic.
+func (q *sqsQueue) send(ctx context.Context, m *queryMessage, res []byte) error {
+	dest := q.out
+	if m.ReplyTo != "" {
+		dest = m.ReplyTo
+	}
+	if isTopic(dest) {
+		_, err := q.sns.Publish(ctx, &sns.PublishInput{
+			TopicArn: aws.String(dest),
+			Message:  aws.String(string(res)),
+		})
+		return err
+	}
+	u, err := q.queueURL(ctx, dest)
+	if err != nil {
+		return err
+	}
+	_, err = q.sqs.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(u),
+		MessageBody: aws.String(string(res)),
+	})
+	return err
+}
+
+func (q *sqsQueue) close() error {
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/discursive-image/dic"
+	"github.com/discursive-image/dic/google"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// stages are the stages the terms go through before they are served
+// from the cache or searched, in order: normalized, corrected, retried
+// with their synonyms if they have no results, and observed. Their
+// requests hold an *ImageRequest as Value.
+var stages = []dic.Stage{
+	recovered,
+	dic.Normalize(normalizeQuery),
+	correct,
+	fallback,
+	observe,
+}
+
+// detached completes the lookups of the records of a batch even once
+// it is canceled, within 5 seconds, only inheriting the span of their
+// record.
+func detached(next dic.Handler) dic.Handler {
+	return func(ctx context.Context, q *dic.Request) ([]*google.ISR, error) {
+		r := q.Value.(*ImageRequest)
+		ctx, cancel := context.WithTimeout(trace.ContextWithSpan(context.Background(), r.span), time.Second*5)
+		defer cancel()
+		return next(ctx, q)
+	}
+}
+
+// recovered fails the lookups that panic, reporting it, instead of
+// killing the whole run: a panic is a bug.
+func recovered(next dic.Handler) dic.Handler {
+	return func(ctx context.Context, q *dic.Request) (items []*google.ISR, err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				items, err = nil, fmt.Errorf("panic: %v", v)
+				logger.Error("record processing panicked", "query", q.Term, "error", err, "stack", string(debug.Stack()))
+				reportError(err, false, map[string]string{"query": q.Term})
+			}
+		}()
+		return next(ctx, q)
+	}
+}
+
+// correct corrects the typos of the keys with spellChecker, if set,
+// recording the correction, empty if none, as a field of the record.
+func correct(next dic.Handler) dic.Handler {
+	return func(ctx context.Context, q *dic.Request) ([]*google.ISR, error) {
+		var correction string
+		if c := correctQuery(q.Key); c != q.Key {
+			logger.Debug("query corrected", "query", q.Key, "correction", c)
+			correction, q.Key = c, c
+		}
+		if spellChecker != nil {
+			q.Fields = append(q.Fields, correction)
+		}
+		return next(ctx, q)
+	}
+}
+
+// fallback retries the keys without results with their synonyms, as
+// told by synonymSource if set, recording the one found, empty if none,
+// as a field of the record.
+func fallback(next dic.Handler) dic.Handler {
+	return func(ctx context.Context, q *dic.Request) ([]*google.ISR, error) {
+		items, err := next(ctx, q)
+		if synonymSource == nil {
+			return items, err
+		}
+		var variant string
+		if k := q.Key; errors.Is(err, errNoResults) {
+			for _, syn := range synonymSource.synonyms(ctx, k) {
+				q.Key = syn
+				sitems, serr := next(ctx, q)
+				if serr == nil {
+					logger.Debug("image found for synonym", "query", k, "synonym", syn)
+					variant, items, err = syn, sitems, nil
+					break
+				}
+				if !errors.Is(serr, errNoResults) && !errors.Is(serr, errDeadLinks) {
+					items, err = nil, serr
+					break
+				}
+			}
+			if variant == "" {
+				q.Key = k
+			}
+		}
+		q.Fields = append(q.Fields, variant)
+		return items, err
+	}
+}
+
+// observe accounts for the lookups of the keys in the cache, journaling
+// the searches they issue.
+func observe(next dic.Handler) dic.Handler {
+	return func(ctx context.Context, q *dic.Request) ([]*google.ISR, error) {
+		r := q.Value.(*ImageRequest)
+		ctx, cspan := tracer.Start(ctx, "cache.lookup")
+		q.Searched, q.Results = false, nil
+		items, err := next(ctx, q)
+		endSpan(cspan, nil, attribute.Bool("cache.hit", !q.Searched))
+		observeCache(!q.Searched)
+		if !q.Searched {
+			r.hit = true
+			return items, err
+		}
+		switch {
+		case errors.Is(err, errNoResults), errors.Is(err, errDeadLinks):
+			journalCall(r.journal, q.Term, q.Key, q.Results, "", nil)
+		case err != nil:
+			journalCall(r.journal, q.Term, q.Key, nil, "", err)
+		default:
+			journalCall(r.journal, q.Term, q.Key, q.Results, items[0].Link, nil)
+		}
+		return items, err
+	}
+}
+
+// search searches the keys with the client of their request, through
+// its gate if set. Returns errNoResults if there are none.
+func search(ctx context.Context, q *dic.Request) ([]*google.ISR, error) {
+	r := q.Value.(*ImageRequest)
+	k := q.Key
+	logger.Debug("searching", "query", k, "provider", providerGoogle)
+	r.searched = true
+	sctx, sspan := tracer.Start(ctx, "search", trace.WithAttributes(
+		attribute.String("provider", providerGoogle),
+		attribute.String("query", k),
+	))
+	search := func(ctx context.Context) ([]*google.ISR, error) {
+		if r.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, r.timeout)
+			defer cancel()
+		}
+		text, err := searchText(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		opts := r.opts
+		if lang := termLanguage(ctx, k); lang != "" {
+			opts = append(slices.Clip(opts), google.FilterLanguage(lang))
+		}
+		start := time.Now()
+		defer func() { r.latency = time.Since(start) }()
+		return r.gsc.SearchImages(ctx, text, opts...)
+	}
+	var items []*google.ISR
+	var err error
+	if r.gate != nil {
+		var shared bool
+		items, shared, err = r.gate.search(sctx, k, r.opts, search)
+		// Shared results did not cost a search.
+		r.searched, r.hit = !shared, shared
+	} else {
+		items, err = search(sctx)
+	}
+	endSpan(sspan, err, attribute.Int("results", len(items)), attribute.Bool("shared", r.hit))
+	if r.searched {
+		observeSearch(providerGoogle, r, err)
+		observeAuth(r.gsc, err)
+	}
+	if err == nil && len(items) == 0 {
+		err = errNoResults
+	}
+	return items, err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/discursive-image/dic"
+	"github.com/discursive-image/dic/google"
+)
+
+func TestFallback(t *testing.T) {
+	synonyms := map[string][]string{
+		"kitty":  {"cat", "feline"},
+		"hound":  {"mutt", "dog"},
+		"pooch":  {"mutt"},
+		"broken": {"error", "cat"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res []map[string]string
+		for _, syn := range synonyms[r.URL.Query().Get("rel_syn")] {
+			res = append(res, map[string]string{"word": syn})
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer srv.Close()
+	t.Cleanup(func() { synonymSource = nil })
+
+	errSearch := errors.New("search failed")
+	var searched []string
+	next := func(ctx context.Context, q *dic.Request) ([]*google.ISR, error) {
+		searched = append(searched, q.Key)
+		switch q.Key {
+		case "cat", "dog":
+			return []*google.ISR{{Link: "http://" + q.Key}}, nil
+		case "mutt":
+			return nil, errDeadLinks
+		case "error":
+			return nil, errSearch
+		}
+		return nil, errNoResults
+	}
+
+	q := &dic.Request{Key: "kitty"}
+	if _, err := fallback(next)(context.Background(), q); !errors.Is(err, errNoResults) || len(q.Fields) != 0 {
+		t.Errorf("without synonyms: got %v, fields %q", err, q.Fields)
+	}
+
+	synonymSource = newThesaurus(srv.URL, 5)
+	for _, tt := range []struct {
+		key      string
+		want     string // link found.
+		err      error
+		variant  string
+		searched []string
+	}{
+		{"cat", "http://cat", nil, "", []string{"cat"}},
+		{"kitty", "http://cat", nil, "cat", []string{"kitty", "cat"}},
+		{"hound", "http://dog", nil, "dog", []string{"hound", "mutt", "dog"}},
+		{"pooch", "", errNoResults, "", []string{"pooch", "mutt"}},
+		{"broken", "", errSearch, "", []string{"broken", "error"}},
+		{"unknown", "", errNoResults, "", []string{"unknown"}},
+	} {
+		searched = nil
+		q := &dic.Request{Term: tt.key, Key: tt.key}
+		items, err := fallback(next)(context.Background(), q)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%q: got %v, want %v", tt.key, err, tt.err)
+		}
+		if tt.err == nil && (len(items) == 0 || items[0].Link != tt.want) {
+			t.Errorf("%q: got %v, want %s", tt.key, items, tt.want)
+		}
+		if len(q.Fields) != 1 || q.Fields[0] != tt.variant {
+			t.Errorf("%q: got fields %q, want variant %q", tt.key, q.Fields, tt.variant)
+		}
+		wantKey := tt.key
+		if tt.variant != "" {
+			wantKey = tt.variant
+		}
+		if q.Key != wantKey {
+			t.Errorf("%q: got key %q, want %q", tt.key, q.Key, wantKey)
+		}
+		if !slices.Equal(searched, tt.searched) {
+			t.Errorf("%q: searched %q, want %q", tt.key, searched, tt.searched)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// summary accounts for the outcome of a batch run.
+type summary struct {
+	Rows      int       `json:"rows"`
+	Succeeded int       `json:"succeeded"`
+	Failed    int       `json:"failed"`
+	CacheHits int       `json:"cache_hits"`
+	APICalls  int       `json:"api_calls"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	// Elapsed is expressed in seconds.
+	Elapsed float64 `json:"elapsed"`
+	// Canceled is true when the run was aborted by a signal.
+	Canceled bool `json:"canceled"`
+	// Error is the fatal error that aborted the run, if any.
+	Error string `json:"error,omitempty"`
+	// RowErrors counts the records that failed, by error message.
+	RowErrors map[string]int `json:"row_errors,omitempty"`
+}
+
+// exitCode returns the process exit code matching the outcome of
+// the run.
+func (s *summary) exitCode() int {
+	switch {
+	case s.Error != "":
+		return exitFatal
+	case s.Canceled:
+		return exitCanceled
+	case s.Failed > 0:
+		return exitRowErrors
+	default:
+		return exitOK
+	}
+}
+
+func newSummary() *summary {
+	return &summary{
+		Start: time.Now(),
+	}
+}
+
+// record accounts for a processed request.
+func (s *summary) record(r *ImageRequest) {
+	s.Rows++
+	if r.err != nil {
+		s.Failed++
+		if s.RowErrors == nil {
+			s.RowErrors = make(map[string]int)
+		}
+		s.RowErrors[r.err.Error()]++
+	} else {
+		s.Succeeded++
+	}
+	if r.hit {
+		s.CacheHits++
+	}
+	if r.searched {
+		s.APICalls++
+	}
+}
+
+// close marks the end of the run.
+func (s *summary) close() {
+	s.End = time.Now()
+	s.Elapsed = s.End.Sub(s.Start).Seconds()
+}
+
+func (s *summary) print(w io.Writer) {
+	fmt.Fprintf(w, "rows:       %d\n", s.Rows)
+	fmt.Fprintf(w, "succeeded:  %d\n", s.Succeeded)
+	fmt.Fprintf(w, "failed:     %d\n", s.Failed)
+	fmt.Fprintf(w, "cache hits: %d\n", s.CacheHits)
+	fmt.Fprintf(w, "api calls:  %d\n", s.APICalls)
+	fmt.Fprintf(w, "elapsed:    %v\n", s.End.Sub(s.Start).Truncate(time.Millisecond))
+	if s.Canceled {
+		fmt.Fprintf(w, "canceled:   true\n")
+	}
+	if s.Error != "" {
+		fmt.Fprintf(w, "error:      %s\n", s.Error)
+	}
+	if len(s.RowErrors) > 0 {
+		fmt.Fprintf(w, "row errors:\n")
+		msgs := make([]string, 0, len(s.RowErrors))
+		for k := range s.RowErrors {
+			msgs = append(msgs, k)
+		}
+		sort.Slice(msgs, func(i, j int) bool {
+			if a, b := s.RowErrors[msgs[i]], s.RowErrors[msgs[j]]; a != b {
+				return a > b
+			}
+			return msgs[i] < msgs[j]
+		})
+		for _, k := range msgs {
+			fmt.Fprintf(w, "  %6d %s\n", s.RowErrors[k], k)
+		}
+	}
+}
+
+// writeFile stores the summary as JSON at path.
+func (s *summary) writeFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create summary file: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to encode summary: %w", err)
+	}
+	return f.Close()
+}
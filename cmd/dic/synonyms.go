@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// datamuseURL is the words endpoint of the Datamuse API, a free
+// thesaurus built on WordNet among others.
+const datamuseURL = "https://api.datamuse.com/words"
+
+// synonymSource, if set, provides the synonyms searched for the terms
+// without results.
+var synonymSource *thesaurus
+
+// thesaurus looks up the synonyms of terms with a Datamuse compatible
+// API.
+type thesaurus struct {
+	endpoint string
+	// max is the number of synonyms returned.
+	max    int
+	client *http.Client
+}
+
+func newThesaurus(endpoint string, max int) *thesaurus {
+	return &thesaurus{
+		endpoint: endpoint,
+		max:      max,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// synonyms returns the synonyms of term, the closest first, none if
+// they cannot be looked up.
+func (t *thesaurus) synonyms(ctx context.Context, term string) []string {
+	words, err := t.lookup(ctx, term)
+	if err != nil {
+		logger.Warn("unable to look up synonyms", "query", term, "error", err)
+		return nil
+	}
+	logger.Debug("synonyms found", "query", term, "synonyms", words)
+	return words
+}
+
+func (t *thesaurus) lookup(ctx context.Context, term string) ([]string, error) {
+	v := url.Values{}
+	v.Set("rel_syn", term)
+	v.Set("max", strconv.Itoa(t.max))
+	req, err := http.NewRequestWithContext(ctx, "GET", t.endpoint+"?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var res []struct {
+		Word string `json:"word"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("unable to decode response: %w", err)
+	}
+	words := make([]string, 0, len(res))
+	for _, r := range res {
+		if r.Word != "" && r.Word != term && len(words) < t.max {
+			words = append(words, r.Word)
+		}
+	}
+	return words, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// thumbnailer resizes images to thumbnails fitting in width x height,
+// images smaller than that being kept as they are, encoded as JPEG or
+// PNG.
+type thumbnailer struct {
+	dir           string
+	width, height int
+	format        string
+	quality       int // of JPEG thumbnails.
+}
+
+// parseSize parses a size such as 256x256.
+func parseSize(s string) (int, int, error) {
+	ws, hs, ok := strings.Cut(s, "x")
+	w, werr := strconv.Atoi(ws)
+	h, herr := strconv.Atoi(hs)
+	if !ok || werr != nil || herr != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid size %q, expected <width>x<height> such as 256x256", s)
+	}
+	return w, h, nil
+}
+
+// fit returns the size of an image of size w x h scaled down to fit in
+// maxw x maxh, keeping its aspect ratio.
+func fit(w, h, maxw, maxh int) (int, int) {
+	if w <= maxw && h <= maxh {
+		return w, h
+	}
+	if w*maxh > h*maxw {
+		return maxw, max(1, h*maxw/w)
+	}
+	return max(1, w*maxh/h), maxh
+}
+
+// make writes the thumbnail of the image at path, called name, and
+// returns its path. Thumbnails more recent than their image are kept.
+func (t *thumbnailer) make(path, name string) (string, error) {
+	out := filepath.Join(t.dir, name+imageFormatExt(t.format))
+	if fi, err := os.Stat(out); err == nil {
+		if ii, err := os.Stat(path); err == nil && !fi.ModTime().Before(ii.ModTime()) {
+			return out, nil
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return "", fmt.Errorf("unable to decode image: %w", err)
+	}
+	b := src.Bounds()
+	w, h := fit(b.Dx(), b.Dy(), t.width, t.height)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	op := draw.Src
+	if t.format != "png" {
+		// JPEG has no transparency, drawn over white instead of black.
+		draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+		op = draw.Over
+	}
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, op, nil)
+
+	if err := writeImage(out, dst, t.format, t.quality); err != nil {
+		return "", fmt.Errorf("unable to write thumbnail: %w", err)
+	}
+	return out, nil
+}
+
+// imageFormatExt returns the extension of the images of format, jpeg or
+// png.
+func imageFormatExt(format string) string {
+	if format == "png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// writeImage encodes img to path as format, jpeg with quality or png.
+// The image is written to a temporary file first, so that only complete
+// images are found in its directory.
+func writeImage(path string, img image.Image, format string, quality int) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if format == "png" {
+		err = png.Encode(tmp, img)
+	} else {
+		err = jpeg.Encode(tmp, img, &jpeg.Options{Quality: quality})
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	os.Chmod(tmp.Name(), 0o644)
+	return os.Rename(tmp.Name(), path)
+}
+
+// converter converts images to format, jpeg with quality or png,
+// dropping their metadata.
+type converter struct {
+	format  string
+	quality int
+}
+
+// convert writes the image at src to dst.
+func (c *converter) convert(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("unable to decode image: %w", err)
+	}
+	if c.format != "png" {
+		// JPEG has no transparency, drawn over white instead of black.
+		b := img.Bounds()
+		flat := image.NewRGBA(b)
+		draw.Draw(flat, b, image.White, image.Point{}, draw.Src)
+		draw.Draw(flat, b, img, b.Min, draw.Over)
+		img = flat
+	}
+	if err := writeImage(dst, img, c.format, c.quality); err != nil {
+		return fmt.Errorf("unable to write converted image: %w", err)
+	}
+	return nil
+}
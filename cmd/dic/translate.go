@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/discursive-image/dic/google"
+)
+
+// queryTranslator, if set, translates the terms before they are
+// searched, their results remaining cached by term.
+var queryTranslator *termTranslator
+
+// termTranslator translates terms with the Cloud Translation API,
+// remembering the translations of the run.
+type termTranslator struct {
+	tc     *google.Translate
+	source string // detected if empty.
+	target string
+
+	mu   sync.Mutex
+	done map[string]string
+}
+
+func newTermTranslator(tc *google.Translate, source, target string) *termTranslator {
+	return &termTranslator{
+		tc:     tc,
+		source: source,
+		target: target,
+		done:   make(map[string]string),
+	}
+}
+
+// translate returns the translation of term to t.target.
+func (t *termTranslator) translate(ctx context.Context, term string) (string, error) {
+	t.mu.Lock()
+	s, ok := t.done[term]
+	t.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+	s, err := t.tc.Text(ctx, term, t.source, t.target)
+	if err != nil {
+		return "", fmt.Errorf("unable to translate query: %w", err)
+	}
+	logger.Debug("query translated", "query", term, "translation", s, "language", t.target)
+	t.mu.Lock()
+	t.done[term] = s
+	t.mu.Unlock()
+	return s, nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// uiPage is the web page used to enrich csv files from a browser.
+//
+//go:embed ui/index.html
+var uiPage []byte
+
+// handleUI serves the web page. It is public: the page asks for the
+// api key, if needed, and passes it to the other endpoints.
+func handleUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiPage)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// imageUploader uploads images to a bucket or a server.
+type imageUploader interface {
+	// upload uploads the image at p found for query, and returns the
+	// URL of its object.
+	upload(ctx context.Context, p, query string) (string, error)
+}
+
+// uploadOptions are the options of the uploads. gcsPublic is set to
+// make the objects uploaded to Google Cloud Storage readable by anyone,
+// and gcsSigned and azureSAS to return URLs signed for that long
+// instead of their plain URL.
+type uploadOptions struct {
+	gcsPublic bool
+	gcsSigned time.Duration
+	azureSAS  time.Duration
+}
+
+// newImageUploader returns the uploader of the images to dst, an
+// s3://<bucket>/<prefix>, gs://<bucket>/<prefix>,
+// azblob://<container>/<prefix>, sftp://[<user>@]<host>/<dir>,
+// webdav://<host>/<dir> or webdavs://<host>/<dir> URL.
+func newImageUploader(ctx context.Context, dst string, opts uploadOptions) (imageUploader, error) {
+	scheme, rest, _ := strings.Cut(dst, "://")
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	date := time.Now().UTC().Format(time.DateOnly)
+	switch {
+	case bucket == "":
+	case scheme == "sftp" || scheme == "webdav" || scheme == "webdavs":
+		u, err := url.Parse(dst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload url: %w", err)
+		}
+		bp := bucketPrefix{prefix: strings.TrimPrefix(u.Path, "/"), date: date}
+		if scheme == "sftp" {
+			return newSFTPUploader(u, bp)
+		}
+		return newWebDAVUploader(u, bp), nil
+	case scheme == "s3" || scheme == "gs" || scheme == "azblob":
+		return newBucketUploader(ctx, scheme, bucketPrefix{bucket: bucket, prefix: prefix, date: date}, opts)
+	}
+	return nil, fmt.Errorf("invalid upload url %q, expected s3://<bucket>/<prefix>, gs://<bucket>/<prefix>, azblob://<container>/<prefix>, sftp://<host>/<dir> or webdav(s)://<host>/<dir>", dst)
+}
+
+func newBucketUploader(ctx context.Context, scheme string, bp bucketPrefix, opts uploadOptions) (imageUploader, error) {
+	switch scheme {
+	case "gs":
+		c, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create storage client: %w", err)
+		}
+		return &gcsUploader{c: c, bucketPrefix: bp, public: opts.gcsPublic, signed: opts.gcsSigned}, nil
+	case "azblob":
+		c, sharedKey, err := newAzureClient()
+		if err != nil {
+			return nil, err
+		}
+		return &azureUploader{c: c, sharedKey: sharedKey, bucketPrefix: bp, sas: opts.azureSAS}, nil
+	}
+	c, err := newS3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Uploader{up: manager.NewUploader(c), bucketPrefix: bp}, nil
+}
+
+// bucketPrefix is the prefix in a bucket, or the directory of a server,
+// under which images are uploaded, in which {query} is replaced with the slug of their query
+// and {date} with the date of the run.
+type bucketPrefix struct {
+	bucket string
+	prefix string
+	date   string
+}
+
+// key returns the key of the image at p found for query.
+func (bp *bucketPrefix) key(p, query string) string {
+	q := slug(query)
+	if q == "" {
+		q = "_"
+	}
+	prefix := strings.NewReplacer("{query}", q, "{date}", bp.date).Replace(bp.prefix)
+	return path.Join(prefix, filepath.Base(p))
+}
+
+// s3Uploader uploads images to an S3 bucket.
+type s3Uploader struct {
+	up *manager.Uploader
+	bucketPrefix
+}
+
+func (u *s3Uploader) upload(ctx context.Context, p, query string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(u.bucket),
+		Key:    aws.String(u.key(p, query)),
+		Body:   f,
+	}
+	if ct := mime.TypeByExtension(filepath.Ext(p)); ct != "" {
+		in.ContentType = aws.String(ct)
+	}
+	out, err := u.up.Upload(ctx, in)
+	if err != nil {
+		return "", fmt.Errorf("unable to upload image: %w", err)
+	}
+	return out.Location, nil
+}
+
+// gcsUploader uploads images to a Google Cloud Storage bucket.
+type gcsUploader struct {
+	c *storage.Client
+	bucketPrefix
+	public bool
+	signed time.Duration
+}
+
+func (u *gcsUploader) upload(ctx context.Context, p, query string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	// Canceling the context aborts the upload.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	key := u.key(p, query)
+	w := u.c.Bucket(u.bucket).Object(key).NewWriter(ctx)
+	w.ContentType = mime.TypeByExtension(filepath.Ext(p))
+	if u.public {
+		// Rejected by buckets with uniform access, made public as a
+		// whole instead.
+		w.PredefinedACL = "publicRead"
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return "", fmt.Errorf("unable to upload image: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("unable to upload image: %w", err)
+	}
+	if u.signed > 0 {
+		link, err := u.c.Bucket(u.bucket).SignedURL(key, &storage.SignedURLOptions{
+			Method:  "GET",
+			Expires: time.Now().Add(u.signed),
+			Scheme:  storage.SigningSchemeV4,
+		})
+		if err != nil {
+			return "", fmt.Errorf("unable to sign image url: %w", err)
+		}
+		return link, nil
+	}
+	return (&url.URL{Scheme: "https", Host: "storage.googleapis.com", Path: "/" + u.bucket + "/" + key}).String(), nil
+}
+
+// newAzureClient returns a client of the Blob Storage account set by the
+// AZURE_STORAGE_CONNECTION_STRING environment variable or else by
+// AZURE_STORAGE_ACCOUNT, authenticated with AZURE_STORAGE_KEY if set, or
+// with the default Azure credential chain: environment variables,
+// workload or managed identity, then the Azure CLI. sharedKey reports
+// whether it is authenticated with a key.
+func newAzureClient() (c *service.Client, sharedKey bool, err error) {
+	if cs := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); cs != "" {
+		c, err := service.NewClientFromConnectionString(cs, nil)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid azure storage connection string: %w", err)
+		}
+		return c, true, nil
+	}
+	account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	if account == "" {
+		return nil, false, fmt.Errorf("azure storage account missing, set AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_CONNECTION_STRING")
+	}
+	serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net/", account)
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		cred, err := service.NewSharedKeyCredential(account, key)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid azure storage key: %w", err)
+		}
+		c, err := service.NewClientWithSharedKeyCredential(serviceURL, cred, nil)
+		return c, true, err
+	}
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to load azure credentials: %w", err)
+	}
+	c, err = service.NewClient(serviceURL, cred, nil)
+	return c, false, err
+}
+
+// azureUploader uploads images to an Azure Blob Storage container.
+type azureUploader struct {
+	c         *service.Client
+	sharedKey bool
+	bucketPrefix
+	sas time.Duration
+
+	// udc signs the SAS of the images if not authenticated with a key,
+	// and is valid until udcExpiry.
+	mu        sync.Mutex
+	udc       *service.UserDelegationCredential
+	udcExpiry time.Time
+}
+
+func (u *azureUploader) upload(ctx context.Context, p, query string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	key := u.key(p, query)
+	bb := u.c.NewContainerClient(u.bucket).NewBlockBlobClient(key)
+	opts := &blockblob.UploadFileOptions{}
+	if ct := mime.TypeByExtension(filepath.Ext(p)); ct != "" {
+		opts.HTTPHeaders = &blob.HTTPHeaders{BlobContentType: &ct}
+	}
+	if _, err := bb.UploadFile(ctx, f, opts); err != nil {
+		return "", fmt.Errorf("unable to upload image: %w", err)
+	}
+	if u.sas <= 0 {
+		return bb.URL(), nil
+	}
+	expiry := time.Now().Add(u.sas)
+	if u.sharedKey {
+		link, err := bb.GetSASURL(sas.BlobPermissions{Read: true}, expiry, nil)
+		if err != nil {
+			return "", fmt.Errorf("unable to sign image url: %w", err)
+		}
+		return link, nil
+	}
+	udc, err := u.userDelegationCredential(ctx, expiry)
+	if err != nil {
+		return "", fmt.Errorf("unable to sign image url: %w", err)
+	}
+	qp, err := sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPS,
+		ExpiryTime:    expiry,
+		Permissions:   (&sas.BlobPermissions{Read: true}).String(),
+		ContainerName: u.bucket,
+		BlobName:      key,
+	}.SignWithUserDelegation(udc)
+	if err != nil {
+		return "", fmt.Errorf("unable to sign image url: %w", err)
+	}
+	return bb.URL() + "?" + qp.Encode(), nil
+}
+
+// userDelegationCredential returns a credential signing SAS valid until
+// expiry, requested once per run unless expiring before.
+func (u *azureUploader) userDelegationCredential(ctx context.Context, expiry time.Time) (*service.UserDelegationCredential, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.udc != nil && !u.udcExpiry.Before(expiry) {
+		return u.udc, nil
+	}
+	// Valid for a bit longer than the SAS signed now, to be reused
+	// by the following images.
+	start, end := time.Now().UTC(), expiry.UTC().Add(time.Hour)
+	startStr, endStr := start.Format(sas.TimeFormat), end.Format(sas.TimeFormat)
+	udc, err := u.c.GetUserDelegationCredential(ctx, service.KeyInfo{Start: &startStr, Expiry: &endStr}, nil)
+	if err != nil {
+		return nil, err
+	}
+	u.udc, u.udcExpiry = udc, end
+	return udc, nil
+}
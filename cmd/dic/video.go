@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// captionFont is the font of the captions burned in videos.
+var captionFont = func() *opentype.Font {
+	f, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}()
+
+// videoFrame returns the frame of size w x h showing the image at path
+// fitted above its caption.
+func videoFrame(path, caption string, w, h int) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode image: %w", err)
+	}
+	frame := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(frame, frame.Bounds(), image.Black, image.Point{}, draw.Src)
+
+	band := h / 6
+	if caption == "" {
+		band = 0
+	}
+	b := src.Bounds()
+	// Scaled up as well as down, to fill the frame.
+	iw, ih := w, b.Dy()*w/b.Dx()
+	if ih > h-band {
+		iw, ih = b.Dx()*(h-band)/b.Dy(), h-band
+	}
+	x, y := (w-iw)/2, (h-band-ih)/2
+	draw.CatmullRom.Scale(frame, image.Rect(x, y, x+iw, y+ih), src, b, draw.Over, nil)
+	if band > 0 {
+		if err := drawCaption(frame, caption, image.Rect(0, h-band, w, h)); err != nil {
+			return nil, err
+		}
+	}
+	return frame, nil
+}
+
+// drawCaption draws caption in white centered in r, shrunk to fit its
+// width if needed.
+func drawCaption(dst draw.Image, caption string, r image.Rectangle) error {
+	size := float64(r.Dy()) * 0.5
+	for {
+		face, err := opentype.NewFace(captionFont, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return err
+		}
+		d := &font.Drawer{Dst: dst, Src: image.NewUniform(color.White), Face: face}
+		width := d.MeasureString(caption).Ceil()
+		if width > r.Dx()*9/10 && size > 8 {
+			face.Close()
+			size *= 0.9
+			continue
+		}
+		m := face.Metrics()
+		baseline := r.Min.Y + (r.Dy()+m.Ascent.Ceil()-m.Descent.Ceil())/2
+		d.Dot = fixed.P(r.Min.X+(r.Dx()-width)/2, baseline)
+		d.DrawString(caption)
+		return face.Close()
+	}
+}
+
+func runVideo(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("video", flag.ExitOnError)
+	o := registerOptions(fs)
+	i := fs.String("i", "-", "Input file containing the records of the images, such as a download output. csv encoded, use - for stdin.")
+	out := fs.String("o", "slides.mp4", "MP4 file where the video is written.")
+	c := fs.Int("c", 3, "Selects the column holding the caption burned in the images, such as their query. Negative values disable the captions.")
+	p := fs.Int("p", -1, "Selects the column holding the path of the images. Negative values count from the last column, -1.")
+	duration := fs.Duration("duration", 5*time.Second, "Time each image is shown.")
+	size := fs.String("size", "1920x1080", "Size of the video, as <width>x<height>.")
+	fps := fs.Int("fps", 25, "Frame rate of the video.")
+	ffmpeg := fs.String("ffmpeg", "ffmpeg", "Path of the ffmpeg executable, which renders the video.")
+	fs.Usage = usageFor(fs, "video [flags]", `Renders an MP4 slideshow of the images of a csv input, such as the output of
+download, each shown for the same time with its caption burned in, with ffmpeg.`)
+	fs.Parse(args)
+	o.load(fs)
+
+	w, h, err := parseSize(*size)
+	switch {
+	case err != nil:
+		exitf(err.Error())
+	case w%2 != 0 || h%2 != 0:
+		exitf("video size must be even, got %s", *size)
+	case *duration <= 0:
+		exitf("duration must be positive, got %v", *duration)
+	case *fps <= 0:
+		exitf("frame rate must be positive, got %d", *fps)
+	}
+	r, err := openInputFile(*i)
+	if err != nil {
+		exitf(err.Error())
+	}
+	defer r.Close()
+
+	// Each frame piped is shown for duration, resampled to fps.
+	cmd := exec.CommandContext(ctx, *ffmpeg,
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-f", "image2pipe", "-c:v", "png",
+		"-framerate", strconv.FormatFloat(1/duration.Seconds(), 'g', -1, 64),
+		"-i", "-",
+		"-c:v", "libx264", "-pix_fmt", "yuv420p", "-r", strconv.Itoa(*fps),
+		"-movflags", "+faststart",
+		"-f", "mp4", *out,
+	)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		exitf(err.Error())
+	}
+	if err := cmd.Start(); err != nil {
+		exitf("unable to start ffmpeg: %v", err)
+	}
+
+	csvr := csv.NewReader(r)
+	csvr.FieldsPerRecord = -1
+	n, failed := 0, 0
+	var werr error
+	for row := 1; ctx.Err() == nil && werr == nil; row++ {
+		rec, err := csvr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			werr = fmt.Errorf("unable to read input: %w", err)
+			break
+		}
+		pi := *p
+		if pi < 0 {
+			pi += len(rec)
+		}
+		if pi < 0 || pi >= len(rec) || *c >= len(rec) {
+			logger.Error("unable to add image", "row", row, "error", fmt.Errorf("tried to access column %d or %d out of %d", *p, *c, len(rec)))
+			failed++
+			continue
+		}
+		var caption string
+		if *c >= 0 {
+			caption = rec[*c]
+		}
+		frame, err := videoFrame(rec[pi], caption, w, h)
+		if err != nil {
+			logger.Error("unable to add image", "row", row, "path", rec[pi], "error", err)
+			failed++
+			continue
+		}
+		if err := png.Encode(stdin, frame); err != nil {
+			werr = fmt.Errorf("unable to write to ffmpeg: %w", err)
+			break
+		}
+		n++
+	}
+	stdin.Close()
+	if err := cmd.Wait(); err != nil && werr == nil && ctx.Err() == nil {
+		werr = fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	if ctx.Err() != nil {
+		os.Remove(*out)
+		exit(exitCanceled)
+	}
+	if werr != nil {
+		exitf(werr.Error())
+	}
+	notice("video written", "images", n, "failed", failed)
+	if failed > 0 {
+		exit(exitRowErrors)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileState is the state of a file of the watched directory, compared
+// across scans to tell whether it is still being written.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// watcher processes the files dropped in a directory.
+type watcher struct {
+	p       *pipeline
+	dir     string
+	pattern string
+	// outDir receives the outputs and their summaries, doneDir the
+	// inputs processed and failedDir the inputs that could not be,
+	// with their summaries.
+	outDir    string
+	doneDir   string
+	failedDir string
+	store     resultStore // optional.
+	sel       *selector
+	events    *eventPublisher
+
+	// seen holds the state of the files found by the previous scan.
+	seen map[string]fileState
+}
+
+// scan returns the files of the directory matching the pattern that are
+// unchanged since the previous scan, in name order.
+func (w *watcher) scan() ([]string, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read watched directory: %w", err)
+	}
+	seen := make(map[string]fileState)
+	var ready []string
+	for _, e := range entries {
+		// Hidden files are usually being copied or written.
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if ok, _ := filepath.Match(w.pattern, e.Name()); !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		st := fileState{size: info.Size(), modTime: info.ModTime()}
+		seen[e.Name()] = st
+		if prev, ok := w.seen[e.Name()]; ok && prev == st {
+			ready = append(ready, e.Name())
+		}
+	}
+	w.seen = seen
+	sort.Strings(ready)
+	return ready, nil
+}
+
+// process enriches the file called name, then moves it to the done or
+// failed directory. Files whose processing is canceled are left in
+// place, to be processed again.
+func (w *watcher) process(ctx context.Context, name string) {
+	in := filepath.Join(w.dir, name)
+	job := newJobID()
+	notice("processing file", "file", name, "job", job)
+	w.events.publish(&progressEvent{Type: "start", Job: job, Time: time.Now()})
+
+	p := *w.p
+	// As in daemon mode, the links found for the previous files are
+	// checked again.
+	p.caches = newResultCaches(w.store, w.sel)
+	sum := newSummary()
+	p.onRecord = func(r *ImageRequest) {
+		w.events.publish(newRowEvent(job, r, sum))
+	}
+	err := p.processFile(ctx, in, filepath.Join(w.outDir, name), sum)
+	sum.close()
+	w.events.publish(&progressEvent{Type: "summary", Job: job, Time: time.Now(), Summary: sum})
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	dest, sumDir := w.doneDir, w.outDir
+	if err != nil {
+		logger.Error("unable to process file", "file", name, "job", job, "error", err)
+		reportError(err, false, map[string]string{"file": name})
+		dest, sumDir = w.failedDir, w.failedDir
+	}
+	sumFile := filepath.Join(sumDir, strings.TrimSuffix(name, filepath.Ext(name))+".summary.json")
+	if err := sum.writeFile(sumFile); err != nil {
+		logger.Error("unable to write summary", "file", name, "error", err)
+	}
+	if merr := os.Rename(in, filepath.Join(dest, name)); merr != nil {
+		// Processing the file again is better than losing it.
+		logger.Error("unable to move processed file", "file", name, "error", merr)
+		return
+	}
+	if err == nil {
+		notice("file processed", "file", name, "job", job,
+			"rows", sum.Rows, "failed", sum.Failed, "api_calls", sum.APICalls)
+	}
+	delete(w.seen, name)
+}
+
+// run processes the files dropped in the directory, scanning it every
+// interval, until ctx is done.
+func (w *watcher) run(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		names, err := w.scan()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if ctx.Err() != nil {
+				return nil
+			}
+			w.process(ctx, name)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func runWatch(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	o := registerOptions(fs)
+	dir := fs.String("dir", ".", "Directory watched for new csv files.")
+	pattern := fs.String("pattern", "*.csv", "Pattern of the names of the files processed, as in filepath.Match.")
+	outDir := fs.String("out-dir", "", "Directory where the outputs are written, with the same name as their input. Defaults to the \"output\" subdirectory of \"dir\".")
+	doneDir := fs.String("done-dir", "", "Directory where the processed inputs are moved. Defaults to the \"done\" subdirectory of \"dir\".")
+	failedDir := fs.String("failed-dir", "", "Directory where the inputs that could not be processed are moved. Defaults to the \"failed\" subdirectory of \"dir\".")
+	interval := fs.Duration("interval", 5*time.Second, "Time between two scans of the watched directory. Files are processed once unchanged between two scans.")
+	c := fs.Int("c", 3, "Selects the column which will be used as word input.")
+	j := fs.Int("j", 10, "Maximum number of concurrent searches.")
+	metricsAddr := fs.String("metrics-addr", "", "Optional address where Prometheus metrics are served, at /metrics.")
+	eo := registerEventOptions(fs)
+	fs.Usage = usageFor(fs, "watch [flags]", `Processes the csv files dropped in a directory, one at a time, until
+interrupted.
+
+Files are processed once they are unchanged between two scans of the
+directory, so that those still being copied are not. Hidden files are
+ignored. Each output is written to "out-dir" with the same name as its
+input, along with the run summary, as <name>.summary.json. The input is
+then moved to "done-dir". Inputs that could not be processed, e.g. that
+are not valid csv, are moved to "failed-dir" with their summary instead.
+Records whose search failed are reported in the summary and omitted from
+the output, as in batch mode.
+
+If "events-redis-url" or "events-webhook" is set, the progress of each
+file is published as JSON events, as in batch mode.`)
+	fs.Parse(args)
+
+	cfg := o.load(fs)
+	if err := applyConfig(fs, map[string]string{
+		"dir":        cfg.Watch.Dir,
+		"pattern":    cfg.Watch.Pattern,
+		"out-dir":    cfg.Watch.OutDir,
+		"done-dir":   cfg.Watch.DoneDir,
+		"failed-dir": cfg.Watch.FailedDir,
+		"interval":   cfg.Watch.Interval.String(),
+		"c":          strconv.Itoa(cfg.Column),
+		"j":          strconv.Itoa(cfg.Concurrency),
+	}); err != nil {
+		exitf(err.Error())
+	}
+	if err := eo.applyConfig(fs, cfg); err != nil {
+		exitf(err.Error())
+	}
+	if *j <= 0 {
+		exitf("concurrency must be positive, got %d", *j)
+	}
+	if *interval <= 0 {
+		exitf("interval must be positive, got %v", *interval)
+	}
+	if _, err := filepath.Match(*pattern, ""); err != nil {
+		exitf("invalid pattern %q: %v", *pattern, err)
+	}
+
+	w := &watcher{
+		p: &pipeline{
+			gsc:     o.searchClient(),
+			c:       *c,
+			maxcc:   *j,
+			opts:    o.filters(),
+			journal: o.journalWriter(),
+		},
+		dir:     expandHome(*dir),
+		store:   o.dirStore(),
+		sel:     o.sel,
+		pattern: *pattern,
+		events:  eo.publisher(),
+	}
+	defer w.events.close()
+	for _, d := range []struct {
+		dst  *string
+		flag string
+		def  string
+	}{
+		{&w.outDir, *outDir, "output"},
+		{&w.doneDir, *doneDir, "done"},
+		{&w.failedDir, *failedDir, "failed"},
+	} {
+		*d.dst = expandHome(d.flag)
+		if d.flag == "" {
+			*d.dst = filepath.Join(w.dir, d.def)
+		}
+		if filepath.Clean(*d.dst) == filepath.Clean(w.dir) {
+			exitf("the watched directory cannot receive the processed files")
+		}
+		if err := os.MkdirAll(*d.dst, 0o755); err != nil {
+			exitf(err.Error())
+		}
+	}
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
+	}
+
+	notice("watching directory", "dir", w.dir, "pattern", w.pattern)
+	if err := w.run(ctx, *interval); err != nil {
+		exitf(err.Error())
+	}
+	notice("watch stopped")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// webdavUploader uploads images to a collection of a WebDAV server.
+type webdavUploader struct {
+	base     url.URL // of the server, without credentials.
+	user     string
+	password string
+	client   *http.Client
+	bucketPrefix
+
+	mu   sync.Mutex
+	cols map[string]bool // created, by path.
+}
+
+// newWebDAVUploader returns the uploader to the server of u, over https
+// for webdavs URLs, authenticated with its user and password, or
+// WEBDAV_PASSWORD, if any.
+func newWebDAVUploader(u *url.URL, bp bucketPrefix) *webdavUploader {
+	wu := &webdavUploader{
+		base:         url.URL{Scheme: "http", Host: u.Host},
+		user:         u.User.Username(),
+		client:       &http.Client{Timeout: downloadTimeout},
+		bucketPrefix: bp,
+		cols:         make(map[string]bool),
+	}
+	if u.Scheme == "webdavs" {
+		wu.base.Scheme = "https"
+	}
+	var ok bool
+	if wu.password, ok = u.User.Password(); !ok {
+		wu.password = os.Getenv("WEBDAV_PASSWORD")
+	}
+	return wu
+}
+
+func (u *webdavUploader) do(req *http.Request) (*http.Response, error) {
+	if u.user != "" {
+		req.SetBasicAuth(u.user, u.password)
+	}
+	return u.client.Do(req)
+}
+
+func (u *webdavUploader) url(key string) string {
+	link := u.base
+	link.Path = "/" + key
+	return link.String()
+}
+
+// mkcol creates the collection at dir and its parents, unless created
+// already.
+func (u *webdavUploader) mkcol(ctx context.Context, dir string) error {
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	u.mu.Lock()
+	done := u.cols[dir]
+	u.mu.Unlock()
+	if done {
+		return nil
+	}
+	if err := u.mkcol(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "MKCOL", u.url(dir)+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := u.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// Existing collections are reported as not allowed.
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("unable to create collection %s: unexpected status %s", dir, resp.Status)
+	}
+	u.mu.Lock()
+	u.cols[dir] = true
+	u.mu.Unlock()
+	return nil
+}
+
+func (u *webdavUploader) upload(ctx context.Context, p, query string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	key := u.key(p, query)
+	if err := u.mkcol(ctx, path.Dir(key)); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", u.url(key), f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = fi.Size()
+	if ct := mime.TypeByExtension(filepath.Ext(p)); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	resp, err := u.do(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to upload image: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("unable to upload image: unexpected status %s", resp.Status)
+	}
+	return u.url(key), nil
+}
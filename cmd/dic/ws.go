@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// rowResult is the outcome of a batch record.
+type rowResult struct {
+	Row      int    `json:"row"`
+	Query    string `json:"query"`
+	Link     string `json:"link,omitempty"`
+	CacheHit bool   `json:"cache_hit"`
+	Error    string `json:"error,omitempty"`
+}
+
+func newRowResult(r *ImageRequest) rowResult {
+	res := rowResult{
+		Row:      r.row,
+		Query:    r.query(),
+		CacheHit: r.hit,
+	}
+	if r.err != nil {
+		res.Error = r.err.Error()
+	} else {
+		res.Link = r.rec[len(r.rec)-1]
+	}
+	return res
+}
+
+// streamMessage is sent to the WebSocket clients following a job.
+// Type is either "row", for each processed record, or "done", sent
+// once with the final job status before closing the connection.
+type streamMessage struct {
+	Type string     `json:"type"`
+	Row  *rowResult `json:"row,omitempty"`
+	Job  *job       `json:"job,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+const wsWriteTimeout = 10 * time.Second
+
+func writeMessage(conn *websocket.Conn, m *streamMessage) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteMessage(websocket.TextMessage, b)
+}
+
+// handleJobStream streams the outcome of each record of a job over a
+// WebSocket, starting from the records already processed.
+func (s *server) handleJobStream(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.job(r.Context(), r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied.
+		logger.Debug("unable to upgrade connection", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	// The client is not expected to send anything: read only to
+	// notice when it goes away.
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	next := 0
+	for {
+		statusMu.Lock()
+		rows := j.rows[next:]
+		running := j.Status == jobRunning
+		updated := j.updated
+		var done []byte
+		if !running {
+			done, err = json.Marshal(&streamMessage{Type: "done", Job: j})
+		}
+		statusMu.Unlock()
+
+		for i := range rows {
+			if err := writeMessage(conn, &streamMessage{Type: "row", Row: &rows[i]}); err != nil {
+				logger.Debug("unable to stream row", "job", j.ID, "error", err)
+				return
+			}
+		}
+		next += len(rows)
+
+		if !running {
+			if err != nil {
+				logger.Error("unable to encode job", "job", j.ID, "error", err)
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			conn.WriteMessage(websocket.TextMessage, done)
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(wsWriteTimeout))
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-updated:
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// Package dic enriches records, such as the rows of a word list, with
+// the link of an image found for one of their fields, searching each
+// term once and rotating through its results across records.
+//
+// It is the core of the dic command, which adds inputs and outputs,
+// filters of the images and observability on top of it, and can be
+// embedded by other programs:
+//
+//	sc := google.NewSC(key, cx)
+//	r := csv.NewReader(os.Stdin)
+//	w := csv.NewWriter(os.Stdout)
+//	err := dic.Enrich(ctx, r, w, dic.Options{Column: 1, Searcher: sc})
+//
+// The lookup of each term goes through stages, middlewares wrapping the
+// next handler, such as Normalize, Validate, Cached and finally Search.
+// Features such as corrections or fallbacks plug in as stages of their
+// own, composed with Chain.
+package dic
@@ -0,0 +1,206 @@
+package dic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/discursive-image/dic/google"
+	"golang.org/x/sync/errgroup"
+)
+
+// Searcher searches images, such as a google.SC.
+type Searcher interface {
+	SearchImages(ctx context.Context, q string, opts ...func(url.Values)) ([]*google.ISR, error)
+}
+
+// Source provides the records enriched, returning io.EOF once done,
+// such as a csv.Reader.
+type Source interface {
+	Read() ([]string, error)
+}
+
+// Sink receives the records enriched, such as a csv.Writer, which is
+// flushed once done.
+type Sink interface {
+	Write(record []string) error
+}
+
+var (
+	// ErrNoResults is reported when a search returns no images.
+	ErrNoResults = errors.New("no results")
+	// ErrDeadLinks is reported when the results of a search all link to
+	// images that are not valid.
+	ErrDeadLinks = errors.New("no live link passing the filters among the results")
+	// ErrAborted is reported for the records read but not written, as
+	// writing the previous ones failed.
+	ErrAborted = errors.New("run aborted")
+)
+
+// Options configure Enrich.
+type Options struct {
+	// Column is the index of the field of the records searched, from 0.
+	Column int
+	// Searcher searches the images, required unless Search is set.
+	Searcher Searcher
+	// Filters restrict the searches, such as google.FilterImgType.
+	Filters []func(url.Values)
+	// Search, if set, searches the keys instead of Searcher, such as
+	// to meter the searches.
+	Search Handler
+	// Concurrency is the number of records enriched at once, 1 if not
+	// positive.
+	Concurrency int
+	// Cache holds the results, an empty one if nil. It may be shared by
+	// several runs.
+	Cache *Cache
+	// Check, if set, reports whether the image of a key may be
+	// returned, as for Validate.
+	Check func(k string, isr *google.ISR) (*google.ISR, bool)
+	// Key, if set, returns the key under which the results of a term
+	// are cached and searched, such as the term normalized.
+	Key func(term string) string
+	// Stages are called first for each record, such as to rewrite or
+	// log its lookup, before the ones normalizing its term with Key,
+	// checking, caching and searching it.
+	Stages []Stage
+	// OnRead, if set, is called with each record once read, in their
+	// order, such as to set its Value.
+	OnRead func(r *Request)
+	// OnRecord, if set, is called with each record read once enriched,
+	// in their order, and the error that prevented it, if any, before
+	// it is written. Records are only written to the sink if enriched.
+	OnRecord func(r *Request, err error)
+	// OnWrite, if set, is called once each record is written, with the
+	// error writing it, if any.
+	OnWrite func(r *Request, err error)
+}
+
+// Handler returns the handler looking up the terms as told by o,
+// through its stages.
+func (o Options) Handler() Handler {
+	cache := o.Cache
+	if cache == nil {
+		cache = &Cache{}
+	}
+	search := o.Search
+	if search == nil {
+		search = Search(o.Searcher, o.Filters...)
+	}
+	stages := append([]Stage{}, o.Stages...)
+	if o.Key != nil {
+		stages = append(stages, Normalize(o.Key))
+	}
+	stages = append(stages, Serialized())
+	if o.Check != nil {
+		stages = append(stages, Validate(cache, o.Check))
+	}
+	stages = append(stages, Cached(cache))
+	return Chain(search, stages...)
+}
+
+// Enrich reads the records of src and writes them to sink, in the same
+// order, with the link of an image found for their opts.Column field
+// appended. Each term is searched once, its results being cached and
+// returned in turn for its records. The records that cannot be enriched
+// are skipped, as reported to opts.OnRecord. Returns the error that
+// aborted the run, reading src or writing sink, or the one of ctx.
+func Enrich(ctx context.Context, src Source, sink Sink, opts Options) error {
+	if opts.Searcher == nil && opts.Search == nil {
+		return fmt.Errorf("searcher missing")
+	}
+	h := opts.Handler()
+	cc := max(opts.Concurrency, 1)
+
+	type job struct {
+		r    *Request
+		err  error
+		done chan struct{}
+	}
+	// The reader and the writer, canceled once either fails, which stops
+	// the searches as well.
+	g, gctx := errgroup.WithContext(ctx)
+	// The searches, up to cc at once.
+	var workers errgroup.Group
+	workers.SetLimit(cc)
+	// The records, in input order, up to one per search ahead of the
+	// writer.
+	jobs := make(chan *job, cc)
+	// The record read once the writer failed, not queued.
+	var left *job
+
+	g.Go(func() error {
+		defer close(jobs)
+		for row := 1; ; row++ {
+			if gctx.Err() != nil {
+				// Nil if the writer failed, reporting its error.
+				return ctx.Err()
+			}
+			rec, err := src.Read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("unable to read record: %w", err)
+			}
+			j := &job{r: &Request{Row: row, Record: rec}, done: make(chan struct{})}
+			if opts.OnRead != nil {
+				opts.OnRead(j.r)
+			}
+			workers.Go(func() error {
+				defer close(j.done)
+				j.err = Lookup(gctx, h, j.r, opts.Column)
+				return nil
+			})
+			select {
+			case jobs <- j:
+			case <-gctx.Done():
+				left = j
+				return ctx.Err()
+			}
+		}
+	})
+	g.Go(func() error {
+		for j := range jobs {
+			<-j.done
+			if opts.OnRecord != nil {
+				opts.OnRecord(j.r, j.err)
+			}
+			if j.err != nil {
+				continue
+			}
+			err := sink.Write(j.r.Record)
+			if opts.OnWrite != nil {
+				opts.OnWrite(j.r, err)
+			}
+			if err != nil {
+				return fmt.Errorf("unable to write record: %w", err)
+			}
+		}
+		if f, ok := sink.(interface {
+			Flush()
+			Error() error
+		}); ok {
+			f.Flush()
+			if err := f.Error(); err != nil {
+				return fmt.Errorf("unable to write record: %w", err)
+			}
+		}
+		return nil
+	})
+
+	err := g.Wait()
+	workers.Wait()
+	// The records left once the writer failed.
+	for j := range jobs {
+		if opts.OnRecord != nil {
+			opts.OnRecord(j.r, ErrAborted)
+		}
+	}
+	if left != nil && opts.OnRecord != nil {
+		opts.OnRecord(left.r, ErrAborted)
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+package dic
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+)
+
+type fakeSearcher struct {
+	mu       sync.Mutex
+	searched map[string]int
+}
+
+func (s *fakeSearcher) SearchImages(ctx context.Context, q string, opts ...func(url.Values)) ([]*google.ISR, error) {
+	s.mu.Lock()
+	s.searched[q]++
+	s.mu.Unlock()
+	switch q {
+	case "none":
+		return nil, nil
+	case "fail":
+		return nil, errors.New("quota exceeded")
+	}
+	return results("https://img/"+q+"/1", "https://img/"+q+"/2"), nil
+}
+
+func TestEnrich(t *testing.T) {
+	s := &fakeSearcher{searched: make(map[string]int)}
+	in := "1,cat\n2,dog\n3,none\n4,Cat\n5,fail\n6,cat\n"
+	var out bytes.Buffer
+	var failed []string
+	err := Enrich(context.Background(), csv.NewReader(strings.NewReader(in)), csv.NewWriter(&out), Options{
+		Column:   1,
+		Searcher: s,
+		Key:      strings.ToLower,
+		OnRecord: func(r *Request, err error) {
+			if err != nil {
+				failed = append(failed, r.Record[0])
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1,cat,https://img/cat/1\n2,dog,https://img/dog/1\n4,Cat,https://img/cat/2\n6,cat,https://img/cat/1\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+	if strings.Join(failed, " ") != "3 5" {
+		t.Errorf("got failed records %q, want 3 5", failed)
+	}
+	if n := s.searched["cat"]; n != 1 {
+		t.Errorf("cat searched %d times, want 1", n)
+	}
+}
+
+func TestEnrichConcurrent(t *testing.T) {
+	s := &fakeSearcher{searched: make(map[string]int)}
+	var in strings.Builder
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&in, "%d,term%d\n", i, i%5)
+	}
+	var out bytes.Buffer
+	err := Enrich(context.Background(), csv.NewReader(strings.NewReader(in.String())), csv.NewWriter(&out), Options{
+		Column:      1,
+		Searcher:    s,
+		Concurrency: 8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 50 {
+		t.Fatalf("got %d records, want 50", len(recs))
+	}
+	for i, rec := range recs {
+		if rec[0] != strconv.Itoa(i) || !strings.HasPrefix(rec[2], "https://img/"+rec[1]+"/") {
+			t.Errorf("unexpected record %d: %v", i, rec)
+		}
+	}
+	for q, n := range s.searched {
+		if n != 1 {
+			t.Errorf("%s searched %d times, want 1", q, n)
+		}
+	}
+}
+
+func TestEnrichSearcherMissing(t *testing.T) {
+	err := Enrich(context.Background(), csv.NewReader(strings.NewReader("")), csv.NewWriter(new(bytes.Buffer)), Options{})
+	if err == nil {
+		t.Fatal("got no error without searcher")
+	}
+}
+
+// countingSource returns n records, each with its own term.
+type countingSource struct {
+	read, n int
+}
+
+func (s *countingSource) Read() ([]string, error) {
+	if s.read == s.n {
+		return nil, io.EOF
+	}
+	s.read++
+	return []string{strconv.Itoa(s.read), "term" + strconv.Itoa(s.read)}, nil
+}
+
+type failingSink struct{}
+
+func (failingSink) Write(rec []string) error { return errors.New("disk full") }
+
+func TestEnrichStopsOnWriteError(t *testing.T) {
+	s := &fakeSearcher{searched: make(map[string]int)}
+	src := &countingSource{n: 1000}
+	var aborted, recorded int
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Enrich(context.Background(), src, failingSink{}, Options{
+			Column:      1,
+			Searcher:    s,
+			Concurrency: 2,
+			OnRecord: func(r *Request, err error) {
+				recorded++
+				if errors.Is(err, ErrAborted) {
+					aborted++
+				}
+			},
+		})
+	}()
+	select {
+	case err := <-errc:
+		if err == nil || !strings.Contains(err.Error(), "disk full") {
+			t.Fatalf("got error %v, want the one of the sink", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlocked after the sink failed")
+	}
+	// The records read ahead of the writer: the ones searched, queued
+	// and the one blocked on both.
+	if src.read > 10 {
+		t.Errorf("read %d records after the sink failed", src.read)
+	}
+	if len(s.searched) > 10 {
+		t.Errorf("searched %d terms after the sink failed", len(s.searched))
+	}
+	if recorded != src.read || aborted != src.read-1 {
+		t.Errorf("%d records reported, %d aborted, want %d and %d", recorded, aborted, src.read, src.read-1)
+	}
+}
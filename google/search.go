@@ -3,10 +3,13 @@ package google
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 )
 
 // SC is a google search client. Initialize it using NewSC.
@@ -17,6 +20,12 @@ type SC struct {
 	// Search context engine identifier.
 	// https://developers.google.com/custom-search/v1/cse/list
 	Cx string
+	// HTTP client used to perform the searches. If nil, a default
+	// client is used.
+	Client *http.Client
+	// Base URL of the custom search API. If empty, the google one is
+	// used; mostly useful for testing.
+	BaseURL string
 }
 
 // NewSC returns a new google search client.
@@ -71,16 +80,65 @@ func decodeISR(r io.Reader) ([]*ISR, error) {
 	return res.Items, nil
 }
 
-func decodeError(r io.Reader) error {
+// Error is an error reported by the custom search API.
+type Error struct {
+	// HTTP status code of the response.
+	StatusCode int
+	Message    string
+	// Reason of the first error reported, e.g. dailyLimitExceeded.
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Quota reports whether the error is caused by the query quota being
+// exhausted or by rate limiting.
+func (e *Error) Quota() bool {
+	switch e.Reason {
+	case "dailyLimitExceeded", "rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded":
+		return true
+	}
+	return e.StatusCode == http.StatusTooManyRequests
+}
+
+// Auth reports whether the error is caused by invalid or unauthorized
+// credentials.
+func (e *Error) Auth() bool {
+	switch e.Reason {
+	case "keyInvalid", "keyExpired", "accessNotConfigured", "forbidden":
+		return true
+	}
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return true
+	case http.StatusForbidden:
+		return !e.Quota()
+	}
+	return false
+}
+
+func decodeError(r io.Reader, status int) error {
 	var res struct {
 		Error struct {
 			Message string `json:"message"`
+			Errors  []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
 		} `json:"error"`
 	}
 	if err := json.NewDecoder(r).Decode(&res); err != nil {
 		return fmt.Errorf("unable to decode response: %w", err)
 	}
-	return fmt.Errorf(res.Error.Message)
+	e := &Error{
+		StatusCode: status,
+		Message:    res.Error.Message,
+	}
+	if len(res.Error.Errors) > 0 {
+		e.Reason = res.Error.Errors[0].Reason
+	}
+	return e
 }
 
 const (
@@ -124,8 +182,69 @@ func FilterImgSize(s string) func(url.Values) {
 	}
 }
 
+// FilterLanguage sets the interface language of the search, such as fr,
+// which favors the results in that language. Empty and undetermined
+// (und) languages are ignored.
+func FilterLanguage(s string) func(url.Values) {
+	return func(v url.Values) {
+		switch s {
+		case "", "und":
+			v.Del("hl")
+		default:
+			v.Set("hl", s)
+		}
+	}
+}
+
+// FilterDateRestrict restricts the results to the images of pages
+// published within the period s: d, w, m or y, for days, weeks, months
+// or years, followed by their number, such as w2. Invalid periods are
+// ignored.
+func FilterDateRestrict(s string) func(url.Values) {
+	return func(v url.Values) {
+		if _, err := ParseDateRestrict(s); err != nil {
+			v.Del("dateRestrict")
+			return
+		}
+		v.Set("dateRestrict", s)
+	}
+}
+
+// ParseDateRestrict returns the duration of the period s, as accepted
+// by FilterDateRestrict, counting months as 30 days and years as 365.
+func ParseDateRestrict(s string) (time.Duration, error) {
+	day := 24 * time.Hour
+	units := map[byte]time.Duration{'d': day, 'w': 7 * day, 'm': 30 * day, 'y': 365 * day}
+	if len(s) >= 2 {
+		n, err := strconv.Atoi(s[1:])
+		if unit, ok := units[s[0]]; ok && err == nil && n > 0 {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid date restriction %q, expected d, w, m or y followed by a number, such as w2", s)
+}
+
 var client = &http.Client{}
 
+// redactKey removes the API key from the URL reported by err, so that
+// errors can be logged safely.
+func redactKey(err error) error {
+	var uerr *url.Error
+	if !errors.As(err, &uerr) {
+		return err
+	}
+	u, perr := url.Parse(uerr.URL)
+	if perr != nil {
+		return err
+	}
+	v := u.Query()
+	if v.Has("key") {
+		v.Set("key", "REDACTED")
+	}
+	u.RawQuery = v.Encode()
+	return &url.Error{Op: uerr.Op, URL: u.String(), Err: uerr.Err}
+}
+
 // SearchImages searches google for images.
 func (c *SC) SearchImages(ctx context.Context, q string, opts ...func(url.Values)) ([]*ISR, error) {
 	// Validate client
@@ -144,7 +263,11 @@ func (c *SC) SearchImages(ctx context.Context, q string, opts ...func(url.Values
 	v.Set("q", q)
 	v.Set("prettyPrint", "false")
 
-	url, err := url.Parse(baseURL)
+	base := c.BaseURL
+	if base == "" {
+		base = baseURL
+	}
+	url, err := url.Parse(base)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse base url: %w", err)
 	}
@@ -157,14 +280,40 @@ func (c *SC) SearchImages(ctx context.Context, q string, opts ...func(url.Values
 	}
 
 	// Perform HTTP request.
-	resp, err := client.Do(req)
+	hc := c.Client
+	if hc == nil {
+		hc = client
+	}
+	resp, err := hc.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("unable to contact google search: %w", err)
+		return nil, fmt.Errorf("unable to contact google search: %w", redactKey(err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, decodeError(resp.Body)
+		return nil, decodeError(resp.Body, resp.StatusCode)
 	}
 	return decodeISR(resp.Body)
 }
+
+// Custom search JSON API pricing, see
+// https://developers.google.com/custom-search/v1/overview#pricing
+const (
+	// FreeQueriesPerDay is the number of queries per day that are not billed.
+	FreeQueriesPerDay = 100
+	// PricePer1000 is the price in USD of 1000 queries past the free ones.
+	PricePer1000 = 5.0
+)
+
+// EstimateCost returns the price in USD of issuing n queries in a day
+// in which used queries were already spent.
+func EstimateCost(n, used int) float64 {
+	billable := n
+	if free := FreeQueriesPerDay - used; free > 0 {
+		billable -= free
+	}
+	if billable <= 0 {
+		return 0
+	}
+	return float64(billable) * PricePer1000 / 1000
+}
@@ -1,8 +1,15 @@
 package google
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 )
 
 var gsiResponse = `{
@@ -81,3 +88,149 @@ func TestDecodeISR(t *testing.T) {
 		t.Fatalf("unexpected items count: %d", len(items))
 	}
 }
+
+func TestEstimateCost(t *testing.T) {
+	tt := []struct {
+		n, used int
+		cost    float64
+	}{
+		{0, 0, 0},
+		{100, 0, 0},
+		{1100, 0, 5},
+		{1000, 100, 5},
+		{10, 200, 0.05},
+	}
+	for _, v := range tt {
+		if got := EstimateCost(v.n, v.used); got != v.cost {
+			t.Errorf("EstimateCost(%d, %d): want %v, got %v", v.n, v.used, v.cost, got)
+		}
+	}
+}
+
+func TestRedactKey(t *testing.T) {
+	err := &url.Error{
+		Op:  "Get",
+		URL: baseURL + "?key=secret&q=cats",
+		Err: errors.New("no such host"),
+	}
+	s := redactKey(err).Error()
+	if strings.Contains(s, "secret") {
+		t.Fatalf("key not redacted: %s", s)
+	}
+	if !strings.Contains(s, "q=cats") {
+		t.Fatalf("query missing: %s", s)
+	}
+}
+
+var gsiErrorResponse = `{
+  "error": {
+    "code": 403,
+    "message": "Request throttled due to daily limit being reached.",
+    "errors": [
+      {
+        "message": "Request throttled due to daily limit being reached.",
+        "domain": "usageLimits",
+        "reason": "dailyLimitExceeded"
+      }
+    ],
+    "status": "RESOURCE_EXHAUSTED"
+  }
+}
+`
+
+func TestDecodeError(t *testing.T) {
+	err := decodeError(strings.NewReader(gsiErrorResponse), 403)
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("unexpected error type: %T", err)
+	}
+	if e.Reason != "dailyLimitExceeded" || !e.Quota() {
+		t.Fatalf("unexpected error: %+v", e)
+	}
+}
+
+func TestErrorAuth(t *testing.T) {
+	tests := []struct {
+		err  *Error
+		auth bool
+	}{
+		{&Error{StatusCode: 400, Reason: "keyInvalid"}, true},
+		{&Error{StatusCode: 403, Reason: "accessNotConfigured"}, true},
+		{&Error{StatusCode: 403, Reason: "dailyLimitExceeded"}, false},
+		{&Error{StatusCode: 401}, true},
+		{&Error{StatusCode: 500, Reason: "backendError"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Auth(); got != tt.auth {
+			t.Errorf("%+v: got auth %v, want %v", tt.err, got, tt.auth)
+		}
+	}
+}
+
+func TestSearchImages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query(); q.Get("q") != "cats" || q.Get("searchType") != "image" || q.Get("imgType") != ImgTypePhoto {
+			t.Errorf("unexpected query: %v", q)
+		}
+		io.WriteString(w, gsiResponse)
+	}))
+	defer srv.Close()
+
+	c := NewSC("key", "cx")
+	c.BaseURL = srv.URL
+	items, err := c.SearchImages(context.Background(), "cats", FilterImgType(ImgTypePhoto))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Image.Width != 2043 {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+}
+
+func TestParseDateRestrict(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Duration
+		ok   bool
+	}{
+		{"d7", 7 * 24 * time.Hour, true},
+		{"w2", 14 * 24 * time.Hour, true},
+		{"m1", 30 * 24 * time.Hour, true},
+		{"y1", 365 * 24 * time.Hour, true},
+		{"", 0, false},
+		{"d", 0, false},
+		{"d0", 0, false},
+		{"x3", 0, false},
+		{"7d", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseDateRestrict(tt.s)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("%q: got %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestFilterDateRestrict(t *testing.T) {
+	v := url.Values{}
+	FilterDateRestrict("w2")(v)
+	if got := v.Get("dateRestrict"); got != "w2" {
+		t.Errorf("got dateRestrict %q, want w2", got)
+	}
+	FilterDateRestrict("")(v)
+	if v.Has("dateRestrict") {
+		t.Errorf("got dateRestrict %q, want none", v.Get("dateRestrict"))
+	}
+}
+
+func TestFilterLanguage(t *testing.T) {
+	v := url.Values{}
+	FilterLanguage("fr")(v)
+	if got := v.Get("hl"); got != "fr" {
+		t.Errorf("got hl %q, want fr", got)
+	}
+	FilterLanguage("und")(v)
+	if v.Has("hl") {
+		t.Errorf("got hl %q, want none", v.Get("hl"))
+	}
+}
@@ -0,0 +1,77 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSheetsValues(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sheet id/values/Words list!A2:C" || r.URL.Query().Get("key") != "key" {
+			t.Errorf("unexpected request: %v", r.URL)
+		}
+		io.WriteString(w, `{"range": "'Words list'!A2:C4", "majorDimension": "ROWS", "values": [["1", "cat"], [], ["3", "dog", "x"]]}`)
+	}))
+	defer srv.Close()
+
+	c := &Sheets{Key: "key", BaseURL: srv.URL}
+	vr, err := c.Values(context.Background(), "sheet id", "Words list!A2:C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vr.Values) != 3 || len(vr.Values[1]) != 0 || vr.Values[2][1] != "dog" {
+		t.Fatalf("unexpected values: %+v", vr.Values)
+	}
+}
+
+func TestSheetsUpdate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/id/values:batchUpdate" {
+			t.Errorf("unexpected request: %s %v", r.Method, r.URL)
+		}
+		var body struct {
+			ValueInputOption string        `json:"valueInputOption"`
+			Data             []*ValueRange `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.ValueInputOption != "RAW" || len(body.Data) != 2 || body.Data[1].Range != "D4" {
+			t.Errorf("unexpected body: %+v", body)
+		}
+		io.WriteString(w, `{"spreadsheetId": "id", "totalUpdatedCells": 2}`)
+	}))
+	defer srv.Close()
+
+	c := &Sheets{BaseURL: srv.URL}
+	n, err := c.Update(context.Background(), "id", []*ValueRange{
+		{Range: "D2", Values: [][]string{{"https://example.com/cat.jpg"}}},
+		{Range: "D4", Values: [][]string{{"https://example.com/dog.jpg"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("got %d updated cells, want 2", n)
+	}
+}
+
+func TestSheetsError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"error": {"code": 403, "message": "The caller does not have permission", "status": "PERMISSION_DENIED"}}`)
+	}))
+	defer srv.Close()
+
+	c := &Sheets{Key: "key", BaseURL: srv.URL}
+	_, err := c.Values(context.Background(), "id", "A:Z")
+	var e *Error
+	if !errors.As(err, &e) || !e.Auth() {
+		t.Fatalf("got %v, want an auth error", err)
+	}
+}
@@ -0,0 +1,103 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Translate is a Cloud Translation API client, authenticated with an
+// API key for which the API is enabled.
+type Translate struct {
+	// Authentication key.
+	Key string
+	// HTTP client used to perform the requests. If nil, a default
+	// client is used.
+	Client *http.Client
+	// Base URL of the translation API. If empty, the google one is
+	// used; mostly useful for testing.
+	BaseURL string
+}
+
+const translateBaseURL = "https://translation.googleapis.com/language/translate/v2"
+
+func (c *Translate) do(ctx context.Context, path string, v url.Values, dst any) error {
+	if c.Key == "" {
+		return fmt.Errorf("translation client key missing")
+	}
+	base := c.BaseURL
+	if base == "" {
+		base = translateBaseURL
+	}
+	v.Set("key", c.Key)
+	req, err := http.NewRequestWithContext(ctx, "POST", base+path, strings.NewReader(v.Encode()))
+	if err != nil {
+		return fmt.Errorf("unable to build translation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	hc := c.Client
+	if hc == nil {
+		hc = client
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to contact google translate: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return decodeError(resp.Body, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
+		return fmt.Errorf("unable to decode response: %w", err)
+	}
+	return nil
+}
+
+// Text translates text to the target language, such as en, from the
+// source one, detected if empty.
+func (c *Translate) Text(ctx context.Context, text, source, target string) (string, error) {
+	v := url.Values{}
+	v.Set("q", text)
+	v.Set("target", target)
+	if source != "" {
+		v.Set("source", source)
+	}
+	v.Set("format", "text")
+	var res struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText string `json:"translatedText"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, "", v, &res); err != nil {
+		return "", err
+	}
+	if len(res.Data.Translations) == 0 {
+		return "", fmt.Errorf("no translation returned")
+	}
+	return res.Data.Translations[0].TranslatedText, nil
+}
+
+// Detect returns the language of text, such as en, or und if it is
+// undetermined.
+func (c *Translate) Detect(ctx context.Context, text string) (string, error) {
+	var res struct {
+		Data struct {
+			Detections [][]struct {
+				Language string `json:"language"`
+			} `json:"detections"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, "/detect", url.Values{"q": {text}}, &res); err != nil {
+		return "", err
+	}
+	if len(res.Data.Detections) == 0 || len(res.Data.Detections[0]) == 0 {
+		return "", fmt.Errorf("no detection returned")
+	}
+	return res.Data.Detections[0][0].Language, nil
+}
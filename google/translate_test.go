@@ -0,0 +1,63 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTranslateText(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.FormValue("key") != "key" || r.FormValue("q") != "chat noir" || r.FormValue("target") != "en" || r.FormValue("source") != "" {
+			t.Errorf("unexpected request: %s %v", r.Method, r.Form)
+		}
+		io.WriteString(w, `{"data": {"translations": [{"translatedText": "black cat", "detectedSourceLanguage": "fr"}]}}`)
+	}))
+	defer srv.Close()
+
+	c := &Translate{Key: "key", BaseURL: srv.URL}
+	got, err := c.Text(context.Background(), "chat noir", "", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "black cat" {
+		t.Fatalf("got %q, want black cat", got)
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"error": {"code": 403, "message": "Cloud Translation API has not been used", "errors": [{"reason": "accessNotConfigured"}]}}`)
+	}))
+	defer srv.Close()
+
+	c := &Translate{Key: "key", BaseURL: srv.URL}
+	_, err := c.Text(context.Background(), "chat", "fr", "en")
+	var gerr *Error
+	if !errors.As(err, &gerr) || !gerr.Auth() {
+		t.Fatalf("got %v, want an auth error", err)
+	}
+}
+
+func TestTranslateDetect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/detect" || r.FormValue("q") != "chat noir" {
+			t.Errorf("unexpected request: %v %v", r.URL, r.Form)
+		}
+		io.WriteString(w, `{"data": {"detections": [[{"language": "fr", "isReliable": false, "confidence": 0.9}]]}}`)
+	}))
+	defer srv.Close()
+
+	c := &Translate{Key: "key", BaseURL: srv.URL}
+	got, err := c.Detect(context.Background(), "chat noir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "fr" {
+		t.Fatalf("got %q, want fr", got)
+	}
+}
@@ -0,0 +1,5 @@
+// Package imagesearch contains the gRPC ImageSearch service
+// definitions, generated from imagesearch.proto.
+package imagesearch
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative imagesearch.proto
@@ -0,0 +1,201 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: imagesearch.proto
+
+package imagesearch
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	ImageSearch_Search_FullMethodName = "/dic.imagesearch.v1.ImageSearch/Search"
+	ImageSearch_Batch_FullMethodName  = "/dic.imagesearch.v1.ImageSearch/Batch"
+)
+
+// ImageSearchClient is the client API for ImageSearch service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ImageSearch exposes the dic search pipeline. Searches share the
+// server cache.
+type ImageSearchClient interface {
+	// Search returns the link of the first image found for a query.
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// Batch appends an image link to each record streamed by the
+	// client, searching for the word in the selected column. The
+	// enriched records are returned in the same order once the stream
+	// is closed; records that could not be enriched are dropped and
+	// accounted in the summary.
+	Batch(ctx context.Context, opts ...grpc.CallOption) (ImageSearch_BatchClient, error)
+}
+
+type imageSearchClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewImageSearchClient(cc grpc.ClientConnInterface) ImageSearchClient {
+	return &imageSearchClient{cc}
+}
+
+func (c *imageSearchClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, ImageSearch_Search_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageSearchClient) Batch(ctx context.Context, opts ...grpc.CallOption) (ImageSearch_BatchClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ImageSearch_ServiceDesc.Streams[0], ImageSearch_Batch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &imageSearchBatchClient{ClientStream: stream}
+	return x, nil
+}
+
+type ImageSearch_BatchClient interface {
+	Send(*BatchRequest) error
+	CloseAndRecv() (*BatchResponse, error)
+	grpc.ClientStream
+}
+
+type imageSearchBatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *imageSearchBatchClient) Send(m *BatchRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *imageSearchBatchClient) CloseAndRecv() (*BatchResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(BatchResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ImageSearchServer is the server API for ImageSearch service.
+// All implementations must embed UnimplementedImageSearchServer
+// for forward compatibility
+//
+// ImageSearch exposes the dic search pipeline. Searches share the
+// server cache.
+type ImageSearchServer interface {
+	// Search returns the link of the first image found for a query.
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// Batch appends an image link to each record streamed by the
+	// client, searching for the word in the selected column. The
+	// enriched records are returned in the same order once the stream
+	// is closed; records that could not be enriched are dropped and
+	// accounted in the summary.
+	Batch(ImageSearch_BatchServer) error
+	mustEmbedUnimplementedImageSearchServer()
+}
+
+// UnimplementedImageSearchServer must be embedded to have forward compatible implementations.
+type UnimplementedImageSearchServer struct {
+}
+
+func (UnimplementedImageSearchServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedImageSearchServer) Batch(ImageSearch_BatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Batch not implemented")
+}
+func (UnimplementedImageSearchServer) mustEmbedUnimplementedImageSearchServer() {}
+
+// UnsafeImageSearchServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ImageSearchServer will
+// result in compilation errors.
+type UnsafeImageSearchServer interface {
+	mustEmbedUnimplementedImageSearchServer()
+}
+
+func RegisterImageSearchServer(s grpc.ServiceRegistrar, srv ImageSearchServer) {
+	s.RegisterService(&ImageSearch_ServiceDesc, srv)
+}
+
+func _ImageSearch_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageSearchServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageSearch_Search_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageSearchServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageSearch_Batch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ImageSearchServer).Batch(&imageSearchBatchServer{ServerStream: stream})
+}
+
+type ImageSearch_BatchServer interface {
+	SendAndClose(*BatchResponse) error
+	Recv() (*BatchRequest, error)
+	grpc.ServerStream
+}
+
+type imageSearchBatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *imageSearchBatchServer) SendAndClose(m *BatchResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *imageSearchBatchServer) Recv() (*BatchRequest, error) {
+	m := new(BatchRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ImageSearch_ServiceDesc is the grpc.ServiceDesc for ImageSearch service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ImageSearch_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dic.imagesearch.v1.ImageSearch",
+	HandlerType: (*ImageSearchServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Search",
+			Handler:    _ImageSearch_Search_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Batch",
+			Handler:       _ImageSearch_Batch_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "imagesearch.proto",
+}
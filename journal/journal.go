@@ -0,0 +1,106 @@
+// Package journal records every call made to a search provider in an
+// append-only file of JSON lines.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+)
+
+// Call statuses.
+const (
+	StatusOK        = "ok"
+	StatusNoResults = "no_results"
+	StatusError     = "error"
+)
+
+// Entry describes a provider call.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Query    string    `json:"query"`
+	Provider string    `json:"provider"`
+	Status   string    `json:"status"`
+	// Link is the result that was selected, if any.
+	Link  string `json:"link,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Items are all the results returned by the provider.
+	Items []*google.ISR `json:"items,omitempty"`
+	// Term is the term Query was searched for, if other than Query,
+	// such as before it was normalized.
+	Term string `json:"term,omitempty"`
+}
+
+// Writer appends entries to a journal file. It is safe for concurrent
+// use. Initialize it using Open.
+type Writer struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Open opens the journal at path for appending, creating it if needed.
+func Open(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open journal: %w", err)
+	}
+	return &Writer{f: f}, nil
+}
+
+// Append writes e to the journal and syncs it to disk, so that no
+// call goes unaccounted if the process dies.
+func (w *Writer) Append(e *Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("unable to encode journal entry: %w", err)
+	}
+	b = append(b, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.f.Write(b); err != nil {
+		return fmt.Errorf("unable to write journal entry: %w", err)
+	}
+	if err := w.f.Sync(); err != nil {
+		return fmt.Errorf("unable to sync journal: %w", err)
+	}
+	return nil
+}
+
+// Close closes the journal file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+// Read decodes the entries from r, calling f for each of them in
+// order. A truncated last line, which is left by a process killed
+// while writing, is ignored.
+func Read(r io.Reader, f func(*Entry) error) error {
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		b, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Either empty or partial.
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read journal: %w", err)
+		}
+		var e Entry
+		if err := json.Unmarshal(b, &e); err != nil {
+			return fmt.Errorf("unable to decode journal line %d: %w", line, err)
+		}
+		if err := f(&e); err != nil {
+			return err
+		}
+	}
+}
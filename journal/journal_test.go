@@ -0,0 +1,52 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+)
+
+func TestAppendRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.log")
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []*Entry{
+		{Time: time.Now(), Query: "cats", Provider: "google", Status: StatusOK, Link: "a", Items: []*google.ISR{{Link: "a"}}},
+		{Time: time.Now(), Query: "dogs", Provider: "google", Status: StatusError, Error: "quota"},
+	}
+	for _, e := range entries {
+		if err := w.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	// Simulate a process killed while writing.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"time":`)
+	f.Close()
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []*Entry
+	if err := Read(f, func(e *Entry) error {
+		got = append(got, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Query != "cats" || got[1].Error != "quota" {
+		t.Fatalf("unexpected entries: %+v", got)
+	}
+	if len(got[0].Items) != 1 {
+		t.Fatalf("items not preserved: %+v", got[0])
+	}
+}
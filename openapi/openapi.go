@@ -0,0 +1,12 @@
+// Package openapi contains the OpenAPI document of the HTTP API served
+// by "dic serve", and a client generated from it.
+package openapi
+
+import _ "embed"
+
+//go:generate go run github.com/oapi-codegen/oapi-codegen/v2/cmd/oapi-codegen@v2.4.1 -config config.yaml openapi.yaml
+
+// Spec is the OpenAPI document, in YAML.
+//
+//go:embed openapi.yaml
+var Spec []byte
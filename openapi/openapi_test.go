@@ -0,0 +1,55 @@
+package openapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSpec(t *testing.T) {
+	var doc struct {
+		OpenAPI string                 `yaml:"openapi"`
+		Paths   map[string]interface{} `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(Spec, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI == "" || len(doc.Paths) == 0 {
+		t.Fatalf("unexpected document: %+v", doc)
+	}
+}
+
+func TestSearchImage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/search" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		var req SearchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if req.Query != "cats" || req.Type == nil || *req.Type != Photo {
+			t.Errorf("unexpected request body: %+v", req)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&SearchResponse{Query: req.Query, Link: "http://a/cat.jpg"})
+	}))
+	defer srv.Close()
+
+	c, err := NewClientWithResponses(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ := Photo
+	res, err := c.SearchImageWithResponse(context.Background(), SearchImageJSONRequestBody{Query: "cats", Type: &typ})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.JSON200 == nil || res.JSON200.Link != "http://a/cat.jpg" {
+		t.Fatalf("unexpected response: %d %s", res.StatusCode(), res.Body)
+	}
+}
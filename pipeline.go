@@ -0,0 +1,180 @@
+package dic
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/discursive-image/dic/google"
+)
+
+// Request is the lookup of the image of a term, passed through the
+// stages of a pipeline.
+type Request struct {
+	// Row is the number of the record in the input, from 1, and Record
+	// the record, to which the link found is appended.
+	Row    int
+	Record []string
+	// Term is the term looked up, as read.
+	Term string
+	// Key is the key of the results of Term, which the stages may
+	// rewrite, such as normalized. It is the text searched.
+	Key string
+	// Fields are appended to Record before the link, such as by the
+	// stages recording how Term was looked up.
+	Fields []string
+	// Searched is set by Cached when a key was not cached, and Results
+	// to the results of the next handler then.
+	Searched bool
+	Results  []*google.ISR
+	// Value is the state of the application for the lookup, if any,
+	// shared by its stages.
+	Value any
+}
+
+// Handler returns the images found for r, the one used first.
+type Handler func(ctx context.Context, r *Request) ([]*google.ISR, error)
+
+// Stage is a step of a pipeline, as a middleware: it returns a handler
+// calling next, or not, such as when the image is cached already.
+type Stage func(next Handler) Handler
+
+// Chain returns the handler calling stages in order, then h.
+func Chain(h Handler, stages ...Stage) Handler {
+	for i := len(stages) - 1; i >= 0; i-- {
+		h = stages[i](h)
+	}
+	return h
+}
+
+// Normalize returns a stage rewriting the keys with f, such as
+// strings.ToLower.
+func Normalize(f func(string) string) Stage {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, r *Request) ([]*google.ISR, error) {
+			r.Key = f(r.Key)
+			return next(ctx, r)
+		}
+	}
+}
+
+// Serialized returns a stage passing the lookups of a key to the next
+// handler one at a time, so that concurrent lookups of a key search it
+// once, when followed by Cached.
+func Serialized() Stage {
+	type keyLock struct {
+		sync.Mutex
+		n int // lookups holding or waiting for the lock.
+	}
+	var mu sync.Mutex
+	locks := make(map[string]*keyLock)
+	return func(next Handler) Handler {
+		return func(ctx context.Context, r *Request) ([]*google.ISR, error) {
+			k := r.Key
+			mu.Lock()
+			l, ok := locks[k]
+			if !ok {
+				l = &keyLock{}
+				locks[k] = l
+			}
+			l.n++
+			mu.Unlock()
+			l.Lock()
+			defer func() {
+				l.Unlock()
+				mu.Lock()
+				if l.n--; l.n == 0 {
+					delete(locks, k)
+				}
+				mu.Unlock()
+			}()
+			return next(ctx, r)
+		}
+	}
+}
+
+// Cached returns a stage serving the images of the keys cached by c,
+// and caching the results of the next handler otherwise. Returns
+// ErrDeadLinks if none of them is left, as when c.Unique is set.
+func Cached(c *Cache) Stage {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, r *Request) ([]*google.ISR, error) {
+			if isr, ok := c.Next(r.Key); ok {
+				return []*google.ISR{isr}, nil
+			}
+			items, err := next(ctx, r)
+			r.Searched, r.Results = true, items
+			if err != nil {
+				return nil, err
+			}
+			c.Set(r.Key, items)
+			isr, ok := c.Next(r.Key)
+			if !ok {
+				return nil, ErrDeadLinks
+			}
+			return []*google.ISR{isr}, nil
+		}
+	}
+}
+
+// Lookup looks up the image of the column field of r.Record with h,
+// appending r.Fields and its link to r.Record.
+func Lookup(ctx context.Context, h Handler, r *Request, column int) error {
+	if column < 0 || column >= len(r.Record) {
+		return fmt.Errorf("tried to access column %d out of %d", column, len(r.Record))
+	}
+	r.Term, r.Key = r.Record[column], r.Record[column]
+	items, err := h(ctx, r)
+	if err != nil {
+		return err
+	}
+	r.Record = append(append(r.Record, r.Fields...), items[0].Link)
+	return nil
+}
+
+// Validate returns a stage checking the images of the next handler,
+// such as Cached(c), with check, which reports whether the image of a
+// key may be returned, along with the image returned in its place, such
+// as with an upgraded link. The next handler is called again while its
+// images are rejected, until one is accepted, or ErrDeadLinks is
+// returned once one comes back. The verdicts are remembered by c, each
+// image of a key being checked once, without holding its lock.
+func Validate(c *Cache, check func(k string, isr *google.ISR) (*google.ISR, bool)) Stage {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, r *Request) ([]*google.ISR, error) {
+			seen := make(map[string]bool)
+			for {
+				items, err := next(ctx, r)
+				if err != nil {
+					return nil, err
+				}
+				isr := items[0]
+				if seen[isr.Link] {
+					return nil, ErrDeadLinks
+				}
+				seen[isr.Link] = true
+				v, ok := c.verdict(r.Key, isr.Link)
+				if !ok {
+					v.isr, v.ok = check(r.Key, isr)
+					c.setVerdict(r.Key, isr.Link, v)
+				}
+				if v.ok {
+					return []*google.ISR{v.isr}, nil
+				}
+			}
+		}
+	}
+}
+
+// Search returns the handler searching the keys with s, restricted by
+// filters. Returns ErrNoResults if there are none.
+func Search(s Searcher, filters ...func(url.Values)) Handler {
+	return func(ctx context.Context, r *Request) ([]*google.ISR, error) {
+		items, err := s.SearchImages(ctx, r.Key, filters...)
+		if err == nil && len(items) == 0 {
+			err = ErrNoResults
+		}
+		return items, err
+	}
+}
@@ -0,0 +1,158 @@
+package dic
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/discursive-image/dic/google"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	stage := func(name string) Stage {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, r *Request) ([]*google.ISR, error) {
+				calls = append(calls, name)
+				return next(ctx, r)
+			}
+		}
+	}
+	h := Chain(func(ctx context.Context, r *Request) ([]*google.ISR, error) {
+		calls = append(calls, "search "+r.Key)
+		return results("https://img/1"), nil
+	}, stage("a"), Normalize(strings.ToUpper), stage("b"))
+	if _, err := h(context.Background(), &Request{Term: "cat", Key: "cat"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(calls, ","); got != "a,b,search CAT" {
+		t.Errorf("got calls %q, want a,b,search CAT", got)
+	}
+}
+
+func TestCachedStage(t *testing.T) {
+	s := &fakeSearcher{searched: make(map[string]int)}
+	h := Chain(Search(s), Cached(&Cache{}))
+	var links []string
+	for _, k := range []string{"cat", "cat", "cat"} {
+		items, err := h(context.Background(), &Request{Term: k, Key: k})
+		if err != nil {
+			t.Fatal(err)
+		}
+		links = append(links, items[0].Link)
+	}
+	if got, want := strings.Join(links, " "), "https://img/cat/1 https://img/cat/2 https://img/cat/1"; got != want {
+		t.Errorf("got links %q, want %q", got, want)
+	}
+	if n := s.searched["cat"]; n != 1 {
+		t.Errorf("cat searched %d times, want 1", n)
+	}
+	if _, err := h(context.Background(), &Request{Term: "none", Key: "none"}); !errors.Is(err, ErrNoResults) {
+		t.Errorf("got error %v, want %v", err, ErrNoResults)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	s := &fakeSearcher{searched: make(map[string]int)}
+	field := func(next Handler) Handler {
+		return func(ctx context.Context, r *Request) ([]*google.ISR, error) {
+			r.Fields = append(r.Fields, strings.ToUpper(r.Key))
+			return next(ctx, r)
+		}
+	}
+	h := Options{Searcher: s, Stages: []Stage{field}}.Handler()
+	r := &Request{Record: []string{"1", "cat"}}
+	if err := Lookup(context.Background(), h, r, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(r.Record, ","), "1,cat,CAT,https://img/cat/1"; got != want {
+		t.Errorf("got record %q, want %q", got, want)
+	}
+	if !r.Searched || len(r.Results) != 2 {
+		t.Errorf("got searched %v with %d results, want 2", r.Searched, len(r.Results))
+	}
+	r = &Request{Record: []string{"2", "cat"}}
+	if err := Lookup(context.Background(), h, r, 1); err != nil || r.Searched {
+		t.Errorf("got error %v, searched %v, want a cached result", err, r.Searched)
+	}
+	if err := Lookup(context.Background(), h, &Request{Record: []string{"3"}}, 1); err == nil {
+		t.Error("got no error for a column out of the record")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := &Cache{}
+	c.Set("k", results("a", "b", "c"))
+	c.Set("dead", results("b"))
+	checked := make(map[string]int)
+	h := Chain(func(ctx context.Context, r *Request) ([]*google.ISR, error) {
+		t.Fatalf("searched %s", r.Key)
+		return nil, nil
+	}, Validate(c, func(k string, isr *google.ISR) (*google.ISR, bool) {
+		checked[k+" "+isr.Link]++
+		return isr, isr.Link != "b"
+	}), Cached(c))
+	var got []string
+	for i := 0; i < 4; i++ {
+		items, err := h(context.Background(), &Request{Term: "k", Key: "k"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, items[0].Link)
+	}
+	if want := "a c a c"; strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, " "), want)
+	}
+	if _, err := h(context.Background(), &Request{Term: "dead", Key: "dead"}); !errors.Is(err, ErrDeadLinks) {
+		t.Errorf("got error %v, want %v", err, ErrDeadLinks)
+	}
+	for k, n := range checked {
+		if n != 1 {
+			t.Errorf("%s checked %d times, want 1", k, n)
+		}
+	}
+	if len(checked) != 4 {
+		t.Errorf("got %d checks, want 4", len(checked))
+	}
+}
+
+func TestValidateUnlocked(t *testing.T) {
+	c := &Cache{}
+	c.Set("slow", results("a"))
+	c.Set("k", results("b"))
+	release := make(chan struct{})
+	h := Chain(Search(&fakeSearcher{searched: make(map[string]int)}), Validate(c, func(k string, isr *google.ISR) (*google.ISR, bool) {
+		if k == "slow" {
+			<-release
+		}
+		return isr, true
+	}), Cached(c))
+	done := make(chan error, 1)
+	go func() {
+		_, err := h(context.Background(), &Request{Term: "slow", Key: "slow"})
+		done <- err
+	}()
+	got := make(chan string, 1)
+	go func() {
+		items, err := h(context.Background(), &Request{Term: "k", Key: "k"})
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		got <- items[0].Link
+	}()
+	select {
+	case link := <-got:
+		if link != "b" {
+			t.Errorf("got %s, want b", link)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lookup blocked by the check of another key")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
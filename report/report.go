@@ -0,0 +1,124 @@
+// Package report sends unexpected failures to error reporting services,
+// grouping them by error type and rate limiting each group.
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Event is a reported failure.
+type Event struct {
+	Time    time.Time         `json:"time"`
+	Group   string            `json:"group"`
+	Message string            `json:"message"`
+	Fatal   bool              `json:"fatal"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	// Number of events of the same group that were not reported due
+	// to rate limiting since the previous one.
+	Suppressed int `json:"suppressed"`
+}
+
+// Sink delivers events to a reporting service.
+type Sink interface {
+	Send(ctx context.Context, e *Event) error
+}
+
+type group struct {
+	last       time.Time
+	suppressed int
+}
+
+// Reporter groups errors by type and forwards at most one event per
+// group every Interval to its sinks. Initialize it using New.
+type Reporter struct {
+	Sinks    []Sink
+	Interval time.Duration
+	// Timeout of each delivery.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	groups map[string]*group
+	now    func() time.Time
+}
+
+// New returns a reporter delivering to sinks.
+func New(interval time.Duration, sinks ...Sink) *Reporter {
+	return &Reporter{
+		Sinks:    sinks,
+		Interval: interval,
+		Timeout:  5 * time.Second,
+		groups:   make(map[string]*group),
+		now:      time.Now,
+	}
+}
+
+// Group returns the grouping key of err, which is the type of the
+// innermost wrapped error.
+func Group(err error) string {
+	for {
+		u := errors.Unwrap(err)
+		if u == nil {
+			return fmt.Sprintf("%T", err)
+		}
+		err = u
+	}
+}
+
+// allow reports whether an event of group g may be delivered now,
+// returning the number of events suppressed before it.
+func (r *Reporter) allow(g string) (bool, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	gr, ok := r.groups[g]
+	if !ok {
+		gr = &group{}
+		r.groups[g] = gr
+	}
+	if ok && now.Sub(gr.last) < r.Interval {
+		gr.suppressed++
+		return false, 0
+	}
+	n := gr.suppressed
+	gr.last = now
+	gr.suppressed = 0
+	return true, n
+}
+
+// Report delivers err to the sinks unless its group was reported
+// less than Interval ago. Delivery is synchronous; the returned error
+// joins the failures of the sinks. A nil Reporter does nothing.
+func (r *Reporter) Report(err error, fatal bool, tags map[string]string) error {
+	if r == nil || err == nil {
+		return nil
+	}
+	g := Group(err)
+	ok, suppressed := r.allow(g)
+	if !ok {
+		return nil
+	}
+	e := &Event{
+		Time:       r.now(),
+		Group:      g,
+		Message:    err.Error(),
+		Fatal:      fatal,
+		Tags:       tags,
+		Suppressed: suppressed,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+	defer cancel()
+
+	var errs []error
+	for _, s := range r.Sinks {
+		if err := s.Send(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
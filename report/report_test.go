@@ -0,0 +1,65 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+type memSink struct {
+	events []*Event
+}
+
+func (s *memSink) Send(_ context.Context, e *Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func TestGroup(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", os.ErrNotExist)
+	if g := Group(err); g != "*errors.errorString" {
+		t.Fatalf("unexpected group: %s", g)
+	}
+	if g := Group(&os.PathError{Op: "open", Err: os.ErrNotExist}); g != "*errors.errorString" {
+		t.Fatalf("unexpected group: %s", g)
+	}
+}
+
+func TestReporterRateLimit(t *testing.T) {
+	s := &memSink{}
+	r := New(time.Minute, s)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		r.Report(errors.New("boom"), false, nil)
+	}
+	if len(s.events) != 1 {
+		t.Fatalf("unexpected events: %d", len(s.events))
+	}
+
+	now = now.Add(2 * time.Minute)
+	r.Report(errors.New("boom"), false, nil)
+	if len(s.events) != 2 {
+		t.Fatalf("unexpected events: %d", len(s.events))
+	}
+	if n := s.events[1].Suppressed; n != 2 {
+		t.Fatalf("unexpected suppressed count: %d", n)
+	}
+}
+
+func TestNewSentry(t *testing.T) {
+	s, err := NewSentry("https://abc@sentry.example.com/prefix/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.storeURL != "https://sentry.example.com/prefix/api/42/store/" || s.key != "abc" {
+		t.Fatalf("unexpected sentry sink: %+v", s)
+	}
+	if _, err := NewSentry("https://sentry.example.com/42"); err == nil {
+		t.Fatalf("expected error on missing key")
+	}
+}
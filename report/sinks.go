@@ -0,0 +1,114 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var client = &http.Client{}
+
+func post(ctx context.Context, u string, header http.Header, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("unable to encode event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("unable to build report request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to deliver report: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unable to deliver report: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Webhook posts events as JSON to URL.
+type Webhook struct {
+	URL string
+}
+
+func (w *Webhook) Send(ctx context.Context, e *Event) error {
+	return post(ctx, w.URL, nil, e)
+}
+
+// Sentry delivers events to a Sentry project. Initialize it using
+// NewSentry.
+type Sentry struct {
+	storeURL string
+	key      string
+}
+
+// NewSentry returns a sink for the project identified by dsn, in the
+// https://<key>@<host>/<project> form.
+func NewSentry(dsn string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sentry dsn: %w", err)
+	}
+	project := strings.Trim(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return nil, fmt.Errorf("invalid sentry dsn: key or project missing")
+	}
+	// Projects hosted under a path have it before the project id.
+	prefix := ""
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	return &Sentry{
+		storeURL: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+		key:      u.User.Username(),
+	}, nil
+}
+
+func eventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Sentry) Send(ctx context.Context, e *Event) error {
+	level := "error"
+	if e.Fatal {
+		level = "fatal"
+	}
+	tags := map[string]string{}
+	for k, v := range e.Tags {
+		tags[k] = v
+	}
+	if e.Suppressed > 0 {
+		tags["suppressed"] = fmt.Sprint(e.Suppressed)
+	}
+	ev := map[string]interface{}{
+		"event_id":    eventID(),
+		"timestamp":   e.Time.UTC().Format("2006-01-02T15:04:05.000Z"),
+		"level":       level,
+		"platform":    "go",
+		"logger":      "dic",
+		"message":     map[string]string{"formatted": e.Message},
+		"fingerprint": []string{e.Group},
+		"tags":        tags,
+		"exception": map[string]interface{}{
+			"values": []map[string]string{{"type": e.Group, "value": e.Message}},
+		},
+	}
+	header := http.Header{}
+	header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=dic/1.0, sentry_key=%s", s.key))
+	return post(ctx, s.storeURL, header, ev)
+}
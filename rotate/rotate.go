@@ -0,0 +1,173 @@
+// Package rotate provides a file writer that rotates its output
+// based on size and age.
+package rotate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is appended to the file name of rotated files.
+const backupTimeFormat = "20060102T150405.000"
+
+// Writer is an io.WriteCloser appending to a file, which is rotated
+// when it grows beyond MaxSize or gets older than MaxAge. Rotated
+// files are renamed by appending their rotation time to the file
+// name. Initialize it using Open.
+type Writer struct {
+	// Path of the file being written.
+	Path string
+	// Size in bytes after which the file is rotated. 0 disables
+	// size based rotation.
+	MaxSize int64
+	// Age after which the file is rotated. 0 disables time based
+	// rotation.
+	MaxAge time.Duration
+	// Number of rotated files to keep. 0 keeps all of them.
+	MaxBackups int
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	created time.Time
+}
+
+// Open returns a Writer appending to the file at path.
+func Open(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*Writer, error) {
+	w := &Writer{
+		Path:       path,
+		MaxSize:    maxSize,
+		MaxAge:     maxAge,
+		MaxBackups: maxBackups,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("unable to open log file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("unable to stat log file: %w", err)
+	}
+	w.f = f
+	w.size = fi.Size()
+	w.created = fi.ModTime()
+	if w.size == 0 {
+		w.created = time.Now()
+	}
+	return nil
+}
+
+// Write appends p to the file, rotating it first if needed.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return 0, os.ErrClosed
+	}
+	if w.due(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// due reports whether writing n more bytes requires a rotation.
+// An empty file is never rotated.
+func (w *Writer) due(n int64) bool {
+	switch {
+	case w.size == 0:
+		return false
+	case w.MaxSize > 0 && w.size+n > w.MaxSize:
+		return true
+	case w.MaxAge > 0 && time.Since(w.created) > w.MaxAge:
+		return true
+	default:
+		return false
+	}
+}
+
+// Rotate forces a rotation of the file.
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate()
+}
+
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("unable to close log file: %w", err)
+	}
+	backup := w.Path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(w.Path, backup); err != nil {
+		return fmt.Errorf("unable to rotate log file: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	return w.prune()
+}
+
+// Backups returns the rotated files, oldest first.
+func (w *Writer) Backups() ([]string, error) {
+	matches, err := filepath.Glob(w.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	backups := matches[:0]
+	prefix := w.Path + "."
+	for _, m := range matches {
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(m, prefix)); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	// The time format sorts lexicographically.
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func (w *Writer) prune() error {
+	if w.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := w.Backups()
+	if err != nil {
+		return fmt.Errorf("unable to list rotated log files: %w", err)
+	}
+	for len(backups) > w.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("unable to remove rotated log file: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Close closes the underlying file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
@@ -0,0 +1,60 @@
+package rotate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriterMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dic.log")
+	w, err := Open(path, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 4; i++ {
+		if _, err := w.Write([]byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+		// Backups are named after the rotation time.
+		time.Sleep(2 * time.Millisecond)
+	}
+	backups, err := w.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("unexpected backups: %v", backups)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "0123456789" {
+		t.Fatalf("unexpected file content: %q", b)
+	}
+}
+
+func TestWriterMaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dic.log")
+	w, err := Open(path, 0, time.Millisecond, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.Write([]byte("a"))
+	time.Sleep(5 * time.Millisecond)
+	w.Write([]byte("b"))
+
+	backups, err := w.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("unexpected backups: %v", backups)
+	}
+}